@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+)
+
+// unconfirmedExpiryDays is how long a new account has to confirm its email before it's removed.
+func unconfirmedExpiryDays() int {
+	return util.GetEnvInt("UNCONFIRMED_EXPIRY_DAYS", 7)
+}
+
+// isExpiredUnconfirmed reports whether the user never confirmed their email within the window.
+func isExpiredUnconfirmed(user *models.User, now time.Time) bool {
+	return user.EmailConfirmedAt == nil && user.CreatedAt.Before(now.AddDate(0, 0, -unconfirmedExpiryDays()))
+}
+
+// CleanupUnconfirmedUsers reminds new users to confirm their email UNCONFIRMED_REMINDER_DAYS
+// after signing up, removes accounts still unconfirmed after UNCONFIRMED_EXPIRY_DAYS and clears
+// email changes left pending for EMAIL_CHANGE_EXPIRY_HOURS.
+func (s *Server) CleanupUnconfirmedUsers(ctx context.Context) error {
+	const batchSize = 100
+	now := time.Now()
+	expiryDays := unconfirmedExpiryDays()
+
+	// latest reminder first, so users who missed earlier ones only get the most recent
+	reminderDays := util.GetEnvInts("UNCONFIRMED_REMINDER_DAYS", []int{1, 3})
+	for step := len(reminderDays) - 1; step >= 0; step-- {
+		days := reminderDays[step]
+		if days >= expiryDays {
+			continue
+		}
+
+		users, err := s.store.GetUsersDueConfirmationReminder(ctx, now.AddDate(0, 0, -days), step, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err := s.sendConfirmationReminder(ctx, user, step+1, expiryDays-days); err != nil {
+				fmt.Printf("Error sending confirmation reminder to user %s: %v\n", user.ID, err)
+			}
+		}
+	}
+
+	expired, err := s.store.GetExpiredUnconfirmedUsers(ctx, now.AddDate(0, 0, -expiryDays), batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range expired {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := s.expireUnconfirmedUser(ctx, user); err != nil && !errors.Is(err, storage.ErrUserConfirmed) {
+			fmt.Printf("Error removing unconfirmed user %s: %v\n", user.ID, err)
+		}
+	}
+
+	expiryHours := util.GetEnvInt("EMAIL_CHANGE_EXPIRY_HOURS", 24)
+	if _, err := s.store.ClearStaleEmailChanges(ctx, now.Add(-time.Duration(expiryHours)*time.Hour)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) sendConfirmationReminder(ctx context.Context, user *models.User, reminders int, expiresInDays int) error {
+	confirmationToken, err := generateToken(user, "email_confirmation")
+	if err != nil {
+		return err
+	}
+
+	locale := user.Locale
+	if locale == "" {
+		locale = i18n.DefaultLocale
+	}
+
+	reminder, err := newTemplateEmail(user.Email, locale, emails.ConfirmEmailReminder, emails.ConfirmEmailReminderData{
+		URL:           fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), confirmationToken),
+		ExpiresInDays: expiresInDays,
+	})
+	if err != nil {
+		return err
+	}
+
+	user.ConfirmationReminders = reminders
+	return s.store.UpdateUser(ctx, user, reminder)
+}
+
+// expireUnconfirmedUser removes an account that never confirmed its email so the address can be
+// used to sign up again. Returns storage.ErrUserConfirmed if the user confirmed in the meantime.
+func (s *Server) expireUnconfirmedUser(ctx context.Context, user *models.User) error {
+	sub, err := s.releaseUserResources(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return s.store.ExpireUnconfirmedUser(ctx, user, userTombstone(models.AuditUserExpired, user, sub))
+}
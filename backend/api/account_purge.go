@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/stripe/stripe-go/v80"
+	"github.com/stripe/stripe-go/v80/subscription"
+)
+
+// accountRecoveryDays is how long a deleted account can be restored before it's purged.
+func accountRecoveryDays() int {
+	return util.GetEnvInt("ACCOUNT_RECOVERY_DAYS", 90)
+}
+
+// PurgeDeletedUsers permanently deletes accounts that were deleted more than
+// ACCOUNT_RECOVERY_DAYS ago. Accounts that fail to purge are retried on the next run.
+func (s *Server) PurgeDeletedUsers(ctx context.Context) error {
+	const batchSize = 100
+	cutoff := time.Now().AddDate(0, 0, -accountRecoveryDays())
+
+	for ctx.Err() == nil {
+		users, err := s.store.GetUsersDeletedBefore(ctx, cutoff, batchSize)
+		if err != nil {
+			return err
+		}
+
+		purged := 0
+		for _, user := range users {
+			if err := s.purgeUser(ctx, user); err != nil {
+				fmt.Printf("Error purging user %s: %v\n", user.ID, err)
+				continue
+			}
+			purged++
+		}
+
+		// stop once the backlog is cleared or only failing accounts are left
+		if len(users) < batchSize || purged == 0 {
+			return nil
+		}
+	}
+
+	return ctx.Err()
+}
+
+// purgeUser cancels the user's subscription and removes their avatar before deleting the account,
+// so a failure part way leaves the account in place to be retried.
+func (s *Server) purgeUser(ctx context.Context, user *models.User) error {
+	sub, err := s.releaseUserResources(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	locale := user.Locale
+	if locale == "" {
+		locale = i18n.DefaultLocale
+	}
+
+	notice, err := newTemplateEmail(user.Email, locale, emails.AccountPurged, emails.AccountDeletionData{RecoveryDays: accountRecoveryDays()})
+	if err != nil {
+		return err
+	}
+
+	tombstone := userTombstone(models.AuditUserPurged, user, sub)
+
+	err = s.store.PurgeUser(ctx, user, tombstone, notice)
+	if errors.Is(err, storage.ErrUserNotDeleted) {
+		// restored since we loaded it
+		return nil
+	}
+	return err
+}
+
+// releaseUserResources cancels the user's paid subscription and deletes their avatar from S3
+// ahead of hard-deleting the account. It returns the subscription, if any.
+func (s *Server) releaseUserResources(ctx context.Context, user *models.User) (*models.Subscription, error) {
+	sub, _ := s.store.GetSubscriptionByUserID(ctx, user.ID)
+	if sub != nil && sub.StripeSubscriptionID != "" && sub.Status != models.SubscriptionCanceled {
+		if _, err := subscription.Cancel(sub.StripeSubscriptionID, &stripe.SubscriptionCancelParams{Params: stripe.Params{Context: ctx}}); err != nil {
+			return nil, fmt.Errorf("canceling subscription: %w", err)
+		}
+	}
+
+	for _, url := range []string{user.AvatarUrl, user.AvatarThumbnailUrl} {
+		if url == "" {
+			continue
+		}
+		if err := util.DeleteFileFromS3(ctx, url); err != nil {
+			return nil, fmt.Errorf("deleting avatar: %w", err)
+		}
+	}
+
+	return sub, nil
+}
+
+// userTombstone records a hard-deleted account without keeping any of its PII.
+func userTombstone(action string, user *models.User, sub *models.Subscription) *models.AuditEvent {
+	emailHash := sha256.Sum256([]byte(strings.ToLower(user.Email)))
+
+	return &models.AuditEvent{
+		Action: action,
+		UserID: &user.ID,
+		Metadata: map[string]any{
+			// lets support confirm an address was removed without keeping it
+			"email_sha256":     hex.EncodeToString(emailHash[:]),
+			"created_at":       user.CreatedAt,
+			"deleted_at":       user.DeletedAt,
+			"had_subscription": sub != nil && sub.Plan != models.PlanFree,
+		},
+	}
+}
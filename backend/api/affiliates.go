@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/middleware"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+)
+
+var affiliateCodePattern = regexp.MustCompile(`^[a-z0-9-]{3,32}$`)
+
+func (s *Server) handleCreateAffiliate(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	if existing, _ := s.store.GetAffiliateByUserID(r.Context(), user.ID); existing != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "you're already an affiliate.", Code: "already_affiliate"})
+	}
+
+	createReq := new(models.CreateAffiliateRequest)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(createReq); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid request.", Code: "invalid_request"})
+		}
+	}
+
+	code := strings.ToLower(strings.TrimSpace(createReq.Code))
+	if code == "" {
+		code, err = generateAffiliateCode()
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+	}
+
+	if !affiliateCodePattern.MatchString(code) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "code must be 3-32 lowercase letters, numbers or dashes.", Code: "invalid_affiliate_code"})
+	}
+
+	if existing, _ := s.store.GetAffiliateByCode(r.Context(), code); existing != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "this code is already taken.", Code: "affiliate_code_taken"})
+	}
+
+	affiliate := &models.Affiliate{
+		UserID:            user.ID,
+		Code:              code,
+		CommissionPercent: util.GetEnvInt("AFFILIATE_COMMISSION_PERCENT", 20),
+		Status:            models.AffiliateActive,
+	}
+
+	if err := s.store.CreateAffiliate(r.Context(), affiliate); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusCreated, Response{Message: "affiliate account created.", Code: "affiliate_created", Data: affiliate})
+}
+
+func (s *Server) handleGetAffiliateDashboard(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	affiliate, err := s.store.GetAffiliateByUserID(r.Context(), user.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "you're not an affiliate yet.", Code: "affiliate_not_found"})
+	}
+
+	stats, err := s.store.GetAffiliateStats(r.Context(), affiliate.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, models.AffiliateDashboardResponse{
+		Code:              affiliate.Code,
+		Link:              affiliateLink(affiliate.Code),
+		CommissionPercent: affiliate.CommissionPercent,
+		Clicks:            stats.Clicks,
+		Signups:           stats.Signups,
+		Conversions:       stats.Conversions,
+		PendingEarnings:   stats.Pending,
+		OwedPayout:        stats.Approved,
+		PaidOut:           stats.Paid,
+	})
+}
+
+// attributeAffiliate links a new user to the affiliate from their affiliate cookie, if any.
+func (s *Server) attributeAffiliate(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	cookie, err := r.Cookie("affiliate")
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "affiliate",
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	affiliate, err := s.store.GetAffiliateByCode(r.Context(), cookie.Value)
+	if err != nil || affiliate.Status != models.AffiliateActive || affiliate.UserID == user.ID {
+		return nil
+	}
+
+	return s.store.CreateAffiliateReferral(r.Context(), &models.AffiliateReferral{
+		AffiliateID: affiliate.ID,
+		UserID:      user.ID,
+	})
+}
+
+// createCommission credits the referring affiliate with a share of a paid invoice, excluding tax.
+func (s *Server) createCommission(ctx context.Context, inv *stripe.Invoice, userID uuid.UUID) error {
+	if inv.AmountPaid <= 0 {
+		return nil
+	}
+
+	referral, err := s.store.GetAffiliateReferralByUserID(ctx, userID)
+	if err != nil {
+		// user wasn't referred
+		return nil
+	}
+
+	affiliate, err := s.store.GetAffiliateByID(ctx, referral.AffiliateID)
+	if err != nil {
+		return err
+	}
+
+	if affiliate.Status != models.AffiliateActive {
+		return nil
+	}
+
+	amount := (inv.AmountPaid - inv.Tax) * int64(affiliate.CommissionPercent) / 100
+	if amount <= 0 {
+		return nil
+	}
+
+	return s.store.CreateCommission(ctx, &models.Commission{
+		AffiliateID:     affiliate.ID,
+		UserID:          userID,
+		StripeInvoiceID: inv.ID,
+		Amount:          amount,
+		Currency:        string(inv.Currency),
+		Status:          models.CommissionPending,
+	})
+}
+
+func generateAffiliateCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// affiliateLink points at the API rather than the app, so the affiliate cookie is set on the
+// domain the signup request goes to before the visitor is sent on to the app.
+func affiliateLink(code string) string {
+	return fmt.Sprintf("%s/r/%s", os.Getenv("API_URL"), code)
+}
+
+// handleAffiliateLink tracks a visit through an affiliate link and redirects to the app. Unknown
+// or inactive codes still get the redirect, just without the cookie.
+func (s *Server) handleAffiliateLink(w http.ResponseWriter, r *http.Request) error {
+	middleware.TrackAffiliate(w, r, s.store, chi.URLParam(r, "code"), "/")
+
+	http.Redirect(w, r, os.Getenv("APP_URL"), http.StatusFound)
+	return nil
+}
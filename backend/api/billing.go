@@ -0,0 +1,627 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+	"github.com/stripe/stripe-go/v80/checkout/session"
+	"github.com/stripe/stripe-go/v80/customer"
+	"github.com/stripe/stripe-go/v80/invoice"
+	"github.com/stripe/stripe-go/v80/subscription"
+	"github.com/stripe/stripe-go/v80/webhook"
+)
+
+func (s *Server) handleStartTrial(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	trialReq := new(models.StartTrialRequest)
+	if err := json.NewDecoder(r.Body).Decode(trialReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "empty body.", Code: "empty_body"})
+	}
+
+	plan, ok := models.Plans[trialReq.Plan]
+	if !ok || plan.ID == models.PlanFree || plan.ID == models.PlanEnterprise {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "plan is not available for a trial.", Code: "invalid_plan"})
+	}
+
+	sub, _ := s.store.GetSubscriptionByUserID(r.Context(), user.ID)
+	if sub == nil {
+		sub = &models.Subscription{UserID: user.ID}
+	}
+
+	if sub.TrialEndsAt != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "your account has already used its free trial.", Code: "trial_already_used"})
+	}
+
+	if sub.Plan != "" && sub.Plan != models.PlanFree {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "your account already has an active plan.", Code: "already_subscribed"})
+	}
+
+	trialEndsAt := time.Now().AddDate(0, 0, util.GetEnvInt("TRIAL_DAYS", 14))
+
+	sub.Plan = plan.ID
+	sub.Status = models.SubscriptionTrialing
+	sub.TrialEndsAt = &trialEndsAt
+
+	if err := s.store.SaveSubscription(r.Context(), sub); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "trial started.", Code: "trial_started", Data: models.NewSubscriptionState(sub)})
+}
+
+func (s *Server) handleCheckout(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	checkoutReq := new(models.CheckoutRequest)
+	if err := json.NewDecoder(r.Body).Decode(checkoutReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "empty body.", Code: "empty_body"})
+	}
+
+	plan, ok := models.Plans[checkoutReq.Plan]
+	if !ok || plan.ID == models.PlanFree || plan.ID == models.PlanEnterprise {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "plan is not available for checkout.", Code: "invalid_plan"})
+	}
+
+	priceID := os.Getenv("STRIPE_PRICE_" + strings.ToUpper(plan.ID))
+	if priceID == "" {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "plan is not available for checkout.", Code: "invalid_plan"})
+	}
+
+	var appliedCoupon *models.Coupon
+	if checkoutReq.Coupon != "" {
+		var apiErr *Error
+		appliedCoupon, apiErr = s.validateCoupon(r.Context(), checkoutReq.Coupon, plan.ID, user.ID)
+		if apiErr != nil {
+			return WriteJSON(w, http.StatusBadRequest, *apiErr)
+		}
+	}
+
+	sub, _ := s.store.GetSubscriptionByUserID(r.Context(), user.ID)
+	if sub == nil {
+		sub = &models.Subscription{UserID: user.ID, Plan: models.PlanFree}
+	}
+
+	if sub.StripeSubscriptionID != "" && sub.Status != models.SubscriptionCanceled {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "your account already has an active plan.", Code: "already_subscribed"})
+	}
+
+	if sub.StripeCustomerID == "" {
+		stripeCustomer, err := customer.New(&stripe.CustomerParams{
+			Params:   stripe.Params{Context: r.Context()},
+			Email:    stripe.String(user.Email),
+			Metadata: map[string]string{"user_id": user.ID.String()},
+		})
+		if err != nil {
+			fmt.Printf("Error creating stripe customer: %v\n", err)
+			return WriteJSON(w, http.StatusBadGateway, Error{Error: "could not start checkout.", Code: "billing_provider_error"})
+		}
+
+		sub.StripeCustomerID = stripeCustomer.ID
+		if err := s.store.SaveSubscription(r.Context(), sub); err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+	}
+
+	metadata := map[string]string{"user_id": user.ID.String(), "plan": plan.ID}
+
+	params := &stripe.CheckoutSessionParams{
+		Params:            stripe.Params{Context: r.Context()},
+		Mode:              stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		Customer:          stripe.String(sub.StripeCustomerID),
+		ClientReferenceID: stripe.String(user.ID.String()),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{Price: stripe.String(priceID), Quantity: stripe.Int64(1)},
+		},
+		SuccessURL: stripe.String(fmt.Sprintf("%s/settings/billing?message=checkout_success", os.Getenv("APP_URL"))),
+		CancelURL:  stripe.String(fmt.Sprintf("%s/settings/billing", os.Getenv("APP_URL"))),
+		Metadata:   metadata,
+	}
+
+	// the coupon is held for this checkout until the session expires, so stripe can't apply it past its limits
+	var reservation *models.CouponRedemption
+	if appliedCoupon != nil {
+		expiresAt := time.Now().Add(checkoutSessionTTL).Truncate(time.Second)
+		reservation = &models.CouponRedemption{CouponID: appliedCoupon.ID, UserID: user.ID, Plan: plan.ID, ExpiresAt: &expiresAt}
+
+		err := s.store.ReserveCoupon(r.Context(), reservation)
+		if errors.Is(err, storage.ErrCouponExhausted) {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "this coupon is no longer available.", Code: "coupon_exhausted"})
+		}
+		if errors.Is(err, storage.ErrCouponAlreadyRedeemed) {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "you've already used this coupon.", Code: "coupon_already_redeemed"})
+		}
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		params.ExpiresAt = stripe.Int64(expiresAt.Unix())
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(appliedCoupon.StripeCouponID)}}
+		metadata["coupon_id"] = appliedCoupon.ID.String()
+	}
+
+	// don't charge until the rest of the trial is used up (stripe needs at least 48 hours)
+	if sub.Status == models.SubscriptionTrialing && sub.TrialEndsAt != nil && time.Until(*sub.TrialEndsAt) > 48*time.Hour {
+		params.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{
+			TrialEnd: stripe.Int64(sub.TrialEndsAt.Unix()),
+		}
+	}
+
+	checkoutSession, err := session.New(params)
+	if err != nil {
+		fmt.Printf("Error creating checkout session: %v\n", err)
+		if reservation != nil {
+			if err := s.store.ReleaseCoupon(r.Context(), reservation); err != nil {
+				fmt.Printf("Error releasing coupon %s for user %s: %v\n", reservation.CouponID, user.ID, err)
+			}
+		}
+		return WriteJSON(w, http.StatusBadGateway, Error{Error: "could not start checkout.", Code: "billing_provider_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "checkout started.", Code: "checkout_started", Data: map[string]string{"url": checkoutSession.URL}})
+}
+
+// checkoutSessionTTL is how long a checkout with a coupon stays open, and so how long the coupon is
+// held for it. Stripe accepts anything from 30 minutes to a day.
+const checkoutSessionTTL = time.Hour
+
+// maxStripeWebhookBytes bounds a webhook body. Events like invoice.paid embed every line item, so
+// it's far above a typical event, and a body over it is refused rather than cut short, which would
+// only fail the signature check.
+const maxStripeWebhookBytes = 1 << 20
+
+func (s *Server) handleStripeWebhook(w http.ResponseWriter, r *http.Request) error {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStripeWebhookBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return WriteJSON(w, http.StatusRequestEntityTooLarge, Error{Error: "request body is too large.", Code: "payload_too_large"})
+		}
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid request.", Code: "invalid_request"})
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), os.Getenv("STRIPE_WEBHOOK_SECRET"), webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid signature.", Code: "invalid_signature"})
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var checkoutSession stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleCheckoutCompleted(r.Context(), &checkoutSession)
+	case "checkout.session.expired":
+		var checkoutSession stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleCheckoutExpired(r.Context(), &checkoutSession)
+	case "invoice.payment_failed":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handlePaymentFailed(r.Context(), &inv)
+	case "invoice.paid":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleInvoicePaid(r.Context(), &inv)
+	case "invoice.finalized", "invoice.voided":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleInvoiceUpdated(r.Context(), &inv)
+	case "customer.subscription.deleted":
+		var stripeSub stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &stripeSub); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleSubscriptionDeleted(r.Context(), &stripeSub)
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+		}
+		err = s.handleChargeRefunded(r.Context(), &charge)
+	}
+
+	if err != nil {
+		fmt.Printf("Error handling stripe event %s: %v\n", event.Type, err)
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, nil)
+}
+
+// handleCheckoutCompleted moves the user onto the purchased plan and records any coupon redemption.
+func (s *Server) handleCheckoutCompleted(ctx context.Context, checkoutSession *stripe.CheckoutSession) error {
+	userID, err := uuid.Parse(checkoutSession.Metadata["user_id"])
+	if err != nil {
+		return nil
+	}
+
+	sub, _ := s.store.GetSubscriptionByUserID(ctx, userID)
+	if sub == nil {
+		sub = &models.Subscription{UserID: userID}
+	}
+
+	sub.Plan = checkoutSession.Metadata["plan"]
+	if checkoutSession.Customer != nil {
+		sub.StripeCustomerID = checkoutSession.Customer.ID
+	}
+	if checkoutSession.Subscription != nil {
+		sub.StripeSubscriptionID = checkoutSession.Subscription.ID
+	}
+
+	// keep trialing until the trial ends, the lifecycle job will then mark it active
+	if sub.Status != models.SubscriptionTrialing || sub.TrialEndsAt == nil || !time.Now().Before(*sub.TrialEndsAt) {
+		sub.Status = models.SubscriptionActive
+	}
+	clearGracePeriod(sub)
+
+	if err := s.store.SaveSubscription(ctx, sub); err != nil {
+		return err
+	}
+
+	couponID, err := uuid.Parse(checkoutSession.Metadata["coupon_id"])
+	if err != nil {
+		return nil
+	}
+
+	err = s.store.RedeemCoupon(ctx, &models.CouponRedemption{
+		CouponID:          couponID,
+		UserID:            userID,
+		Plan:              sub.Plan,
+		CheckoutSessionID: checkoutSession.ID,
+	})
+	if !errors.Is(err, storage.ErrCouponExhausted) && !errors.Is(err, storage.ErrCouponAlreadyRedeemed) {
+		return err
+	}
+
+	// the reservation lapsed or was released before the customer paid and someone else took the
+	// redemption. the discount is already applied, so retrying can't help; record it for review.
+	fmt.Printf("Coupon %s over-redeemed by user %s in checkout %s: %v\n", couponID, userID, checkoutSession.ID, err)
+	return s.store.CreateAuditEvent(ctx, &models.AuditEvent{
+		Action: models.AuditCouponOverused,
+		UserID: &userID,
+		Metadata: map[string]any{
+			"coupon_id":           couponID.String(),
+			"checkout_session_id": checkoutSession.ID,
+			"reason":              err.Error(),
+		},
+	})
+}
+
+// handleCheckoutExpired gives back the coupon reserved for an abandoned checkout.
+func (s *Server) handleCheckoutExpired(ctx context.Context, checkoutSession *stripe.CheckoutSession) error {
+	userID, err := uuid.Parse(checkoutSession.Metadata["user_id"])
+	if err != nil {
+		return nil
+	}
+
+	couponID, err := uuid.Parse(checkoutSession.Metadata["coupon_id"])
+	if err != nil {
+		return nil
+	}
+
+	expiresAt := time.Unix(checkoutSession.ExpiresAt, 0)
+	return s.store.ReleaseCoupon(ctx, &models.CouponRedemption{CouponID: couponID, UserID: userID, ExpiresAt: &expiresAt})
+}
+
+// handlePaymentFailed opens a grace period for the subscription and sends the first dunning email.
+func (s *Server) handlePaymentFailed(ctx context.Context, inv *stripe.Invoice) error {
+	if inv.Customer == nil {
+		return nil
+	}
+
+	sub, err := s.store.GetSubscriptionByStripeCustomerID(ctx, inv.Customer.ID)
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		// a manual invoice or a customer created outside checkout, there's nothing of ours to update
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.syncInvoice(ctx, inv, sub.UserID); err != nil {
+		return err
+	}
+
+	// stripe sends a failure for each of its own retries, the grace period is already open
+	if sub.Status == models.SubscriptionPastDue {
+		return nil
+	}
+
+	now := time.Now()
+	graceEndsAt := now.AddDate(0, 0, util.GetEnvInt("GRACE_PERIOD_DAYS", 14))
+
+	sub.Status = models.SubscriptionPastDue
+	sub.PaymentFailedAt = &now
+	sub.FailedInvoiceID = inv.ID
+	sub.GraceEndsAt = &graceEndsAt
+	sub.DunningStep = 0
+	sub.NextDunningAt = nextDunningAt(now, 0)
+
+	if err := s.store.SaveSubscription(ctx, sub); err != nil {
+		return err
+	}
+
+	return s.sendDunningEmail(ctx, sub)
+}
+
+func (s *Server) handleInvoicePaid(ctx context.Context, inv *stripe.Invoice) error {
+	if inv.Customer == nil {
+		return nil
+	}
+
+	sub, err := s.store.GetSubscriptionByStripeCustomerID(ctx, inv.Customer.ID)
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.syncInvoice(ctx, inv, sub.UserID); err != nil {
+		return err
+	}
+
+	if inv.Subscription != nil && inv.Subscription.ID != "" {
+		sub.StripeSubscriptionID = inv.Subscription.ID
+	}
+
+	if err := s.createCommission(ctx, inv, sub.UserID); err != nil {
+		return err
+	}
+
+	sub.Status = models.SubscriptionActive
+	clearGracePeriod(sub)
+
+	return s.store.SaveSubscription(ctx, sub)
+}
+
+func (s *Server) handleInvoiceUpdated(ctx context.Context, inv *stripe.Invoice) error {
+	if inv.Customer == nil {
+		return nil
+	}
+
+	sub, err := s.store.GetSubscriptionByStripeCustomerID(ctx, inv.Customer.ID)
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.syncInvoice(ctx, inv, sub.UserID)
+}
+
+func (s *Server) handleSubscriptionDeleted(ctx context.Context, stripeSub *stripe.Subscription) error {
+	if stripeSub.Customer == nil {
+		return nil
+	}
+
+	sub, err := s.store.GetSubscriptionByStripeCustomerID(ctx, stripeSub.Customer.ID)
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sub.Plan = models.PlanFree
+	sub.Status = models.SubscriptionCanceled
+	sub.StripeSubscriptionID = ""
+	clearGracePeriod(sub)
+
+	return s.store.SaveSubscription(ctx, sub)
+}
+
+// ProcessSubscriptions sends trial reminders, runs the dunning schedule and downgrades
+// accounts whose trial or grace period has ended.
+func (s *Server) ProcessSubscriptions(ctx context.Context) error {
+	now := time.Now()
+
+	trials, err := s.store.GetSubscriptionsByStatus(ctx, models.SubscriptionTrialing)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range trials {
+		if err := s.processTrial(ctx, sub, now); err != nil {
+			fmt.Printf("Error processing trial for subscription %s: %v\n", sub.ID, err)
+		}
+	}
+
+	pastDue, err := s.store.GetSubscriptionsByStatus(ctx, models.SubscriptionPastDue)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range pastDue {
+		if err := s.processDunning(ctx, sub, now); err != nil {
+			fmt.Printf("Error processing dunning for subscription %s: %v\n", sub.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) processTrial(ctx context.Context, sub *models.Subscription, now time.Time) error {
+	if sub.TrialEndsAt == nil {
+		return nil
+	}
+
+	user, err := s.store.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		return err
+	}
+
+	plan := models.GetPlan(sub.Plan)
+
+	if !now.Before(*sub.TrialEndsAt) {
+		// trial converted to a paid subscription
+		if sub.StripeSubscriptionID != "" {
+			sub.Status = models.SubscriptionActive
+			return s.store.SaveSubscription(ctx, sub)
+		}
+
+		sub.Plan = models.PlanFree
+		sub.Status = models.SubscriptionExpired
+
+		return s.inTx(ctx, func(tx *Server) error {
+			if err := tx.store.SaveSubscription(ctx, sub); err != nil {
+				return err
+			}
+
+			return tx.queueUserTemplate(ctx, user, models.NotificationBilling, emails.TrialEnded, emails.PlanData{Plan: plan.Name, URL: billingURL()})
+		})
+	}
+
+	reminderAt := sub.TrialEndsAt.AddDate(0, 0, -util.GetEnvInt("TRIAL_REMINDER_DAYS", 3))
+	if sub.TrialReminderSentAt != nil || now.Before(reminderAt) || sub.StripeSubscriptionID != "" {
+		return nil
+	}
+
+	sub.TrialReminderSentAt = &now
+
+	return s.inTx(ctx, func(tx *Server) error {
+		if err := tx.store.SaveSubscription(ctx, sub); err != nil {
+			return err
+		}
+
+		return tx.queueUserTemplate(ctx, user, models.NotificationBilling, emails.TrialEnding, emails.TrialEndingData{Plan: plan.Name, EndsAt: *sub.TrialEndsAt, URL: billingURL()})
+	})
+}
+
+func (s *Server) processDunning(ctx context.Context, sub *models.Subscription, now time.Time) error {
+	if sub.GraceEndsAt != nil && !now.Before(*sub.GraceEndsAt) {
+		return s.downgradeSubscription(ctx, sub)
+	}
+
+	if sub.NextDunningAt == nil || now.Before(*sub.NextDunningAt) || sub.PaymentFailedAt == nil {
+		return nil
+	}
+
+	// retry the failed invoice before escalating
+	if sub.FailedInvoiceID != "" {
+		if _, err := invoice.Pay(sub.FailedInvoiceID, &stripe.InvoicePayParams{Params: stripe.Params{Context: ctx}}); err == nil {
+			sub.Status = models.SubscriptionActive
+			clearGracePeriod(sub)
+			return s.store.SaveSubscription(ctx, sub)
+		}
+	}
+
+	sub.DunningStep++
+	sub.NextDunningAt = nextDunningAt(*sub.PaymentFailedAt, sub.DunningStep)
+
+	return s.inTx(ctx, func(tx *Server) error {
+		if err := tx.store.SaveSubscription(ctx, sub); err != nil {
+			return err
+		}
+
+		return tx.sendDunningEmail(ctx, sub)
+	})
+}
+
+// downgradeSubscription cancels the stripe subscription and moves the account to the free plan.
+func (s *Server) downgradeSubscription(ctx context.Context, sub *models.Subscription) error {
+	if sub.StripeSubscriptionID != "" {
+		if _, err := subscription.Cancel(sub.StripeSubscriptionID, &stripe.SubscriptionCancelParams{Params: stripe.Params{Context: ctx}}); err != nil {
+			return err
+		}
+	}
+
+	plan := models.GetPlan(sub.Plan)
+
+	sub.Plan = models.PlanFree
+	sub.Status = models.SubscriptionCanceled
+	sub.StripeSubscriptionID = ""
+	clearGracePeriod(sub)
+
+	return s.inTx(ctx, func(tx *Server) error {
+		if err := tx.store.SaveSubscription(ctx, sub); err != nil {
+			return err
+		}
+
+		user, err := tx.store.GetUserByID(ctx, sub.UserID)
+		if err != nil {
+			return err
+		}
+
+		return tx.queueUserTemplate(ctx, user, models.NotificationBilling, emails.PlanDowngraded, emails.PlanData{Plan: plan.Name, URL: billingURL()})
+	})
+}
+
+// sendDunningEmail sends the email for the subscription's current dunning step, getting more
+// urgent as the end of the grace period approaches.
+func (s *Server) sendDunningEmail(ctx context.Context, sub *models.Subscription) error {
+	user, err := s.store.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		return err
+	}
+
+	data := emails.DunningData{Plan: models.GetPlan(sub.Plan).Name, URL: billingURL()}
+	if sub.GraceEndsAt != nil {
+		data.GraceEndsAt = *sub.GraceEndsAt
+	}
+
+	name := emails.PaymentFinalNotice
+	switch {
+	case sub.DunningStep == 0:
+		name = emails.PaymentFailed
+	case sub.NextDunningAt != nil:
+		name = emails.PaymentRetryFailed
+	}
+
+	return s.queueUserTemplate(ctx, user, models.NotificationBilling, name, data)
+}
+
+func billingURL() string {
+	return fmt.Sprintf("%s/settings/billing", os.Getenv("APP_URL"))
+}
+
+// nextDunningAt returns when the retry after the given step is due, or nil once the schedule is exhausted.
+func nextDunningAt(failedAt time.Time, step int) *time.Time {
+	schedule := util.GetEnvInts("DUNNING_RETRY_DAYS", []int{3, 7, 11})
+	if step >= len(schedule) {
+		return nil
+	}
+
+	next := failedAt.AddDate(0, 0, schedule[step])
+	return &next
+}
+
+func clearGracePeriod(sub *models.Subscription) {
+	sub.PaymentFailedAt = nil
+	sub.FailedInvoiceID = ""
+	sub.GraceEndsAt = nil
+	sub.DunningStep = 0
+	sub.NextDunningAt = nil
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+)
+
+// TestCheckoutCompletedOverRedeemed checks a checkout whose coupon reservation lapsed and was taken
+// by someone else is acked and recorded rather than retried forever.
+func TestCheckoutCompletedOverRedeemed(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	s := NewServer(":0", store, nil)
+
+	coupon := &models.Coupon{Code: "LAST", PercentOff: 50, Duration: models.CouponDurationOnce, MaxRedemptions: 1}
+	if err := store.CreateCoupon(ctx, coupon); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+	if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: uuid.New()}); err != nil {
+		t.Fatalf("RedeemCoupon: %v", err)
+	}
+
+	userID := uuid.New()
+	checkoutSession := &stripe.CheckoutSession{
+		ID:       "cs_late",
+		Customer: &stripe.Customer{ID: "cus_late"},
+		Metadata: map[string]string{"user_id": userID.String(), "plan": "pro", "coupon_id": coupon.ID.String()},
+	}
+	if err := s.handleCheckoutCompleted(ctx, checkoutSession); err != nil {
+		t.Fatalf("handleCheckoutCompleted = %v, want the over-redemption acked", err)
+	}
+
+	if sub, _ := store.GetSubscriptionByUserID(ctx, userID); sub == nil || sub.Status != models.SubscriptionActive {
+		t.Errorf("subscription = %+v, want it active", sub)
+	}
+	events, _ := store.GetAuditEventsByUserID(ctx, userID, 10)
+	if len(events) != 1 || events[0].Action != models.AuditCouponOverused || events[0].Metadata["checkout_session_id"] != "cs_late" {
+		t.Errorf("audit events = %+v, want the over-redemption recorded", events)
+	}
+}
+
+func TestNextDunningAt(t *testing.T) {
+	failedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		schedule string
+		step     int
+		want     *time.Time
+	}{
+		"first retry":          {"", 0, ptr(failedAt.AddDate(0, 0, 3))},
+		"second retry":         {"", 1, ptr(failedAt.AddDate(0, 0, 7))},
+		"last retry":           {"", 2, ptr(failedAt.AddDate(0, 0, 11))},
+		"schedule exhausted":   {"", 3, nil},
+		"configured schedule":  {"1, 2", 1, ptr(failedAt.AddDate(0, 0, 2))},
+		"configured exhausted": {"1, 2", 2, nil},
+		"invalid schedule":     {"1,x", 0, ptr(failedAt.AddDate(0, 0, 3))},
+	}
+	for name, test := range tests {
+		t.Setenv("DUNNING_RETRY_DAYS", test.schedule)
+
+		got := nextDunningAt(failedAt, test.step)
+		if (got == nil) != (test.want == nil) || got != nil && !got.Equal(*test.want) {
+			t.Errorf("%s: nextDunningAt(step %d) = %v, want %v", name, test.step, got, test.want)
+		}
+	}
+}
+
+func TestUnknownStripeCustomer(t *testing.T) {
+	ctx := context.Background()
+	s := NewServer(":0", storage.NewMemoryStore(), nil)
+
+	inv := &stripe.Invoice{ID: "in_manual", Customer: &stripe.Customer{ID: "cus_unknown"}}
+	if err := s.handlePaymentFailed(ctx, inv); err != nil {
+		t.Errorf("handlePaymentFailed = %v, want an unknown customer acked", err)
+	}
+	if err := s.handleInvoicePaid(ctx, inv); err != nil {
+		t.Errorf("handleInvoicePaid = %v, want an unknown customer acked", err)
+	}
+	if err := s.handleInvoiceUpdated(ctx, inv); err != nil {
+		t.Errorf("handleInvoiceUpdated = %v, want an unknown customer acked", err)
+	}
+	if err := s.handleSubscriptionDeleted(ctx, &stripe.Subscription{Customer: &stripe.Customer{ID: "cus_unknown"}}); err != nil {
+		t.Errorf("handleSubscriptionDeleted = %v, want an unknown customer acked", err)
+	}
+}
+
+// TestProcessSubscriptions runs the lifecycle job over trials and past due subscriptions at each
+// point of their schedule and checks where each one ends up and which email it was sent.
+func TestProcessSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	s := NewServer(":0", store, nil)
+
+	now := time.Now()
+	days := func(n int) *time.Time { return ptr(now.AddDate(0, 0, n)) }
+
+	tests := map[string]struct {
+		sub   models.Subscription
+		check func(*models.Subscription) bool
+		email string
+	}{
+		"trial not ending yet": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionTrialing, TrialEndsAt: days(10)},
+			func(sub *models.Subscription) bool { return sub.TrialReminderSentAt == nil },
+			"",
+		},
+		"trial ending": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionTrialing, TrialEndsAt: days(2)},
+			func(sub *models.Subscription) bool { return sub.TrialReminderSentAt != nil },
+			emails.TrialEnding,
+		},
+		"trial ending, already reminded": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionTrialing, TrialEndsAt: days(1), TrialReminderSentAt: days(-1)},
+			func(sub *models.Subscription) bool { return sub.Status == models.SubscriptionTrialing },
+			"",
+		},
+		"trial ended without paying": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionTrialing, TrialEndsAt: days(-1)},
+			func(sub *models.Subscription) bool {
+				return sub.Plan == models.PlanFree && sub.Status == models.SubscriptionExpired
+			},
+			emails.TrialEnded,
+		},
+		"trial converted": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionTrialing, TrialEndsAt: days(-1), StripeSubscriptionID: "sub_paid"},
+			func(sub *models.Subscription) bool {
+				return sub.Plan == models.PlanPro && sub.Status == models.SubscriptionActive
+			},
+			"",
+		},
+		"retry not due": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionPastDue, PaymentFailedAt: days(-1), NextDunningAt: days(2), GraceEndsAt: days(13)},
+			func(sub *models.Subscription) bool { return sub.DunningStep == 0 },
+			"",
+		},
+		"first retry due": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionPastDue, PaymentFailedAt: days(-4), NextDunningAt: days(-1), GraceEndsAt: days(10)},
+			func(sub *models.Subscription) bool {
+				return sub.DunningStep == 1 && sub.NextDunningAt != nil && sub.NextDunningAt.Equal(days(-4).AddDate(0, 0, 7))
+			},
+			emails.PaymentRetryFailed,
+		},
+		"last retry due": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionPastDue, DunningStep: 2, PaymentFailedAt: days(-12), NextDunningAt: days(-1), GraceEndsAt: days(2)},
+			func(sub *models.Subscription) bool {
+				return sub.DunningStep == 3 && sub.NextDunningAt == nil && sub.Status == models.SubscriptionPastDue
+			},
+			emails.PaymentFinalNotice,
+		},
+		"grace period over": {
+			models.Subscription{Plan: models.PlanPro, Status: models.SubscriptionPastDue, DunningStep: 3, PaymentFailedAt: days(-15), GraceEndsAt: days(-1)},
+			func(sub *models.Subscription) bool {
+				return sub.Plan == models.PlanFree && sub.Status == models.SubscriptionCanceled && sub.GraceEndsAt == nil && sub.PaymentFailedAt == nil
+			},
+			emails.PlanDowngraded,
+		},
+	}
+
+	users := map[string]*models.User{}
+	for name, test := range tests {
+		user := &models.User{Email: uuid.NewString() + "@example.com"}
+		if err := store.CreateUser(ctx, user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users[name] = user
+
+		sub := test.sub
+		sub.UserID = user.ID
+		if err := store.SaveSubscription(ctx, &sub); err != nil {
+			t.Fatalf("SaveSubscription: %v", err)
+		}
+	}
+
+	if err := s.ProcessSubscriptions(ctx); err != nil {
+		t.Fatalf("ProcessSubscriptions: %v", err)
+	}
+
+	queued, err := store.GetOutboxEmailsByStatus(ctx, models.OutboxPending, 100)
+	if err != nil {
+		t.Fatalf("GetOutboxEmailsByStatus: %v", err)
+	}
+	subjects := map[string][]string{}
+	for _, email := range queued {
+		subjects[email.To] = append(subjects[email.To], email.Subject)
+	}
+
+	for name, test := range tests {
+		user := users[name]
+		sub, err := store.GetSubscriptionByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("%s: GetSubscriptionByUserID: %v", name, err)
+		}
+		if !test.check(sub) {
+			t.Errorf("%s: subscription ended up %+v", name, sub)
+		}
+
+		var want []string
+		if test.email != "" {
+			rendered, err := emails.Preview(test.email, "en")
+			if err != nil {
+				t.Fatalf("%s: Preview: %v", name, err)
+			}
+			want = []string{rendered.Subject}
+		}
+		if got := subjects[user.Email]; !slices.Equal(got, want) {
+			t.Errorf("%s: queued %q, want %q", name, got, want)
+		}
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+	"github.com/stripe/stripe-go/v80/coupon"
+)
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,40}$`)
+
+func (s *Server) handleCreateCoupon(w http.ResponseWriter, r *http.Request) error {
+	createReq := new(models.CreateCouponRequest)
+	if err := json.NewDecoder(r.Body).Decode(createReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "empty body.", Code: "empty_body"})
+	}
+
+	code := normalizeCouponCode(createReq.Code)
+	if !couponCodePattern.MatchString(code) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "code must be 3-40 letters, numbers, dashes or underscores.", Code: "invalid_coupon_code"})
+	}
+
+	if (createReq.PercentOff > 0) == (createReq.AmountOff > 0) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "provide either a percent or a fixed amount off.", Code: "invalid_discount"})
+	}
+
+	if createReq.PercentOff > 100 || createReq.PercentOff < 0 || createReq.AmountOff < 0 {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "discount is out of range.", Code: "invalid_discount"})
+	}
+
+	if createReq.AmountOff > 0 && len(createReq.Currency) != 3 {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "a currency is required for fixed amount discounts.", Code: "invalid_currency"})
+	}
+
+	switch createReq.Duration {
+	case models.CouponDurationOnce, models.CouponDurationForever:
+		createReq.DurationInMonths = 0
+	case models.CouponDurationRepeating:
+		if createReq.DurationInMonths < 1 {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "repeating coupons need a duration in months.", Code: "invalid_duration"})
+		}
+	default:
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "duration must be once, repeating or forever.", Code: "invalid_duration"})
+	}
+
+	if createReq.MaxRedemptions < 0 {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "max redemptions cannot be negative.", Code: "invalid_max_redemptions"})
+	}
+
+	if createReq.ExpiresAt != nil && createReq.ExpiresAt.Before(time.Now()) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "expiry must be in the future.", Code: "invalid_expiry"})
+	}
+
+	for _, plan := range createReq.AllowedPlans {
+		if _, ok := models.Plans[plan]; !ok {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("unknown plan %s.", plan), Code: "invalid_plan"})
+		}
+	}
+
+	if existing, _ := s.store.GetCouponByCode(r.Context(), code); existing != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "a coupon with this code already exists.", Code: "coupon_exists"})
+	}
+
+	newCoupon := &models.Coupon{
+		Code:             code,
+		PercentOff:       createReq.PercentOff,
+		AmountOff:        createReq.AmountOff,
+		Currency:         strings.ToLower(createReq.Currency),
+		Duration:         createReq.Duration,
+		DurationInMonths: createReq.DurationInMonths,
+		MaxRedemptions:   createReq.MaxRedemptions,
+		ExpiresAt:        createReq.ExpiresAt,
+		AllowedPlans:     createReq.AllowedPlans,
+		Active:           true,
+	}
+
+	// sync to stripe first so we never store a coupon checkout can't apply
+	params := &stripe.CouponParams{
+		ID:       stripe.String(code),
+		Name:     stripe.String(code),
+		Duration: stripe.String(newCoupon.Duration),
+	}
+	if newCoupon.PercentOff > 0 {
+		params.PercentOff = stripe.Float64(float64(newCoupon.PercentOff))
+	} else {
+		params.AmountOff = stripe.Int64(newCoupon.AmountOff)
+		params.Currency = stripe.String(newCoupon.Currency)
+	}
+	if newCoupon.DurationInMonths > 0 {
+		params.DurationInMonths = stripe.Int64(int64(newCoupon.DurationInMonths))
+	}
+	if newCoupon.MaxRedemptions > 0 {
+		params.MaxRedemptions = stripe.Int64(int64(newCoupon.MaxRedemptions))
+	}
+	if newCoupon.ExpiresAt != nil {
+		params.RedeemBy = stripe.Int64(newCoupon.ExpiresAt.Unix())
+	}
+
+	params.Context = r.Context()
+	stripeCoupon, err := coupon.New(params)
+	if err != nil {
+		fmt.Printf("Error creating stripe coupon: %v\n", err)
+		return WriteJSON(w, http.StatusBadGateway, Error{Error: "could not sync coupon to the billing provider.", Code: "billing_provider_error"})
+	}
+
+	newCoupon.StripeCouponID = stripeCoupon.ID
+
+	if err := s.store.CreateCoupon(r.Context(), newCoupon); err != nil {
+		// clean up even when the request was cancelled
+		_, _ = coupon.Del(stripeCoupon.ID, &stripe.CouponParams{Params: stripe.Params{Context: context.WithoutCancel(r.Context())}})
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusCreated, Response{Message: "coupon created.", Code: "coupon_created", Data: newCoupon})
+}
+
+func (s *Server) handleGetAllCoupons(w http.ResponseWriter, r *http.Request) error {
+	coupons, err := s.store.GetAllCoupons(r.Context())
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if coupons == nil {
+		coupons = []*models.Coupon{}
+	}
+
+	return WriteJSON(w, http.StatusOK, coupons)
+}
+
+func (s *Server) handleDeactivateCoupon(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid coupon id.", Code: "invalid_id"})
+	}
+
+	existing, err := s.store.GetCouponByID(r.Context(), id)
+	if err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "coupon not found.", Code: "coupon_not_found"})
+	}
+
+	if !existing.Active {
+		return WriteJSON(w, http.StatusNoContent, nil)
+	}
+
+	// stripe coupons can't be edited, deleting stops new redemptions while existing discounts keep applying
+	if existing.StripeCouponID != "" {
+		if _, err := coupon.Del(existing.StripeCouponID, &stripe.CouponParams{Params: stripe.Params{Context: r.Context()}}); err != nil {
+			fmt.Printf("Error deleting stripe coupon: %v\n", err)
+			return WriteJSON(w, http.StatusBadGateway, Error{Error: "could not sync coupon to the billing provider.", Code: "billing_provider_error"})
+		}
+	}
+
+	existing.Active = false
+
+	if err := s.store.UpdateCoupon(r.Context(), existing); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *Server) handleValidateCoupon(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	validateReq := new(models.ValidateCouponRequest)
+	if err := json.NewDecoder(r.Body).Decode(validateReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "empty body.", Code: "empty_body"})
+	}
+
+	validCoupon, apiErr := s.validateCoupon(r.Context(), validateReq.Coupon, validateReq.Plan, user.ID)
+	if apiErr != nil {
+		return WriteJSON(w, http.StatusBadRequest, *apiErr)
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "coupon is valid.", Code: "coupon_valid", Data: validCoupon})
+}
+
+// validateCoupon checks a code can be applied to the plan by the user.
+func (s *Server) validateCoupon(ctx context.Context, code string, plan string, userID uuid.UUID) (*models.Coupon, *Error) {
+	found, err := s.store.GetCouponByCode(ctx, normalizeCouponCode(code))
+	if err != nil || !found.Active {
+		return nil, &Error{Error: "this coupon code is invalid.", Code: "invalid_coupon"}
+	}
+
+	if found.ExpiresAt != nil && !time.Now().Before(*found.ExpiresAt) {
+		return nil, &Error{Error: "this coupon has expired.", Code: "coupon_expired"}
+	}
+
+	redemption, _ := s.store.GetCouponRedemption(ctx, found.ID, userID)
+	if redemption != nil && redemption.Status == models.CouponRedeemed {
+		return nil, &Error{Error: "you've already used this coupon.", Code: "coupon_already_redeemed"}
+	}
+
+	// a checkout the user already started holds one of the redemptions for them
+	if redemption == nil && found.MaxRedemptions > 0 && found.TimesRedeemed >= found.MaxRedemptions {
+		return nil, &Error{Error: "this coupon is no longer available.", Code: "coupon_exhausted"}
+	}
+
+	if !found.AllowsPlan(plan) {
+		return nil, &Error{Error: "this coupon can't be used with the selected plan.", Code: "coupon_not_applicable"}
+	}
+
+	return found, nil
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/colecaccamise/go-backend/mail"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+type emailWebhookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		EmailID string   `json:"email_id"`
+		To      []string `json:"to"`
+		Bounce  *struct {
+			Type string `json:"type"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// handleEmailWebhook ingests delivery events from the mail provider, suppressing addresses that
+// hard bounce or complain so we stop sending to them.
+func (s *Server) handleEmailWebhook(w http.ResponseWriter, r *http.Request) error {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 65536))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+	}
+
+	if err := mail.VerifyWebhook(os.Getenv("EMAIL_WEBHOOK_SECRET"), r.Header, payload, time.Now()); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid signature.", Code: "invalid_signature"})
+	}
+
+	event := new(emailWebhookEvent)
+	if err := json.Unmarshal(payload, event); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid payload.", Code: "invalid_payload"})
+	}
+
+	var eventType string
+	switch event.Type {
+	case "email.delivered":
+		eventType = models.EmailEventDelivered
+	case "email.bounced":
+		eventType = models.EmailEventBounced
+	case "email.complained":
+		eventType = models.EmailEventComplained
+	default:
+		return WriteJSON(w, http.StatusOK, nil)
+	}
+
+	for i, to := range event.Data.To {
+		created, err := s.store.CreateEmailEvent(r.Context(), &models.EmailEvent{
+			ProviderEventID: fmt.Sprintf("%s:%d", r.Header.Get("svix-id"), i),
+			ProviderEmailID: event.Data.EmailID,
+			Type:            eventType,
+			Email:           to,
+		})
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		// already handled on an earlier delivery of this webhook
+		if !created {
+			continue
+		}
+
+		// transient bounces like a full mailbox are worth retrying later
+		if eventType == models.EmailEventDelivered || (eventType == models.EmailEventBounced && event.Data.Bounce != nil && event.Data.Bounce.Type == "Transient") {
+			continue
+		}
+
+		if err := s.store.SuppressEmail(r.Context(), &models.EmailSuppression{Email: to, Reason: eventType}); err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+	}
+
+	return WriteJSON(w, http.StatusOK, nil)
+}
+
+func (s *Server) handleGetEmailSuppressions(w http.ResponseWriter, r *http.Request) error {
+	suppressions, err := s.store.GetEmailSuppressions(r.Context())
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if suppressions == nil {
+		suppressions = []*models.EmailSuppression{}
+	}
+
+	return WriteJSON(w, http.StatusOK, suppressions)
+}
+
+func (s *Server) handleDeleteEmailSuppression(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid suppression id.", Code: "invalid_id"})
+	}
+
+	if err := s.store.DeleteEmailSuppression(r.Context(), id); err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "suppression not found.", Code: "suppression_not_found"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/mail"
+	"github.com/colecaccamise/go-backend/middleware"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/go-chi/chi"
+)
+
+func (s *Server) handleGetEmailTemplates(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, emails.Names())
+}
+
+// handlePreviewEmail renders a template with sample data. Pass ?format=text for the plain-text part
+// or ?format=json for the subject and both parts, and ?locale= to preview a translation.
+func (s *Server) handlePreviewEmail(w http.ResponseWriter, r *http.Request) error {
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = middleware.GetLocale(w)
+	}
+
+	email, err := emails.Preview(chi.URLParam(r, "name"), i18n.Normalize(locale))
+	if err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "email template not found.", Code: "email_template_not_found"})
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return WriteJSON(w, http.StatusOK, email)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte(email.Text))
+		return err
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte(email.HTML))
+		return err
+	}
+}
+
+// newTemplateEmail renders the named email template in the locale into an outbox email, ready
+// to be queued alongside the change that triggered it. Templates are all account emails, so
+// they're sent in the security category which can't be turned off.
+func newTemplateEmail(to string, locale string, name string, data any) (*models.OutboxEmail, error) {
+	email, err := emails.Render(name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxEmail{
+		To:       to,
+		Subject:  email.Subject,
+		HTML:     email.HTML,
+		Text:     email.Text,
+		Category: models.NotificationSecurity,
+	}, nil
+}
+
+// queueTemplate renders the named email template and queues it for delivery.
+func (s *Server) queueTemplate(ctx context.Context, to string, locale string, name string, data any) error {
+	email, err := newTemplateEmail(to, locale, name, data)
+	if err != nil {
+		return err
+	}
+
+	return s.store.EnqueueEmails(ctx, email)
+}
+
+// queueUserTemplate renders the named email template in the user's locale and queues it in the
+// notification category, so the user's preferences and unsubscribe links apply to it.
+func (s *Server) queueUserTemplate(ctx context.Context, user *models.User, category string, name string, data any) error {
+	email, err := emails.Render(name, userLocale(user), data)
+	if err != nil {
+		return err
+	}
+
+	return s.queueEmail(ctx, &mail.Message{To: user.Email, Subject: email.Subject, HTML: email.HTML, Text: email.Text, Category: category})
+}
+
+// queueEmail queues a message for delivery by the outbox worker. Messages in marketing categories
+// get a one-click unsubscribe link and RFC 8058 List-Unsubscribe headers.
+func (s *Server) queueEmail(ctx context.Context, msg *mail.Message) error {
+	email := &models.OutboxEmail{
+		To:       msg.To,
+		Subject:  msg.Subject,
+		HTML:     msg.HTML,
+		Text:     msg.Text,
+		Headers:  msg.Headers,
+		Category: msg.Category,
+	}
+
+	if category, ok := models.GetNotificationCategory(msg.Category); ok && category.Marketing {
+		user, err := s.store.GetUserByEmail(ctx, msg.To)
+		if err != nil {
+			return err
+		}
+
+		addUnsubscribeLink(email, user, category)
+	}
+
+	return s.store.EnqueueEmails(ctx, email)
+}
+
+func addUnsubscribeLink(email *models.OutboxEmail, user *models.User, category models.NotificationCategory) {
+	link := unsubscribeURL(user.ID, category.ID)
+	locale := userLocale(user)
+
+	headers := map[string]string{}
+	for key, value := range email.Headers {
+		headers[key] = value
+	}
+	headers["List-Unsubscribe"] = fmt.Sprintf("<%s>", link)
+	headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	email.Headers = headers
+
+	footer := i18n.T(locale, "unsubscribe.footer", categoryName(locale, category.ID))
+	paragraph := fmt.Sprintf(`<p style="font-size: 12px; color: #6b7280;">%s <a href="%s">%s</a></p>`, html.EscapeString(footer), html.EscapeString(link), html.EscapeString(i18n.T(locale, "unsubscribe.link")))
+	if i := strings.LastIndex(email.HTML, "</body>"); i >= 0 {
+		email.HTML = email.HTML[:i] + paragraph + email.HTML[i:]
+	} else {
+		email.HTML += paragraph
+	}
+	if email.Text != "" {
+		email.Text += fmt.Sprintf("\n\n%s %s: %s\n", footer, i18n.T(locale, "unsubscribe.link"), link)
+	}
+}
+
+// emailLocale picks the user's saved locale, falling back to the request's locale.
+func emailLocale(w http.ResponseWriter, user *models.User) string {
+	if user.Locale != "" {
+		return user.Locale
+	}
+	return middleware.GetLocale(w)
+}
+
+// userLocale picks the user's saved locale for emails sent outside a request.
+func userLocale(user *models.User) string {
+	if user.Locale != "" {
+		return user.Locale
+	}
+	return i18n.DefaultLocale
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// ReencryptUsers reseals users' personal data with the primary key, a batch at a time. To rotate
+// keys, add the new key to PII_KEYS, make it PII_PRIMARY_KEY and run this; once it finishes the
+// old key can be removed.
+func (s *Server) ReencryptUsers(ctx context.Context) error {
+	for {
+		updated, err := s.store.ReencryptUsers(ctx, 100)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return nil
+		}
+	}
+}
+
+// handleReencryptUsers queues a re-encryption run now instead of waiting for the nightly one.
+func (s *Server) handleReencryptUsers(w http.ResponseWriter, r *http.Request) error {
+	if s.jobs == nil {
+		return WriteJSON(w, http.StatusServiceUnavailable, Error{Error: "background jobs aren't running.", Code: "jobs_unavailable"})
+	}
+
+	if err := s.jobs.Enqueue(r.Context(), "users.reencrypt", nil); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusAccepted, nil)
+}
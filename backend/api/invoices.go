@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+	"github.com/stripe/stripe-go/v80/customer"
+)
+
+func (s *Server) handleGetInvoices(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 20
+	}
+	if perPage > 100 {
+		perPage = 100
+	}
+
+	invoices, total, err := s.store.GetInvoicesByUserID(r.Context(), user.ID, perPage, (page-1)*perPage)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if invoices == nil {
+		invoices = []*models.Invoice{}
+	}
+
+	return WriteJSON(w, http.StatusOK, models.InvoiceListResponse{
+		Invoices: invoices,
+		Page:     page,
+		PerPage:  perPage,
+		Total:    total,
+		HasMore:  int64(page*perPage) < total,
+	})
+}
+
+func (s *Server) handleGetInvoicePDF(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid invoice id.", Code: "invalid_id"})
+	}
+
+	inv, err := s.store.GetInvoiceByID(r.Context(), id)
+	if err != nil || inv.UserID != user.ID {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "invoice not found.", Code: "invoice_not_found"})
+	}
+
+	profile, _ := s.store.GetBillingProfileByUserID(r.Context(), user.ID)
+
+	receipt := renderReceipt(inv, profile, user)
+
+	filename := inv.Number
+	if filename == "" {
+		filename = inv.ID.String()
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"receipt-%s.pdf\"", filename))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(receipt)
+	return err
+}
+
+func (s *Server) handleGetBillingProfile(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	profile, err := s.store.GetBillingProfileByUserID(r.Context(), user.ID)
+	if err != nil {
+		profile = &models.BillingProfile{UserID: user.ID}
+	}
+
+	return WriteJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) handleUpdateBillingProfile(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	updateReq := new(models.UpdateBillingProfileRequest)
+	if err := json.NewDecoder(r.Body).Decode(updateReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "empty body.", Code: "empty_body"})
+	}
+
+	fields := []*string{
+		&updateReq.Name, &updateReq.CompanyName, &updateReq.AddressLine1, &updateReq.AddressLine2,
+		&updateReq.City, &updateReq.State, &updateReq.PostalCode, &updateReq.Country, &updateReq.TaxID,
+	}
+	for _, field := range fields {
+		*field = strings.TrimSpace(*field)
+		if len(*field) > 200 {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "billing details must be 200 characters or fewer.", Code: "invalid_input"})
+		}
+	}
+
+	updateReq.Country = strings.ToUpper(updateReq.Country)
+	if updateReq.Country != "" && len(updateReq.Country) != 2 {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "country must be a two letter country code.", Code: "invalid_country"})
+	}
+
+	profile, err := s.store.GetBillingProfileByUserID(r.Context(), user.ID)
+	if err != nil {
+		profile = &models.BillingProfile{UserID: user.ID}
+	}
+
+	profile.Name = updateReq.Name
+	profile.CompanyName = updateReq.CompanyName
+	profile.AddressLine1 = updateReq.AddressLine1
+	profile.AddressLine2 = updateReq.AddressLine2
+	profile.City = updateReq.City
+	profile.State = updateReq.State
+	profile.PostalCode = updateReq.PostalCode
+	profile.Country = updateReq.Country
+	profile.TaxID = updateReq.TaxID
+
+	if err := s.store.SaveBillingProfile(r.Context(), profile); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	// keep the address on the billing provider's invoices in sync
+	if sub, err := s.store.GetSubscriptionByUserID(r.Context(), user.ID); err == nil && sub.StripeCustomerID != "" {
+		name := profile.CompanyName
+		if name == "" {
+			name = profile.Name
+		}
+
+		_, err := customer.Update(sub.StripeCustomerID, &stripe.CustomerParams{
+			Params: stripe.Params{Context: r.Context()},
+			Name:   stripe.String(name),
+			Address: &stripe.AddressParams{
+				Line1:      stripe.String(profile.AddressLine1),
+				Line2:      stripe.String(profile.AddressLine2),
+				City:       stripe.String(profile.City),
+				State:      stripe.String(profile.State),
+				PostalCode: stripe.String(profile.PostalCode),
+				Country:    stripe.String(profile.Country),
+			},
+		})
+		if err != nil {
+			fmt.Printf("Error syncing billing profile to stripe: %v\n", err)
+		}
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "billing profile updated.", Code: "billing_profile_updated", Data: profile})
+}
+
+// syncInvoice stores a copy of a stripe invoice so receipts can be listed and rendered locally.
+func (s *Server) syncInvoice(ctx context.Context, inv *stripe.Invoice, userID uuid.UUID) error {
+	var discount int64
+	for _, amount := range inv.TotalDiscountAmounts {
+		discount += amount.Amount
+	}
+
+	description := inv.Description
+	if description == "" && inv.Lines != nil && len(inv.Lines.Data) > 0 {
+		description = inv.Lines.Data[0].Description
+	}
+
+	record := &models.Invoice{
+		UserID:          userID,
+		StripeInvoiceID: inv.ID,
+		Number:          inv.Number,
+		Status:          string(inv.Status),
+		Description:     description,
+		Currency:        string(inv.Currency),
+		Subtotal:        inv.Subtotal,
+		Discount:        discount,
+		Tax:             inv.Tax,
+		Total:           inv.Total,
+		AmountPaid:      inv.AmountPaid,
+		PeriodStart:     unixTime(inv.PeriodStart),
+		PeriodEnd:       unixTime(inv.PeriodEnd),
+	}
+
+	if inv.StatusTransitions != nil {
+		record.PaidAt = unixTime(inv.StatusTransitions.PaidAt)
+	}
+
+	return s.store.SaveInvoice(ctx, record)
+}
+
+// renderReceipt lays out a one page receipt with our company details and the customer's billing profile.
+func renderReceipt(inv *models.Invoice, profile *models.BillingProfile, user *models.User) []byte {
+	pdf := util.NewPDF()
+	left, right := 56.0, util.PDFPageWidth-56
+	y := util.PDFPageHeight - 72
+
+	pdf.Text(left, y, 22, true, "Receipt")
+	pdf.TextRight(right, y, 10, false, fmt.Sprintf("Receipt #%s", inv.Number))
+	y -= 16
+	issuedAt := inv.CreatedAt
+	if inv.PaidAt != nil {
+		issuedAt = *inv.PaidAt
+	}
+	pdf.TextRight(right, y, 10, false, issuedAt.Format("January 2, 2006"))
+
+	// seller
+	y -= 40
+	pdf.Text(left, y, 10, true, "From")
+	y -= 14
+	for _, line := range companyDetails() {
+		pdf.Text(left, y, 10, false, line)
+		y -= 14
+	}
+
+	// customer
+	y -= 12
+	pdf.Text(left, y, 10, true, "Billed to")
+	y -= 14
+	for _, line := range customerDetails(profile, user) {
+		pdf.Text(left, y, 10, false, line)
+		y -= 14
+	}
+
+	// line items
+	y -= 20
+	pdf.Line(left, y, right, y)
+	y -= 18
+	pdf.Text(left, y, 10, true, "Description")
+	pdf.TextRight(right, y, 10, true, "Amount")
+	y -= 10
+	pdf.Line(left, y, right, y)
+
+	y -= 18
+	description := inv.Description
+	if description == "" {
+		description = "Subscription"
+	}
+	if inv.PeriodStart != nil && inv.PeriodEnd != nil {
+		description = fmt.Sprintf("%s (%s - %s)", description, inv.PeriodStart.Format("Jan 2, 2006"), inv.PeriodEnd.Format("Jan 2, 2006"))
+	}
+	pdf.Text(left, y, 10, false, description)
+	pdf.TextRight(right, y, 10, false, formatAmount(inv.Subtotal, inv.Currency))
+
+	y -= 24
+	pdf.Line(left, y, right, y)
+
+	totals := [][2]string{{"Subtotal", formatAmount(inv.Subtotal, inv.Currency)}}
+	if inv.Discount > 0 {
+		totals = append(totals, [2]string{"Discount", "-" + formatAmount(inv.Discount, inv.Currency)})
+	}
+	if inv.Tax > 0 {
+		totals = append(totals, [2]string{"Tax", formatAmount(inv.Tax, inv.Currency)})
+	}
+	totals = append(totals,
+		[2]string{"Total", formatAmount(inv.Total, inv.Currency)},
+		[2]string{"Amount paid", formatAmount(inv.AmountPaid, inv.Currency)},
+	)
+
+	for _, total := range totals {
+		y -= 18
+		bold := total[0] == "Amount paid"
+		pdf.Text(right-200, y, 10, bold, total[0])
+		pdf.TextRight(right, y, 10, bold, total[1])
+	}
+
+	pdf.Text(left, 56, 8, false, fmt.Sprintf("Questions about this receipt? Contact %s", os.Getenv("COMPANY_EMAIL")))
+
+	return pdf.Bytes()
+}
+
+// companyDetails reads the seller block from env. COMPANY_ADDRESS lines are separated by semicolons.
+func companyDetails() []string {
+	lines := []string{os.Getenv("COMPANY_NAME")}
+	for _, line := range strings.Split(os.Getenv("COMPANY_ADDRESS"), ";") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if email := os.Getenv("COMPANY_EMAIL"); email != "" {
+		lines = append(lines, email)
+	}
+	if taxID := os.Getenv("COMPANY_TAX_ID"); taxID != "" {
+		lines = append(lines, "Tax ID: "+taxID)
+	}
+	return lines
+}
+
+func customerDetails(profile *models.BillingProfile, user *models.User) []string {
+	if profile == nil {
+		return []string{user.Email}
+	}
+
+	var lines []string
+	for _, line := range []string{
+		profile.CompanyName,
+		profile.Name,
+		profile.AddressLine1,
+		profile.AddressLine2,
+		strings.TrimSpace(strings.Join([]string{profile.City, profile.State, profile.PostalCode}, " ")),
+		profile.Country,
+	} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, user.Email)
+	if profile.TaxID != "" {
+		lines = append(lines, "Tax ID: "+profile.TaxID)
+	}
+	return lines
+}
+
+// formatAmount formats an amount in the currency's smallest unit, e.g. 1099 usd -> $10.99 and
+// 1099 jpy -> 1099 JPY.
+func formatAmount(amount int64, currency string) string {
+	formatted := formatDecimal(amount, currency)
+	negative := strings.HasPrefix(formatted, "-")
+	formatted = strings.TrimPrefix(formatted, "-")
+
+	if strings.EqualFold(currency, "usd") || currency == "" {
+		formatted = "$" + formatted
+	} else {
+		formatted = formatted + " " + strings.ToUpper(currency)
+	}
+
+	if negative {
+		return "-" + formatted
+	}
+	return formatted
+}
+
+// zeroDecimalCurrencies have no minor unit, so Stripe amounts in them are whole units. See
+// https://docs.stripe.com/currencies#zero-decimal.
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+// threeDecimalCurrencies have a minor unit of a thousandth. See
+// https://docs.stripe.com/currencies#three-decimal.
+var threeDecimalCurrencies = map[string]bool{"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true}
+
+// formatDecimal formats an amount in the currency's smallest unit as a plain decimal, e.g.
+// 1099 usd -> 10.99.
+func formatDecimal(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	currency = strings.ToLower(currency)
+	switch {
+	case zeroDecimalCurrencies[currency]:
+		return fmt.Sprintf("%s%d", sign, amount)
+	case threeDecimalCurrencies[currency]:
+		return fmt.Sprintf("%s%d.%03d", sign, amount/1000, amount%1000)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+	}
+}
+
+func unixTime(seconds int64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/colecaccamise/go-backend/jobs"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// RegisterJobs registers the server's background jobs with the runner.
+func (s *Server) RegisterJobs(runner *jobs.Runner) error {
+	s.jobs = runner
+
+	registrations := []struct {
+		name    string
+		handler jobs.Handler
+		opts    []jobs.Option
+	}{
+		{"outbox.deliver", func(ctx context.Context, job *models.Job) error { return s.DeliverOutbox(ctx) }, []jobs.Option{jobs.Every("@every 10s"), jobs.MaxAttempts(1)}},
+		{"usage.report", func(ctx context.Context, job *models.Job) error { return s.ReportUsage(ctx) }, []jobs.Option{jobs.Every("@hourly")}},
+		{"subscriptions.process", func(ctx context.Context, job *models.Job) error { return s.ProcessSubscriptions(ctx) }, []jobs.Option{jobs.Every("15 * * * *")}},
+		{"payouts.process", func(ctx context.Context, job *models.Job) error { return s.ProcessPayouts(ctx) }, []jobs.Option{jobs.Every("30 * * * *")}},
+		{"users.purge_deleted", func(ctx context.Context, job *models.Job) error { return s.PurgeDeletedUsers(ctx) }, []jobs.Option{jobs.Every("0 3 * * *"), jobs.Timeout(time.Hour)}},
+		{"users.cleanup_unconfirmed", func(ctx context.Context, job *models.Job) error { return s.CleanupUnconfirmedUsers(ctx) }, []jobs.Option{jobs.Every("45 * * * *")}},
+		{"users.reencrypt", func(ctx context.Context, job *models.Job) error { return s.ReencryptUsers(ctx) }, []jobs.Option{jobs.Every("0 4 * * *"), jobs.Timeout(time.Hour)}},
+	}
+
+	for _, registration := range registrations {
+		if err := runner.Register(registration.name, registration.handler, registration.opts...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleGetJobs shows the job queue for admins: counts per status, recurring schedules and the
+// most recent jobs, filtered with ?status= (dead by default).
+func (s *Server) handleGetJobs(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.JobDead
+	}
+
+	if status != models.JobPending && status != models.JobRunning && status != models.JobSucceeded && status != models.JobDead {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "status must be pending, running, succeeded or dead.", Code: "invalid_job_status"})
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "limit must be between 1 and 100.", Code: "invalid_limit"})
+		}
+		limit = n
+	}
+
+	counts, err := s.store.GetJobCounts(r.Context())
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	schedules, err := s.store.GetJobSchedules(r.Context())
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	jobList, err := s.store.GetJobs(r.Context(), status, limit)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if schedules == nil {
+		schedules = []*models.JobSchedule{}
+	}
+	if jobList == nil {
+		jobList = []*models.Job{}
+	}
+
+	response := models.JobStatusResponse{
+		Counts:    counts,
+		Schedules: schedules,
+		Jobs:      jobList,
+	}
+	if s.jobs != nil {
+		response.WorkerID = s.jobs.ID()
+		response.Leader = s.jobs.IsLeader()
+	}
+
+	return WriteJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleRetryJob(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid job id.", Code: "invalid_id"})
+	}
+
+	if err := s.store.RetryJob(r.Context(), id); err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "dead job not found.", Code: "job_not_found"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
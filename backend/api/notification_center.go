@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// notificationHub fans new notifications out to the event streams open on this replica. It hears
+// about them from the store, so a notification created on any replica reaches every dashboard.
+type notificationHub struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan *models.Notification]struct{}
+}
+
+func newNotificationHub() *notificationHub {
+	return &notificationHub{subscribers: map[uuid.UUID]map[chan *models.Notification]struct{}{}}
+}
+
+func (h *notificationHub) subscribe(userID uuid.UUID) (chan *models.Notification, func()) {
+	ch := make(chan *models.Notification, 16)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = map[chan *models.Notification]struct{}{}
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// listen feeds the hub from the store until ctx is done, listening again after a failure.
+// Notifications created while it's reconnecting aren't pushed, dashboards pick them up on their
+// next GET /notifications.
+func (h *notificationHub) listen(ctx context.Context, store storage.NotificationRepository) {
+	for {
+		err := store.ListenNotifications(ctx, h.publish)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("Error listening for notifications: %v\n", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *notificationHub) publish(notification *models.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[notification.UserID] {
+		select {
+		case ch <- notification:
+		default:
+			// slow stream, it'll catch up from the unread count on reconnect
+		}
+	}
+}
+
+// notify records an in-app notification for the user in their locale, which the store announces
+// to their open dashboards. Failures are logged rather than failing the action that triggered it.
+func (s *Server) notify(ctx context.Context, user *models.User, notificationType string, args ...any) {
+	locale := user.Locale
+	if locale == "" {
+		locale = i18n.DefaultLocale
+	}
+
+	notification := &models.Notification{
+		UserID: user.ID,
+		Type:   notificationType,
+		Title:  i18n.T(locale, fmt.Sprintf("notification.%s.title", notificationType)),
+		Body:   i18n.T(locale, fmt.Sprintf("notification.%s.body", notificationType), args...),
+	}
+
+	if err := s.store.CreateNotification(ctx, notification); err != nil {
+		fmt.Printf("Error creating %s notification for user %s: %v\n", notificationType, user.ID, err)
+	}
+}
+
+func (s *Server) handleGetNotifications(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 100 {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "limit must be between 1 and 100.", Code: "invalid_limit"})
+		}
+	}
+
+	notifications, err := s.store.GetNotifications(r.Context(), user.ID, limit)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	unread, err := s.store.CountUnreadNotifications(r.Context(), user.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if notifications == nil {
+		notifications = []*models.Notification{}
+	}
+
+	return WriteJSON(w, http.StatusOK, models.NotificationsResponse{Notifications: notifications, Unread: unread})
+}
+
+func (s *Server) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "notification not found.", Code: "notification_not_found"})
+	}
+
+	if err := s.store.MarkNotificationRead(r.Context(), user.ID, id); err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "notification not found.", Code: "notification_not_found"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *Server) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	if err := s.store.MarkAllNotificationsRead(r.Context(), user.ID); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+// handleNotificationStream pushes new notifications to the dashboard as Server-Sent Events. It
+// sends the unread count on connect and a comment every 25 seconds to keep proxies from closing
+// the idle connection.
+func (s *Server) handleNotificationStream(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	unread, err := s.store.CountUnreadNotifications(r.Context(), user.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	notifications, unsubscribe := s.notifications.subscribe(user.ID)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeEvent(w, "unread", map[string]int64{"unread": unread}); err != nil {
+		return nil
+	}
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case notification := <-notifications:
+			if err := writeEvent(w, "notification", notification); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return nil
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
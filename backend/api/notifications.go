@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/middleware"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/google/uuid"
+)
+
+func (s *Server) handleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	preferences, err := s.store.GetNotificationPreferences(r.Context(), user.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, notificationPreferencesResponse(w, preferences))
+}
+
+func (s *Server) handleUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	updateReq := new(models.UpdateNotificationPreferencesRequest)
+	if err := json.NewDecoder(r.Body).Decode(updateReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid request.", Code: "invalid_request"})
+	}
+
+	for id := range updateReq.Email {
+		category, ok := models.GetNotificationCategory(id)
+		if !ok {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("unknown notification category %s.", id), Code: "unknown_notification_category"})
+		}
+		if category.Required {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("%s emails can't be turned off.", id), Code: "notification_category_required"})
+		}
+	}
+
+	if err := s.store.SaveNotificationPreferences(r.Context(), user.ID, updateReq.Email); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	preferences, err := s.store.GetNotificationPreferences(r.Context(), user.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "notification preferences updated.", Code: "notification_preferences_updated", Data: notificationPreferencesResponse(w, preferences)})
+}
+
+// notificationPreferencesResponse lists the user's preferences with category names in the request's locale.
+func notificationPreferencesResponse(w http.ResponseWriter, preferences []*models.NotificationPreference) []models.NotificationPreferenceResponse {
+	response := models.NewNotificationPreferencesResponse(preferences)
+	for i := range response {
+		response[i].Name = categoryName(middleware.GetLocale(w), response[i].Category)
+	}
+	return response
+}
+
+func categoryName(locale string, category string) string {
+	return i18n.T(locale, "notifications."+category)
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!doctype html>
+<html lang="{{.Locale}}">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 64px auto; padding: 0 16px;">
+<h1 style="font-size: 20px;">{{.Title}}</h1>
+<p>{{.Body}}</p>
+{{if .Action}}<form method="post" action="{{.Action}}"><button type="submit">{{.Button}}</button></form>{{end}}
+</body>
+</html>`))
+
+type unsubscribePageData struct {
+	Locale string
+	Title  string
+	Body   string
+	Button string
+	Action string
+}
+
+// handleUnsubscribe shows a confirmation page for an unsubscribe link. Nothing changes on GET so
+// link scanners that prefetch emails can't unsubscribe users.
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) error {
+	locale := middleware.GetLocale(w)
+
+	category, _, ok := s.parseUnsubscribeToken(r)
+	if !ok {
+		return writeUnsubscribePage(w, http.StatusBadRequest, unsubscribePageData{
+			Locale: locale,
+			Title:  i18n.T(locale, "unsubscribe.invalid_title"),
+			Body:   i18n.T(locale, "unsubscribe.invalid_body"),
+		})
+	}
+
+	return writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{
+		Locale: locale,
+		Title:  i18n.T(locale, "unsubscribe.confirm_title"),
+		Body:   i18n.T(locale, "unsubscribe.confirm_body", categoryName(locale, category.ID)),
+		Button: i18n.T(locale, "unsubscribe.button"),
+		Action: r.URL.RequestURI(),
+	})
+}
+
+// handleOneClickUnsubscribe turns off the category in the token. It serves both the RFC 8058
+// one-click POST from mail clients and the form on the confirmation page.
+func (s *Server) handleOneClickUnsubscribe(w http.ResponseWriter, r *http.Request) error {
+	locale := middleware.GetLocale(w)
+
+	category, userID, ok := s.parseUnsubscribeToken(r)
+	if !ok {
+		return writeUnsubscribePage(w, http.StatusBadRequest, unsubscribePageData{
+			Locale: locale,
+			Title:  i18n.T(locale, "unsubscribe.invalid_title"),
+			Body:   i18n.T(locale, "unsubscribe.invalid_body"),
+		})
+	}
+
+	if err := s.store.SaveNotificationPreferences(r.Context(), userID, map[string]bool{category.ID: false}); err != nil {
+		return writeUnsubscribePage(w, http.StatusInternalServerError, unsubscribePageData{
+			Locale: locale,
+			Title:  i18n.T(locale, "unsubscribe.error_title"),
+			Body:   i18n.T(locale, "unsubscribe.error_body"),
+		})
+	}
+
+	return writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{
+		Locale: locale,
+		Title:  i18n.T(locale, "unsubscribe.done_title"),
+		Body:   i18n.T(locale, "unsubscribe.done_body", categoryName(locale, category.ID), os.Getenv("APP_URL")+"/settings/notifications"),
+	})
+}
+
+func (s *Server) parseUnsubscribeToken(r *http.Request) (models.NotificationCategory, uuid.UUID, bool) {
+	userID, id, err := util.ParseUnsubscribeToken(r.URL.Query().Get("token"))
+	if err != nil {
+		return models.NotificationCategory{}, uuid.Nil, false
+	}
+
+	category, ok := models.GetNotificationCategory(id)
+	if !ok || category.Required {
+		return models.NotificationCategory{}, uuid.Nil, false
+	}
+
+	if _, err := s.store.GetUserByID(r.Context(), userID); err != nil {
+		return models.NotificationCategory{}, uuid.Nil, false
+	}
+
+	return category, userID, true
+}
+
+func writeUnsubscribePage(w http.ResponseWriter, status int, data unsubscribePageData) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	return unsubscribePage.Execute(w, data)
+}
+
+// unsubscribeURL is the one-click unsubscribe endpoint for a user and category. It points at the
+// api rather than the app so mail clients can POST to it directly.
+func unsubscribeURL(userID uuid.UUID, category string) string {
+	return fmt.Sprintf("%s/unsubscribe?token=%s", os.Getenv("API_URL"), url.QueryEscape(util.SignUnsubscribeToken(userID, category)))
+}
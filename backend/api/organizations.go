@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// Organizations are tenant tables, so every handler here reads and writes them through
+// WithTenant. The request picks the organization, but the user only gets to act for it after
+// checking, as themselves, that they're a member.
+
+func (s *Server) handleCreateOrganization(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	createReq := new(models.CreateOrganizationRequest)
+	if err := json.NewDecoder(r.Body).Decode(createReq); err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid request.", Code: "invalid_request"})
+	}
+
+	name := strings.TrimSpace(createReq.Name)
+	if name == "" || len(name) > 100 {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "name must be between 1 and 100 characters.", Code: "invalid_organization_name"})
+	}
+
+	org := &models.Organization{ID: uuid.New(), Name: name}
+	if err := s.store.WithTenant(r.Context(), storage.Tenant{OrgID: org.ID, UserID: user.ID}, func(tx storage.Storage) error {
+		return tx.CreateOrganization(r.Context(), org, user.ID)
+	}); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusCreated, org)
+}
+
+// handleGetOrganizations lists the organizations the user belongs to.
+func (s *Server) handleGetOrganizations(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	var orgs []*models.Organization
+	if err := s.store.WithTenant(r.Context(), storage.Tenant{UserID: user.ID}, func(tx storage.Storage) error {
+		orgs, err = tx.GetOrganizations(r.Context())
+		return err
+	}); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if orgs == nil {
+		orgs = []*models.Organization{}
+	}
+	return WriteJSON(w, http.StatusOK, orgs)
+}
+
+func (s *Server) handleGetOrganizationMembers(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "organization not found.", Code: "organization_not_found"})
+	}
+
+	var members []*models.OrganizationMembership
+	err = s.store.WithTenant(r.Context(), storage.Tenant{UserID: user.ID}, func(tx storage.Storage) error {
+		// as just the user, only organizations they belong to are visible
+		if _, err := tx.GetOrganization(r.Context(), id); err != nil {
+			return err
+		}
+
+		return tx.WithTenant(r.Context(), storage.Tenant{OrgID: id, UserID: user.ID}, func(tx storage.Storage) error {
+			members, err = tx.GetOrganizationMembers(r.Context(), id)
+			return err
+		})
+	})
+	if errors.Is(err, storage.ErrOrganizationNotFound) {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "organization not found.", Code: "organization_not_found"})
+	}
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusOK, members)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/colecaccamise/go-backend/mail"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+func (s *Server) handleGetOutboxEmails(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.OutboxDead
+	}
+
+	if status != models.OutboxPending && status != models.OutboxSending && status != models.OutboxSent && status != models.OutboxDead && status != models.OutboxSkipped {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "status must be pending, sending, sent, dead or skipped.", Code: "invalid_email_status"})
+	}
+
+	outboxEmails, err := s.store.GetOutboxEmailsByStatus(r.Context(), status, 100)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if outboxEmails == nil {
+		outboxEmails = []*models.OutboxEmail{}
+	}
+
+	return WriteJSON(w, http.StatusOK, outboxEmails)
+}
+
+func (s *Server) handleRetryOutboxEmail(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "invalid email id.", Code: "invalid_id"})
+	}
+
+	if err := s.store.RetryOutboxEmail(r.Context(), id); err != nil {
+		return WriteJSON(w, http.StatusNotFound, Error{Error: "failed email not found.", Code: "outbox_email_not_found"})
+	}
+
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+// DeliverOutbox sends due outbox emails until the queue is drained or a batch fails.
+func (s *Server) DeliverOutbox(ctx context.Context) error {
+	for {
+		processed, err := s.store.ProcessOutbox(ctx, 20, s.deliverOutboxEmail, outboxRetryAt)
+		if err != nil {
+			return err
+		}
+		if processed == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Server) deliverOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	err := s.mailer.Send(ctx, &mail.Message{
+		To:       email.To,
+		Subject:  email.Subject,
+		HTML:     email.HTML,
+		Text:     email.Text,
+		Headers:  email.Headers,
+		Category: email.Category,
+		// the outbox id, so a retry after a lost result isn't sent twice
+		IdempotencyKey: email.ID.String(),
+	})
+	if err != nil {
+		fmt.Printf("Error sending outbox email %s (attempt %d): %v\n", email.ID, email.Attempts, err)
+	}
+	return outboxError(err)
+}
+
+// outboxError lets the outbox dead-letter emails to suppressed addresses and drop emails the
+// recipient opted out of instead of retrying them.
+func outboxError(err error) error {
+	if errors.Is(err, mail.ErrOptedOut) {
+		return fmt.Errorf("%w: %v", storage.ErrSkipped, err)
+	}
+	if errors.Is(err, mail.ErrSuppressed) {
+		return fmt.Errorf("%w: %v", storage.ErrUndeliverable, err)
+	}
+	return err
+}
+
+// outboxRetryAt backs off exponentially from OUTBOX_RETRY_BASE_SECONDS, capped at six hours, and
+// dead-letters the email after OUTBOX_MAX_ATTEMPTS.
+func outboxRetryAt(attempts int) *time.Time {
+	if attempts >= util.GetEnvInt("OUTBOX_MAX_ATTEMPTS", 8) {
+		return nil
+	}
+
+	base := time.Duration(util.GetEnvInt("OUTBOX_RETRY_BASE_SECONDS", 30)) * time.Second
+	delay := time.Duration(float64(base) * math.Pow(2, float64(attempts-1)))
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+
+	next := time.Now().Add(delay)
+	return &next
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+)
+
+func (s *Server) handleGetPayoutBatches(w http.ResponseWriter, r *http.Request) error {
+	batches, err := s.store.GetPayoutBatches(r.Context())
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if batches == nil {
+		batches = []*models.PayoutBatch{}
+	}
+
+	return WriteJSON(w, http.StatusOK, batches)
+}
+
+func (s *Server) handleCreatePayoutBatch(w http.ResponseWriter, r *http.Request) error {
+	batch, err := s.createPayoutBatch(r.Context(), time.Now())
+	if errors.Is(err, storage.ErrPayoutBatchExists) {
+		return WriteJSON(w, http.StatusConflict, Error{Error: "a payout batch already exists for last month.", Code: "payout_batch_exists"})
+	}
+	if errors.Is(err, storage.ErrNoApprovedCommissions) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "there are no approved commissions to pay out.", Code: "no_approved_commissions"})
+	}
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	return WriteJSON(w, http.StatusCreated, Response{Message: "payout batch created.", Code: "payout_batch_created", Data: batch})
+}
+
+func (s *Server) handleGetPayoutBatch(w http.ResponseWriter, r *http.Request) error {
+	batch, apiErr := s.getPayoutBatch(r)
+	if apiErr != nil {
+		return WriteJSON(w, http.StatusNotFound, *apiErr)
+	}
+
+	payouts, err := s.store.GetAffiliatePayouts(r.Context(), batch.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	if payouts == nil {
+		payouts = []*models.AffiliatePayout{}
+	}
+
+	return WriteJSON(w, http.StatusOK, models.PayoutBatchResponse{PayoutBatch: *batch, Payouts: payouts})
+}
+
+func (s *Server) handleExportPayoutBatch(w http.ResponseWriter, r *http.Request) error {
+	batch, apiErr := s.getPayoutBatch(r)
+	if apiErr != nil {
+		return WriteJSON(w, http.StatusNotFound, *apiErr)
+	}
+
+	payouts, err := s.store.GetAffiliatePayouts(r.Context(), batch.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	rows := [][]string{{"affiliate_id", "affiliate_code", "email", "amount", "currency", "commissions", "period_start", "period_end"}}
+	for _, payout := range payouts {
+		affiliate, err := s.store.GetAffiliateByID(r.Context(), payout.AffiliateID)
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		var email string
+		if user, err := s.store.GetUserByIDFromReplica(r.Context(), affiliate.UserID); err == nil {
+			email = user.Email
+		}
+
+		rows = append(rows, []string{
+			affiliate.ID.String(),
+			affiliate.Code,
+			email,
+			formatDecimal(payout.Amount, payout.Currency),
+			payout.Currency,
+			strconv.FormatInt(payout.CommissionCount, 10),
+			batch.PeriodStart.Format("2006-01-02"),
+			batch.PeriodEnd.Format("2006-01-02"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"payouts-%s.csv\"", batch.PeriodStart.Format("2006-01")))
+	w.WriteHeader(http.StatusOK)
+
+	return csv.NewWriter(w).WriteAll(rows)
+}
+
+func (s *Server) handleMarkPayoutBatchPaid(w http.ResponseWriter, r *http.Request) error {
+	batch, apiErr := s.getPayoutBatch(r)
+	if apiErr != nil {
+		return WriteJSON(w, http.StatusNotFound, *apiErr)
+	}
+
+	if batch.Status == models.PayoutBatchPaid {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "this payout batch has already been paid.", Code: "payout_batch_paid"})
+	}
+
+	if err := s.store.MarkPayoutBatchPaid(r.Context(), batch); err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	payouts, err := s.store.GetAffiliatePayouts(r.Context(), batch.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	for _, payout := range payouts {
+		if err := s.sendPayoutStatement(r.Context(), batch, payout); err != nil {
+			fmt.Printf("Error sending payout statement to affiliate %s: %v\n", payout.AffiliateID, err)
+		}
+	}
+
+	return WriteJSON(w, http.StatusOK, Response{Message: "payout batch marked as paid.", Code: "payout_batch_paid", Data: batch})
+}
+
+func (s *Server) getPayoutBatch(r *http.Request) (*models.PayoutBatch, *Error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, &Error{Error: "payout batch not found.", Code: "payout_batch_not_found"}
+	}
+
+	batch, err := s.store.GetPayoutBatchByID(r.Context(), id)
+	if err != nil {
+		return nil, &Error{Error: "payout batch not found.", Code: "payout_batch_not_found"}
+	}
+
+	return batch, nil
+}
+
+// handleChargeRefunded reverses the refunded share of the affiliate commission earned on an invoice.
+// Stripe sends the charge's refunded total with every refund, so partial refunds are prorated and
+// a repeated event reverses nothing more.
+func (s *Server) handleChargeRefunded(ctx context.Context, charge *stripe.Charge) error {
+	if charge.AmountRefunded == 0 || charge.Invoice == nil {
+		return nil
+	}
+
+	reversed, err := s.store.ReverseCommission(ctx, charge.Invoice.ID, charge.AmountRefunded, charge.Amount)
+	if err != nil {
+		return err
+	}
+
+	if reversed > 0 {
+		fmt.Printf("Reversed %s of affiliate commission for refunded invoice %s\n", formatAmount(reversed, string(charge.Currency)), charge.Invoice.ID)
+	}
+
+	return nil
+}
+
+// ProcessPayouts approves commissions once they're past the refund hold window and opens
+// the payout batch for the previous month.
+func (s *Server) ProcessPayouts(ctx context.Context) error {
+	now := time.Now()
+
+	holdDays := util.GetEnvInt("AFFILIATE_HOLD_DAYS", 30)
+	if _, err := s.store.ApproveCommissions(ctx, now.AddDate(0, 0, -holdDays)); err != nil {
+		return err
+	}
+
+	_, err := s.createPayoutBatch(ctx, now)
+	if errors.Is(err, storage.ErrPayoutBatchExists) || errors.Is(err, storage.ErrNoApprovedCommissions) {
+		return nil
+	}
+
+	return err
+}
+
+// createPayoutBatch batches approved commissions for the month before now.
+func (s *Server) createPayoutBatch(ctx context.Context, now time.Time) (*models.PayoutBatch, error) {
+	currentStart, _ := models.UsagePeriod(now)
+	periodStart, periodEnd := models.UsagePeriod(currentStart.AddDate(0, 0, -1))
+
+	return s.store.CreatePayoutBatch(ctx, periodStart, periodEnd)
+}
+
+func (s *Server) sendPayoutStatement(ctx context.Context, batch *models.PayoutBatch, payout *models.AffiliatePayout) error {
+	affiliate, err := s.store.GetAffiliateByID(ctx, payout.AffiliateID)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.GetUserByID(ctx, affiliate.UserID)
+	if err != nil {
+		return err
+	}
+
+	return s.queueUserTemplate(ctx, user, models.NotificationAffiliate, emails.AffiliateStatement, emails.AffiliateStatementData{
+		Period:      batch.PeriodStart,
+		Amount:      formatAmount(payout.Amount, payout.Currency),
+		Commissions: payout.CommissionCount,
+		URL:         fmt.Sprintf("%s/settings/affiliates", os.Getenv("APP_URL")),
+	})
+}
@@ -2,17 +2,25 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/httprate"
 
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/jobs"
+	"github.com/colecaccamise/go-backend/mail"
 	"github.com/colecaccamise/go-backend/middleware"
 	"github.com/colecaccamise/go-backend/models"
 	"github.com/colecaccamise/go-backend/storage"
@@ -24,7 +32,6 @@ import (
 	"github.com/h2non/filetype"
 	"github.com/rs/cors"
 	"github.com/stripe/stripe-go/v80"
-	"github.com/stripe/stripe-go/v80/subscription"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -32,6 +39,10 @@ type Error struct {
 	Message string `json:"message"`
 	Error   string `json:"error"`
 	Code    string `json:"code,omitempty"`
+
+	// localized keeps an Error the handler already translated, with more detail than the
+	// catalog's text for its Code.
+	localized bool
 }
 
 type Response struct {
@@ -43,21 +54,47 @@ type Response struct {
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
 type Server struct {
-	listenAddr string
-	store      storage.Storage
+	listenAddr    string
+	store         storage.Storage
+	mailer        mail.Mailer
+	notifications *notificationHub
+	jobs          *jobs.Runner
 }
 
-func NewServer(listenAddr string, store storage.Storage) *Server {
+func NewServer(listenAddr string, store storage.Storage, mailer mail.Mailer) *Server {
 	return &Server{
-		listenAddr: listenAddr,
-		store:      store,
+		listenAddr:    listenAddr,
+		store:         store,
+		mailer:        mailer,
+		notifications: newNotificationHub(),
 	}
 }
 
+// inTx runs fn against a copy of the server whose store is a transaction, so store writes and
+// the emails queued through it commit or roll back together.
+func (s *Server) inTx(ctx context.Context, fn func(tx *Server) error) error {
+	return s.store.WithTx(ctx, func(store storage.Storage) error {
+		tx := *s
+		tx.store = store
+		return fn(&tx)
+	})
+}
+
 func (s *Server) Start() error {
 	r := chi.NewRouter()
 	stripe.Key = os.Getenv("STRIPE_KEY")
 
+	go s.notifications.listen(context.Background(), s.store)
+
+	// bound each billing provider call, stripe retries failed calls on its own
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		HTTPClient: &http.Client{Timeout: time.Duration(util.GetEnvInt("STRIPE_TIMEOUT_SECONDS", 15)) * time.Second},
+	}))
+
+	// every route gets a deadline except the notification stream, which is long-lived by design and
+	// ends when the client disconnects
+	timeout := middleware.Timeout(time.Duration(util.GetEnvInt("REQUEST_TIMEOUT_SECONDS", 30)) * time.Second)
+
 	r.NotFound(makeHttpHandleFunc(handleNotFound))
 	r.MethodNotAllowed(makeHttpHandleFunc(handleMethodNotAllowed))
 
@@ -68,6 +105,7 @@ func (s *Server) Start() error {
 	r.Use(httprate.LimitByIP(100, 1*time.Minute))
 
 	r.Route("/auth", func(r chi.Router) {
+		r.Use(timeout)
 		r.Post("/signup", makeHttpHandleFunc(s.handleSignup))
 		r.Post("/resend-email", makeHttpHandleFunc(s.handleResendEmail))
 		r.Post("/login", makeHttpHandleFunc(s.handleLogin))
@@ -79,14 +117,19 @@ func (s *Server) Start() error {
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
 		r.Get("/auth/identity", makeHttpHandleFunc(s.handleIdentity))
 		r.Get("/auth/refresh", makeHttpHandleFunc(s.handleRefreshToken))
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(s.VerifyUserNotDeleted)
 		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Use(s.MeterApiUsage)
 		r.Route("/tokens", func(r chi.Router) {
 			r.Get("/", makeHttpHandleFunc(s.handleGetAllTokens))
 			// r.Post("/", makeHttpHandleFunc(s.handleCreateToken))
@@ -95,9 +138,11 @@ func (s *Server) Start() error {
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(middleware.VerifyAuth)
 		r.Use(s.VerifyUserNotDeleted)
 		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
 		r.Post("/auth/verify-password", makeHttpHandleFunc(s.handleVerifyPassword))
 	})
 
@@ -118,9 +163,12 @@ func (s *Server) Start() error {
 
 	// user taking actions on their own account they're logged in to
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(middleware.VerifyAuth)
 		r.Use(s.VerifyUserNotDeleted)
 		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Use(s.MeterApiUsage)
 		r.Route("/users", func(r chi.Router) {
 			r.Patch("/", makeHttpHandleFunc(s.handleUpdateUser))
 			r.Delete("/", makeHttpHandleFunc(s.handleDeleteUser))
@@ -129,26 +177,128 @@ func (s *Server) Start() error {
 			r.Patch("/avatar", makeHttpHandleFunc(s.handleUploadAvatar))
 			r.Delete("/avatar", makeHttpHandleFunc(s.handleDeleteAvatar))
 			r.Patch("/change-password", makeHttpHandleFunc(s.handleChangeUserPassword))
+			r.Get("/notifications", makeHttpHandleFunc(s.handleGetNotificationPreferences))
+			r.Patch("/notifications", makeHttpHandleFunc(s.handleUpdateNotificationPreferences))
 		})
 	})
 
 	// user actions that can be taken when deleted
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(middleware.VerifyAuth)
 		r.Patch("/users/restore", makeHttpHandleFunc(s.handleRestoreUser))
 	})
 
 	// subscription routes
 	r.Group(func(r chi.Router) {
+		r.Use(timeout)
 		r.Use(middleware.VerifyAuth)
 		r.Use(s.VerifyUserNotDeleted)
 		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Use(s.MeterApiUsage)
 		r.Get("/subscriptions", makeHttpHandleFunc(s.handleGetSubscriptions))
+		r.Get("/usage", makeHttpHandleFunc(s.handleGetUsage))
+		r.Post("/billing/trial", makeHttpHandleFunc(s.handleStartTrial))
+		r.Post("/billing/checkout", makeHttpHandleFunc(s.handleCheckout))
+		r.Post("/billing/coupons/validate", makeHttpHandleFunc(s.handleValidateCoupon))
+		r.Get("/billing/invoices", makeHttpHandleFunc(s.handleGetInvoices))
+		r.Get("/billing/invoices/{id}/pdf", makeHttpHandleFunc(s.handleGetInvoicePDF))
+		r.Get("/billing/profile", makeHttpHandleFunc(s.handleGetBillingProfile))
+		r.Patch("/billing/profile", makeHttpHandleFunc(s.handleUpdateBillingProfile))
+	})
+
+	// notification center routes
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.VerifyAuth)
+		r.Use(s.VerifyUserNotDeleted)
+		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Route("/notifications", func(r chi.Router) {
+			r.Get("/stream", makeHttpHandleFunc(s.handleNotificationStream))
+			r.Group(func(r chi.Router) {
+				r.Use(timeout)
+				r.Get("/", makeHttpHandleFunc(s.handleGetNotifications))
+				r.Post("/read", makeHttpHandleFunc(s.handleMarkAllNotificationsRead))
+				r.Post("/{id}/read", makeHttpHandleFunc(s.handleMarkNotificationRead))
+			})
+		})
+	})
+
+	// organization routes
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+		r.Use(middleware.VerifyAuth)
+		r.Use(s.VerifyUserNotDeleted)
+		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Route("/organizations", func(r chi.Router) {
+			r.Get("/", makeHttpHandleFunc(s.handleGetOrganizations))
+			r.Post("/", makeHttpHandleFunc(s.handleCreateOrganization))
+			r.Get("/{id}/members", makeHttpHandleFunc(s.handleGetOrganizationMembers))
+		})
+	})
+
+	// affiliate routes
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+		r.Use(middleware.VerifyAuth)
+		r.Use(s.VerifyUserNotDeleted)
+		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Post("/affiliates", makeHttpHandleFunc(s.handleCreateAffiliate))
+		r.Get("/affiliates/dashboard", makeHttpHandleFunc(s.handleGetAffiliateDashboard))
+	})
+
+	// admin routes
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+		r.Use(middleware.VerifyAuth)
+		r.Use(s.VerifyUserNotDeleted)
+		r.Use(s.VerifySecurityVersion)
+		r.Use(s.UserLocale)
+		r.Use(s.VerifyAdmin)
+		r.Route("/admin", func(r chi.Router) {
+			r.Get("/users", makeHttpHandleFunc(s.handleGetAllUsers))
+			r.Post("/users/reencrypt", makeHttpHandleFunc(s.handleReencryptUsers))
+			r.Get("/coupons", makeHttpHandleFunc(s.handleGetAllCoupons))
+			r.Post("/coupons", makeHttpHandleFunc(s.handleCreateCoupon))
+			r.Delete("/coupons/{id}", makeHttpHandleFunc(s.handleDeactivateCoupon))
+			r.Get("/emails", makeHttpHandleFunc(s.handleGetEmailTemplates))
+			r.Get("/emails/{name}", makeHttpHandleFunc(s.handlePreviewEmail))
+			r.Get("/outbox", makeHttpHandleFunc(s.handleGetOutboxEmails))
+			r.Post("/outbox/{id}/retry", makeHttpHandleFunc(s.handleRetryOutboxEmail))
+			r.Get("/jobs", makeHttpHandleFunc(s.handleGetJobs))
+			r.Post("/jobs/{id}/retry", makeHttpHandleFunc(s.handleRetryJob))
+			r.Get("/email-suppressions", makeHttpHandleFunc(s.handleGetEmailSuppressions))
+			r.Delete("/email-suppressions/{id}", makeHttpHandleFunc(s.handleDeleteEmailSuppression))
+			r.Get("/payouts", makeHttpHandleFunc(s.handleGetPayoutBatches))
+			r.Post("/payouts", makeHttpHandleFunc(s.handleCreatePayoutBatch))
+			r.Get("/payouts/{id}", makeHttpHandleFunc(s.handleGetPayoutBatch))
+			r.Get("/payouts/{id}/csv", makeHttpHandleFunc(s.handleExportPayoutBatch))
+			r.Post("/payouts/{id}/paid", makeHttpHandleFunc(s.handleMarkPayoutBatchPaid))
+		})
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+
+		// provider webhooks, verified by signature
+		r.Post("/billing/webhook", makeHttpHandleFunc(s.handleStripeWebhook))
+		r.Post("/webhooks/email", makeHttpHandleFunc(s.handleEmailWebhook))
+		r.Get("/unsubscribe", makeHttpHandleFunc(s.handleUnsubscribe))
+
+		// affiliate links
+		r.Get("/r/{code}", makeHttpHandleFunc(s.handleAffiliateLink))
+		r.Post("/unsubscribe", makeHttpHandleFunc(s.handleOneClickUnsubscribe))
 	})
 
 	stack := middleware.CreateStack(
 		middleware.Logging,
 		middleware.Nosniff,
+		middleware.Affiliate(s.store),
+		middleware.Locale,
+		middleware.Authenticate(s.store),
 	)
 
 	fmt.Println("Server is running on port", s.listenAddr)
@@ -158,7 +308,7 @@ func (s *Server) Start() error {
 		AllowCredentials: true,
 		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
-		ExposedHeaders:   []string{"Content-Type", "Location"},
+		ExposedHeaders:   []string{"Content-Type", "Location", "ETag", "Link"},
 		AllowOriginFunc: func(origin string) bool {
 			if os.Getenv("ENVIRONMENT") == "development" {
 				return origin == "http://localhost:3000" || origin == "http://localhost:8000"
@@ -179,7 +329,7 @@ func (s *Server) VerifyUserNotDeleted(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, _, err := getUserIdentity(s, r)
 		if err != nil {
-			_ = WriteJSON(w, http.StatusUnauthorized, Error{Message: "unauthorized", Error: err.Error()})
+			_ = WriteJSON(w, http.StatusUnauthorized, Error{Message: "unauthorized", Error: err.Error(), Code: "invalid_token"})
 			return
 		}
 
@@ -195,6 +345,34 @@ func (s *Server) VerifyUserNotDeleted(next http.Handler) http.Handler {
 	})
 }
 
+func (s *Server) VerifyAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, err := getUserIdentity(s, r)
+		if err != nil {
+			_ = WriteJSON(w, http.StatusUnauthorized, Error{Message: "unauthorized", Error: err.Error(), Code: "invalid_token"})
+			return
+		}
+
+		if !user.IsAdmin {
+			_ = WriteJSON(w, http.StatusForbidden, Error{Error: "you do not have permission to perform this action.", Code: "forbidden"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UserLocale switches the response locale to the authenticated user's saved preference.
+func (s *Server) UserLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, err := getUserIdentity(s, r); err == nil && user.Locale != "" {
+			middleware.SetLocale(w, user.Locale)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) VerifySecurityVersion(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, _, err := getUserIdentity(s, r)
@@ -299,11 +477,11 @@ func (s *Server) VerifySecurityVersion(next http.Handler) http.Handler {
 }
 
 func handleNotFound(w http.ResponseWriter, req *http.Request) error {
-	return WriteJSON(w, http.StatusNotFound, Error{Message: fmt.Sprintf("cannot %s %s", req.Method, req.URL.Path), Error: "route not found"})
+	return WriteJSON(w, http.StatusNotFound, Error{Message: fmt.Sprintf("cannot %s %s", req.Method, req.URL.Path), Error: "route not found", Code: "route_not_found"})
 }
 
 func handleMethodNotAllowed(w http.ResponseWriter, req *http.Request) error {
-	return WriteJSON(w, http.StatusMethodNotAllowed, Error{Message: fmt.Sprintf("cannot %s %s", req.Method, req.URL.Path), Error: "method not allowed"})
+	return WriteJSON(w, http.StatusMethodNotAllowed, Error{Message: fmt.Sprintf("cannot %s %s", req.Method, req.URL.Path), Error: "method not allowed", Code: "method_not_allowed"})
 }
 
 func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) error {
@@ -321,7 +499,7 @@ func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// If valid, return user
-	userData, err := s.store.GetUserByID(uuid.MustParse(userId))
+	userData, err := s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 	if err != nil {
 		http.SetCookie(w, &http.Cookie{
 			Name:     "auth-token",
@@ -336,27 +514,35 @@ func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) error {
 
 	userIdentity := models.NewUserIdentityResponse(userData)
 
+	// include trial and grace period state for dashboard banners
+	sub, _ := s.store.GetSubscriptionByUserID(r.Context(), userData.ID)
+	userIdentity.Subscription = models.NewSubscriptionState(sub)
+
+	// prompt the user to fix an address that bounced or complained
+	userIdentity.EmailUndeliverable, _ = s.store.IsEmailSuppressed(r.Context(), userData.Email)
+
+	w.Header().Set("ETag", userETag(userData))
 	return WriteJSON(w, http.StatusOK, userIdentity)
 }
 
 func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) error {
 	refreshToken, err := r.Cookie("refresh-token")
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	userId, authTokenType, err := util.ParseJWT(refreshToken.Value)
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	if authTokenType != "refresh" {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: "unauthorized"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: "unauthorized", Code: "invalid_token"})
 	}
 
-	user, err := s.store.GetUserByID(uuid.MustParse(userId))
+	user, err := s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	authToken, err := generateToken(user, "auth")
@@ -385,42 +571,33 @@ func (s *Server) handleSignup(w http.ResponseWriter, r *http.Request) error {
 		if err == io.EOF {
 			errorMsg = "request body is empty"
 		}
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMsg})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMsg, Code: "invalid_request"})
 	}
 
 	if signupReq.Email == "" || signupReq.Password == "" {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "email and password are required"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "email and password are required", Code: "missing_credentials"})
+	}
+
+	existingUser, _ := s.store.GetUserByEmail(r.Context(), signupReq.Email)
+	if existingUser != nil && isExpiredUnconfirmed(existingUser, time.Now()) {
+		// the address is held by a signup that never confirmed it, release it
+		err := s.expireUnconfirmedUser(r.Context(), existingUser)
+		if err != nil && !errors.Is(err, storage.ErrUserConfirmed) {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+		if err == nil {
+			existingUser = nil
+		}
 	}
 
-	existingUser, _ := s.store.GetUserByEmail(signupReq.Email)
 	if existingUser != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "cannot signup", Error: "an account with this email already exists"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "cannot signup", Error: "an account with this email already exists", Code: "email_taken"})
 	}
 
 	eightOrMore, number, upper, special := util.ValidatePassword(signupReq.Password)
 
-	var errorMessages []string
-	if !eightOrMore {
-		errorMessages = append(errorMessages, "be at least 8 characters long")
-	}
-	if !number {
-		errorMessages = append(errorMessages, "contain at least one number")
-	}
-	if !upper {
-		errorMessages = append(errorMessages, "contain at least one uppercase letter")
-	}
-	if !special {
-		errorMessages = append(errorMessages, "contain at least one special character")
-	}
-
-	if len(errorMessages) > 0 {
-		errorMessage := "Password must " + strings.Join(errorMessages, ", ")
-		if len(errorMessages) > 1 {
-			lastIndex := len(errorMessages) - 1
-			errorMessage = strings.Join(errorMessages[:lastIndex], ", ") + ", and " + errorMessages[lastIndex]
-			errorMessage = "Password must " + errorMessage
-		}
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMessage})
+	if errorMessage := passwordRequirementsMessage(middleware.GetLocale(w), eightOrMore, number, upper, special); errorMessage != "" {
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMessage, Code: "weak_password", localized: true})
 	}
 
 	hashedPassword, err := hashAndSaltPassword(signupReq.Password)
@@ -433,18 +610,32 @@ func (s *Server) handleSignup(w http.ResponseWriter, r *http.Request) error {
 		Email:          signupReq.Email,
 		HashedPassword: hashedPassword,
 	})
+	user.ID = uuid.New()
+	user.Locale = middleware.GetLocale(w)
 
-	// store user object in db
-	if err := s.store.CreateUser(user); err != nil {
+	// generate auth confirmation token
+	confirmationToken, err := generateToken(user, "email_confirmation")
+	if err != nil {
 		return err
 	}
 
-	// generate auth confirmation token
-	confirmationToken, err := generateToken(user, "email_confirmation")
+	confirmationUrl := fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), confirmationToken)
+
+	confirmationEmail, err := newTemplateEmail(signupReq.Email, user.Locale, emails.ConfirmEmail, emails.LinkData{URL: confirmationUrl})
 	if err != nil {
 		return err
 	}
 
+	// store user object in db, queueing the confirmation email with it
+	if err := s.store.CreateUser(r.Context(), user, confirmationEmail); err != nil {
+		return err
+	}
+
+	// attribute signup to the affiliate link the user arrived from
+	if err := s.attributeAffiliate(w, r, user); err != nil {
+		fmt.Printf("Error attributing affiliate referral: %v\n", err)
+	}
+
 	// generate email resend token
 	emailResendToken, err := generateToken(user, "email_resend")
 	if err != nil {
@@ -461,15 +652,6 @@ func (s *Server) handleSignup(w http.ResponseWriter, r *http.Request) error {
 		SameSite: http.SameSiteLaxMode,
 	})
 
-	confirmationUrl := fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), confirmationToken)
-
-	// send confirmation email
-	err = util.SendEmail(signupReq.Email, "Confirm your email", fmt.Sprintf("Click here to confirm your email: %s", confirmationUrl))
-
-	if err != nil {
-		return err
-	}
-
 	// generate auth tokens
 	authToken, err := generateToken(user, "auth")
 	if err != nil {
@@ -511,23 +693,23 @@ func (s *Server) handleResendEmail(w http.ResponseWriter, r *http.Request) error
 	authToken, err := r.Cookie("auth-token")
 
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	userId, authTokenType, err := util.ParseJWT(authToken.Value)
 	if err != nil || authTokenType != "auth" {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "user is not authenticated", Error: err.Error(), Code: "invalid_token"})
 	}
 
-	user, err := s.store.GetUserByID(uuid.MustParse(userId))
+	user, err := s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 	if err != nil {
 		fmt.Printf("Error getting user: %v\n", err)
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	// verify users email isn't already confirmed
 	if user.EmailConfirmedAt != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "email already confirmed", Error: "email already confirmed"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "email already confirmed", Error: "email already confirmed", Code: "email_already_confirmed"})
 	}
 
 	emailConfirmationToken, err := generateToken(user, "email_confirmation")
@@ -538,10 +720,10 @@ func (s *Server) handleResendEmail(w http.ResponseWriter, r *http.Request) error
 
 	confirmationUrl := fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), emailConfirmationToken)
 
-	err = util.SendEmail(user.Email, "Confirm your email", fmt.Sprintf("Click here to confirm your email: %s", confirmationUrl))
+	err = s.queueTemplate(r.Context(), user.Email, emailLocale(w, user), emails.ResendConfirmation, emails.LinkData{URL: confirmationUrl})
 
 	if err != nil {
-		fmt.Printf("Error sending email: %v\n", err)
+		fmt.Printf("Error queueing email: %v\n", err)
 		return err
 	}
 
@@ -555,7 +737,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request.", Error: "empty body.", Code: "empty_body"})
 	}
 
-	user, err := s.store.GetUserByEmail(loginReq.Email)
+	user, err := s.store.GetUserByEmail(r.Context(), loginReq.Email)
 
 	if err != nil {
 		// simulate work with random time between 40 and 90ms
@@ -636,11 +818,11 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) error {
 func (s *Server) handleConfirmEmailToken(w http.ResponseWriter, r *http.Request) error {
 	tokenReq := new(models.ConfirmEmailTokenRequest)
 	if err := json.NewDecoder(r.Body).Decode(tokenReq); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_request"})
 	}
 
 	if tokenReq.Token == "" {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "token is required"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "token is required", Code: "missing_token"})
 	}
 
 	token, err := jwt.Parse(tokenReq.Token, func(token *jwt.Token) (any, error) {
@@ -656,17 +838,17 @@ func (s *Server) handleConfirmEmailToken(w http.ResponseWriter, r *http.Request)
 
 	userId, ok := token.Claims.(jwt.MapClaims)["user_id"]
 	if !ok {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token", Error: "token is invalid or expired.", Code: "invalid_token"})
 	}
 
 	uuid, err := uuid.Parse(userId.(string))
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token", Error: "token is invalid or expired.", Code: "invalid_token"})
 	}
 
-	user, err := s.store.GetUserByID(uuid)
+	user, err := s.store.GetUserByID(r.Context(), uuid)
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid token", Error: "token is invalid or expired.", Code: "invalid_token"})
 	}
 
 	// update security version
@@ -674,10 +856,6 @@ func (s *Server) handleConfirmEmailToken(w http.ResponseWriter, r *http.Request)
 
 	user.SecurityVersionChangedAt = &now
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
-	}
-
 	// specify redirect url
 	redirectUrl := fmt.Sprintf("%s/dashboard?message=email_confirmed", os.Getenv("APP_URL"))
 
@@ -685,7 +863,8 @@ func (s *Server) handleConfirmEmailToken(w http.ResponseWriter, r *http.Request)
 	successMessage := "email confirmed successfully."
 	successCode := "email_confirmed"
 
-	if user.UpdatedEmail != "" {
+	emailChanged := user.UpdatedEmail != ""
+	if emailChanged {
 		user.Email = user.UpdatedEmail
 		user.UpdatedEmail = ""
 		user.UpdatedEmailConfirmedAt = &now
@@ -696,8 +875,21 @@ func (s *Server) handleConfirmEmailToken(w http.ResponseWriter, r *http.Request)
 		user.EmailConfirmedAt = &now
 	}
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return err
+	err = s.inTx(r.Context(), func(tx *Server) error {
+		if err := tx.store.UpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+
+		if !emailChanged {
+			return nil
+		}
+		return tx.store.CreateAuditEvent(r.Context(), &models.AuditEvent{Action: models.AuditEmailChanged, UserID: &user.ID})
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -739,7 +931,7 @@ func (s *Server) handleVerifyPassword(w http.ResponseWriter, r *http.Request) er
 	user, _, err := getUserIdentity(s, r)
 
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid credentials", Error: "unauthorized"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "invalid credentials", Error: "unauthorized", Code: "unauthorized"})
 	}
 
 	passwordMatches := comparePasswords(user.HashedPassword, verifyPasswordRequest.Password)
@@ -779,7 +971,7 @@ func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) er
 	}
 
 	// check if email exists in system
-	user, err := s.store.GetUserByEmail(email)
+	user, err := s.store.GetUserByEmail(r.Context(), email)
 
 	if user == nil {
 		// simulate work with random time between 300 and 700ms
@@ -802,8 +994,10 @@ func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) er
 	// send email
 	resetPasswordUrl := fmt.Sprintf("%s/auth/change-password?token=%s", os.Getenv("APP_URL"), forgotPasswordToken)
 
-	// todo warn about error
-	_ = util.SendEmail(user.Email, "Reset your dashboard password", fmt.Sprintf("Click here to reset your password: %s", resetPasswordUrl))
+	if err := s.queueTemplate(r.Context(), user.Email, emailLocale(w, user), emails.ResetPassword, emails.LinkData{URL: resetPasswordUrl}); err != nil {
+		fmt.Printf("Error queueing password reset email: %v\n", err)
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
 
 	return WriteJSON(w, http.StatusOK, Response{Message: "password reset link sent.", Code: "password_reset_sent"})
 }
@@ -832,7 +1026,7 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) er
 		return WriteJSON(w, http.StatusBadRequest, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
 	}
 
-	user, err := s.store.GetUserByID(uuid.MustParse(userId))
+	user, err := s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 
 	if err != nil {
 		return WriteJSON(w, http.StatusBadRequest, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
@@ -864,28 +1058,8 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) er
 	// validate password strength
 	eightOrMore, number, upper, special := util.ValidatePassword(changePasswordRequest.Password)
 
-	var errorMessages []string
-	if !eightOrMore {
-		errorMessages = append(errorMessages, "be at least 8 characters long")
-	}
-	if !number {
-		errorMessages = append(errorMessages, "contain at least one number")
-	}
-	if !upper {
-		errorMessages = append(errorMessages, "contain at least one uppercase letter")
-	}
-	if !special {
-		errorMessages = append(errorMessages, "contain at least one special character")
-	}
-
-	if len(errorMessages) > 0 {
-		errorMessage := "Password must " + strings.Join(errorMessages, ", ")
-		if len(errorMessages) > 1 {
-			lastIndex := len(errorMessages) - 1
-			errorMessage = strings.Join(errorMessages[:lastIndex], ", ") + ", and " + errorMessages[lastIndex]
-			errorMessage = "Password must " + errorMessage
-		}
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: errorMessage, Error: errorMessage, Code: "weak_password"})
+	if errorMessage := passwordRequirementsMessage(middleware.GetLocale(w), eightOrMore, number, upper, special); errorMessage != "" {
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMessage, Code: "weak_password", localized: true})
 	}
 
 	// hash password
@@ -895,12 +1069,21 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) er
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
 	}
 
+	// update security version so sessions from before the reset stop working
+	now := time.Now()
+
 	user.HashedPassword = hashedPassword
+	user.SecurityVersionChangedAt = &now
 
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.changePassword(r.Context(), user, "reset"); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
 	}
 
+	s.notify(r.Context(), user, models.NotificationPasswordChanged)
+
 	authToken, err := generateToken(user, "auth")
 
 	if err != nil {
@@ -933,16 +1116,23 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) er
 		SameSite: http.SameSiteLaxMode,
 	})
 
-	// update security version
-	now := time.Now()
-
-	user.SecurityVersionChangedAt = &now
+	return WriteJSON(w, http.StatusOK, Response{Message: "password changed.", Code: "password_changed"})
+}
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
-	}
+// changePassword saves a user whose password and security version have been changed, along with
+// an audit event saying how.
+func (s *Server) changePassword(ctx context.Context, user *models.User, method string) error {
+	return s.inTx(ctx, func(tx *Server) error {
+		if err := tx.store.UpdateUser(ctx, user); err != nil {
+			return err
+		}
 
-	return WriteJSON(w, http.StatusOK, Response{Message: "password changed.", Code: "password_changed"})
+		return tx.store.CreateAuditEvent(ctx, &models.AuditEvent{
+			Action:   models.AuditPasswordChanged,
+			UserID:   &user.ID,
+			Metadata: map[string]any{"method": method},
+		})
+	})
 }
 
 func (s *Server) handleDeleteSessions(w http.ResponseWriter, r *http.Request) error {
@@ -953,10 +1143,7 @@ func (s *Server) handleDeleteSessions(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 
-	// update security version
-	user.SecurityVersionChangedAt = &now
-
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.store.RevokeSessions(r.Context(), user.ID, now); err != nil {
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
 	}
 
@@ -994,6 +1181,36 @@ func comparePasswords(hashedPassword, password string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
 }
 
+// passwordRequirementsMessage lists the unmet password requirements in the locale, or returns
+// an empty string when the password is strong enough.
+func passwordRequirementsMessage(locale string, eightOrMore, number, upper, special bool) string {
+	var requirements []string
+	if !eightOrMore {
+		requirements = append(requirements, i18n.T(locale, "password.min_length"))
+	}
+	if !number {
+		requirements = append(requirements, i18n.T(locale, "password.number"))
+	}
+	if !upper {
+		requirements = append(requirements, i18n.T(locale, "password.uppercase"))
+	}
+	if !special {
+		requirements = append(requirements, i18n.T(locale, "password.special"))
+	}
+
+	if len(requirements) == 0 {
+		return ""
+	}
+
+	list := requirements[0]
+	if len(requirements) > 1 {
+		lastIndex := len(requirements) - 1
+		list = strings.Join(requirements[:lastIndex], ", ") + i18n.T(locale, "list.last_separator") + requirements[lastIndex]
+	}
+
+	return i18n.T(locale, "password.must", list)
+}
+
 func generateToken(user *models.User, tokenType string) (string, error) {
 	if tokenType != "auth" && tokenType != "refresh" && tokenType != "reset_password" && tokenType != "email_confirmation" && tokenType != "email_resend" && tokenType != "reset_email" && tokenType != "email_update_confirmation" {
 		return "", fmt.Errorf("invalid token type")
@@ -1005,7 +1222,7 @@ func generateToken(user *models.User, tokenType string) (string, error) {
 			if tokenType == "refresh" {
 				return time.Now().Add(time.Hour * 24 * 90).Unix()
 			}
-			if tokenType == "email_resend" {
+			if tokenType == "email_resend" || tokenType == "email_confirmation" {
 				return time.Now().Add(time.Hour * 24).Unix()
 			}
 			if tokenType == "reset_email" {
@@ -1028,46 +1245,27 @@ func generateToken(user *models.User, tokenType string) (string, error) {
 	return signedAuthToken, nil
 }
 
+// getUserIdentity returns the user middleware.Authenticate identified. The user is loaded once per
+// request, so the middlewares and the handler share it.
 func getUserIdentity(s *Server, r *http.Request) (user *models.User, authType string, err error) {
 	if s == nil || r == nil {
 		return nil, "", fmt.Errorf("both server and request not provided")
 	}
 
-	authToken, e := r.Cookie("auth-token")
-	apiKey := r.Header.Get("X-API-KEY")
-
-	// require either auth token or api key
-	if apiKey == "" && e != nil {
-		return nil, "", e
+	identity := middleware.GetIdentity(r.Context())
+	if identity == nil {
+		return nil, "", fmt.Errorf("no valid authentication method")
 	}
 
-	// handle auth token authentication
-	if authToken != nil {
-		userId, authTokenType, e := util.ParseJWT(authToken.Value)
-		if e != nil && apiKey == "" {
-			return nil, "", e
-		}
-
-		if userId != "" && authTokenType == "auth" {
-			user, err := s.store.GetUserByID(uuid.MustParse(userId))
-			if err != nil && apiKey == "" {
-				return nil, "", err
-			}
-			if user == nil && apiKey == "" {
-				return nil, "", fmt.Errorf("invalid user")
-			}
-			if user != nil {
-				return user, "authToken", nil
-			}
+	if identity.User == nil {
+		user, err := s.store.GetUserByID(r.Context(), identity.UserID)
+		if err != nil {
+			return nil, "", err
 		}
+		identity.User = user
 	}
 
-	// handle api key authentication
-	if apiKey != "" {
-		return nil, "", fmt.Errorf("identity not implemented for api keys")
-	}
-
-	return nil, "", fmt.Errorf("no valid authentication method")
+	return identity.User, identity.AuthType, nil
 }
 
 func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) error {
@@ -1078,32 +1276,99 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) error
 
 	user := models.NewUser(createUserReq)
 
-	if err := s.store.CreateUser(user); err != nil {
+	if err := s.store.CreateUser(r.Context(), user); err != nil {
 		return err
 	}
 
 	return WriteJSON(w, http.StatusOK, user)
 }
 
+// handleGetAllUsers lists users a page at a time. The next page's url is in the Link header and
+// its cursor in next_cursor.
 func (s *Server) handleGetAllUsers(w http.ResponseWriter, r *http.Request) error {
-	users, err := s.store.GetAllUsers()
+	params := r.URL.Query()
+	query := storage.UserQuery{
+		Email:  strings.TrimSpace(params.Get("email")),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+		Limit:  50,
+	}
+
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "limit must be between 1 and 100.", Code: "invalid_limit"})
+		}
+		query.Limit = n
+	}
+
+	if query.Sort != "" && !slices.Contains(storage.UserSorts, query.Sort) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "sort must be one of " + strings.Join(storage.UserSorts, ", ") + ".", Code: "invalid_sort"})
+	}
+
+	for name, filter := range map[string]**bool{"confirmed": &query.Confirmed, "deleted": &query.Deleted, "admin": &query.Admin} {
+		if value := params.Get(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return WriteJSON(w, http.StatusBadRequest, Error{Error: "confirmed, deleted and admin must be true or false.", Code: "invalid_filter"})
+			}
+			*filter = &b
+		}
+	}
+
+	for name, filter := range map[string]**time.Time{"created_after": &query.CreatedAfter, "created_before": &query.CreatedBefore} {
+		if value := params.Get(name); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return WriteJSON(w, http.StatusBadRequest, Error{Error: "created_after and created_before must be RFC 3339 timestamps.", Code: "invalid_date"})
+			}
+			*filter = &at
+		}
+	}
+
+	page, err := s.store.ListUsers(r.Context(), query)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "cursor is invalid. start again from the first page.", Code: "invalid_cursor"})
+	}
+	if errors.Is(err, storage.ErrSearchTooShort) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "email must be at least 3 characters.", Code: "search_too_short"})
+	}
+	if errors.Is(err, storage.ErrTooManyToSort) {
+		return WriteJSON(w, http.StatusBadRequest, Error{Error: "too many users match to sort by email. narrow the filters or sort by created_at.", Code: "sort_too_broad"})
+	}
 	if err != nil {
-		return err
+		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+	}
+
+	res := models.UserListResponse{
+		Users:         make([]*models.AdminUserResponse, 0, len(page.Users)),
+		NextCursor:    page.NextCursor,
+		TotalEstimate: page.TotalEstimate,
+	}
+	for _, user := range page.Users {
+		res.Users = append(res.Users, models.NewAdminUserResponse(user))
+	}
+
+	if page.NextCursor != "" {
+		params.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, params.Encode()))
 	}
-	return WriteJSON(w, http.StatusOK, users)
+
+	return WriteJSON(w, http.StatusOK, res)
 }
 
 func (s *Server) handleGetUserByID(w http.ResponseWriter, r *http.Request) error {
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid id", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid id", Error: err.Error(), Code: "invalid_id"})
 	}
 
-	user, err := s.store.GetUserByID(id)
+	user, err := s.store.GetUserByID(r.Context(), id)
 	if err != nil {
-		return WriteJSON(w, http.StatusNotFound, Error{Message: fmt.Sprintf("cannot %s %s", r.Method, r.URL.Path), Error: "user not found"})
+		return WriteJSON(w, http.StatusNotFound, Error{Message: fmt.Sprintf("cannot %s %s", r.Method, r.URL.Path), Error: "user not found", Code: "user_not_found"})
 	}
 
+	w.Header().Set("ETag", userETag(user))
 	return WriteJSON(w, http.StatusOK, user)
 }
 
@@ -1113,18 +1378,32 @@ func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
+	}
+
 	updateUserReq := new(models.UpdateUserRequest)
 	if err := json.NewDecoder(r.Body).Decode(updateUserReq); err != nil {
 		return err
 	}
 
+	if updateUserReq.Locale != "" {
+		locale := i18n.Normalize(updateUserReq.Locale)
+		if !i18n.IsSupported(locale) {
+			return WriteJSON(w, http.StatusBadRequest, Error{Error: "locale is not supported.", Code: "unsupported_locale"})
+		}
+		user.Locale = locale
+		middleware.SetLocale(w, locale)
+	}
+
 	user.FirstName = updateUserReq.FirstName
 	user.LastName = updateUserReq.LastName
 
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.store.UpdateUser(r.Context(), user); err != nil {
 		return err
 	}
 
+	w.Header().Set("ETag", userETag(user))
 	return WriteJSON(w, http.StatusOK, user)
 }
 
@@ -1134,6 +1413,10 @@ func (s *Server) handleUpdateUserEmail(w http.ResponseWriter, r *http.Request) e
 		return err
 	}
 
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
+	}
+
 	resetEmailToken, err := r.Cookie("reset-email-token")
 	if err != nil {
 		http.SetCookie(w, &http.Cookie{
@@ -1171,7 +1454,7 @@ func (s *Server) handleUpdateUserEmail(w http.ResponseWriter, r *http.Request) e
 	}
 
 	// validate user with requested email doesn't exist
-	existingUser, _ := s.store.GetUserByEmail(updateUserEmailReq.Email)
+	existingUser, _ := s.store.GetUserByEmail(r.Context(), updateUserEmailReq.Email)
 
 	if existingUser != nil {
 		return WriteJSON(w, http.StatusBadRequest, Error{Message: "email taken", Error: "a user with this email already exists", Code: "email_taken"})
@@ -1181,11 +1464,6 @@ func (s *Server) handleUpdateUserEmail(w http.ResponseWriter, r *http.Request) e
 	now := time.Now()
 	user.UpdatedEmailAt = &now
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return err
-	}
-
-	// send email confirmation
 	emailConfirmationToken, err := generateToken(user, "email_update_confirmation")
 	if err != nil {
 		return err
@@ -1193,20 +1471,24 @@ func (s *Server) handleUpdateUserEmail(w http.ResponseWriter, r *http.Request) e
 
 	confirmationUrl := fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), emailConfirmationToken)
 
-	// send confirmation to new email
-	err = util.SendEmail(user.UpdatedEmail, "Confirm your new email", fmt.Sprintf("Please confirm your email by clicking <a href=\"%s\">here</a>", confirmationUrl))
+	// confirmation to new email
+	confirmationEmail, err := newTemplateEmail(user.UpdatedEmail, emailLocale(w, user), emails.EmailChange, emails.EmailChangeData{URL: confirmationUrl, NewEmail: user.UpdatedEmail})
 	if err != nil {
-		fmt.Printf("Error sending email: %v\n", err)
 		return err
 	}
 
-	// send notice to current email
-	err = util.SendEmail(user.Email, "Security Notice: Email Change Request Initiated", fmt.Sprintf("Someone requested to change your email to %s. If this was you, you can safely ignore this email. If it wasn't, please contact support immediately.", user.UpdatedEmail))
+	// notice to current email
+	noticeEmail, err := newTemplateEmail(user.Email, emailLocale(w, user), emails.EmailChangeNotice, emails.EmailChangeNoticeData{NewEmail: user.UpdatedEmail})
 	if err != nil {
-		fmt.Printf("Error sending email: %v\n", err)
 		return err
 	}
 
+	if err := s.store.UpdateUser(r.Context(), user, confirmationEmail, noticeEmail); err != nil {
+		return err
+	}
+
+	s.notify(r.Context(), user, models.NotificationEmailChangeRequested, user.UpdatedEmail)
+
 	// delete email update token after single use
 	http.SetCookie(w, &http.Cookie{
 		Name:     "email-update-token",
@@ -1217,6 +1499,7 @@ func (s *Server) handleUpdateUserEmail(w http.ResponseWriter, r *http.Request) e
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	w.Header().Set("ETag", userETag(user))
 	return WriteJSON(w, http.StatusOK, Response{Message: "email update requested", Data: map[string]string{"updated_email": user.UpdatedEmail, "email": user.Email}})
 }
 
@@ -1244,9 +1527,9 @@ func (s *Server) handleResendUpdateEmail(w http.ResponseWriter, r *http.Request)
 	confirmationUrl := fmt.Sprintf("%s/auth/confirm?token=%s", os.Getenv("APP_URL"), emailConfirmationToken)
 
 	// send confirmation to new email
-	err = util.SendEmail(user.UpdatedEmail, "Confirm your new email", fmt.Sprintf("Please confirm your email by clicking <a href=\"%s\">here</a>", confirmationUrl))
+	err = s.queueTemplate(r.Context(), user.UpdatedEmail, emailLocale(w, user), emails.EmailChange, emails.EmailChangeData{URL: confirmationUrl, NewEmail: user.UpdatedEmail})
 	if err != nil {
-		fmt.Printf("Error sending email: %v\n", err)
+		fmt.Printf("Error queueing email: %v\n", err)
 		return err
 	}
 
@@ -1270,6 +1553,10 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
+	}
+
 	if user.DeletedAt != nil {
 		if !comparePasswords(user.HashedPassword, password) {
 			return WriteJSON(w, http.StatusBadRequest, Error{Error: "password is incorrect.", Code: "invalid_password"})
@@ -1298,21 +1585,25 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) error
 			return WriteJSON(w, http.StatusBadRequest, Error{Error: "password is incorrect.", Code: "invalid_password"})
 		}
 
-		// Send warning email
-		err = util.SendEmail(user.Email, "SECURITY NOTICE: Account Deletion Initiated", "Your account has been deleted. If this was not you, please contact support immediately. After 90 days, this data will no longer be recoverable.")
+		// warning email goes out with the deletion
+		deletionEmail, err := newTemplateEmail(user.Email, emailLocale(w, user), emails.AccountDeletion, emails.AccountDeletionData{RecoveryDays: accountRecoveryDays()})
 		if err != nil {
-			fmt.Printf("Error sending deletion email: %v\n", err)
 			return err
 		}
 
+		// soft delete, the users.purge_deleted job permanently deletes the account once the
+		// recovery window passes
 		now := time.Now()
 		user.DeletedAt = &now
 
-		if err := s.store.UpdateUser(user); err != nil {
+		if err := s.store.UpdateUser(r.Context(), user, deletionEmail); err != nil {
+			if errors.Is(err, storage.ErrConflict) {
+				return err
+			}
 			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
 		}
 
-		// todo set up cron task that watches out for outstanding account deletion requests
+		s.notify(r.Context(), user, models.NotificationAccountDeletionRequested, accountRecoveryDays())
 
 		http.SetCookie(w, &http.Cookie{
 			Name:     "auth-token",
@@ -1350,12 +1641,16 @@ func (s *Server) handleRestoreUser(w http.ResponseWriter, r *http.Request) error
 	now := time.Now()
 	user.RestoredAt = &now
 
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.store.UpdateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error", Code: "internal_server_error"})
 	}
 
 	userData := models.NewUserIdentityResponse(user)
 
+	w.Header().Set("ETag", userETag(user))
 	return WriteJSON(w, http.StatusOK, Response{Message: "user restored", Code: "user_restored", Data: map[string]models.UserIdentityResponse{"user": *userData}})
 }
 
@@ -1363,59 +1658,77 @@ func (s *Server) handleUploadAvatar(w http.ResponseWriter, r *http.Request) erro
 	file, fileHeader, err := r.FormFile("avatar")
 
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_required"})
 	}
 
 	if file == nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "file is required"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "file is required", Code: "file_required"})
 	}
 
 	buf, err := io.ReadAll(file)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_request"})
 	}
 	fileType, err := filetype.MatchReader(bytes.NewReader(buf))
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_file_type"})
 	}
 
 	if fileType.MIME.Value != "image/jpeg" && fileType.MIME.Value != "image/png" {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "file must be a jpeg or png"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: "file must be a jpeg or png", Code: "invalid_file_type"})
 	}
 
 	fmt.Println("file type:", fileType.MIME.Value)
 
 	if fileHeader.Size > 2000000 {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "file too large", Error: "file too large"})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "file too large", Error: "file too large", Code: "file_too_large"})
 	}
 
 	// delete existing avatar from S3
 	user, _, err := getUserIdentity(s, r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_token"})
+	}
+
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
+	}
+
+	// check the new avatar fits in the storage quota
+	storageLimit := s.getPlan(r.Context(), user.ID).Limits[models.UsageStorageBytes]
+	if storageLimit.Hard > 0 {
+		storageUsed, err := s.getUsedQuantity(r.Context(), user.ID, models.UsageStorageBytes, time.Now())
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		if storageUsed-user.AvatarSize+int64(len(buf)) > storageLimit.Hard {
+			return WriteJSON(w, http.StatusForbidden, Error{Message: "storage quota exceeded", Error: "storage quota exceeded for your plan.", Code: "quota_exceeded"})
+		}
 	}
+	previousAvatarSize := user.AvatarSize
 
 	avatarUrl := user.AvatarUrl
 	avatarThumbUrl := user.AvatarThumbnailUrl
 
 	if avatarUrl != "" {
-		err = util.DeleteFileFromS3(avatarUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 	}
 
 	if avatarThumbUrl != "" {
-		err = util.DeleteFileFromS3(avatarThumbUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarThumbUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 	}
 
 	// upload new avatar to s3
-	filename, thumbFilename, err := util.UploadFileToS3(buf, true)
+	filename, thumbFilename, err := util.UploadFileToS3(r.Context(), buf, true)
 	if err != nil {
 		return err
 	}
@@ -1426,31 +1739,37 @@ func (s *Server) handleUploadAvatar(w http.ResponseWriter, r *http.Request) erro
 	authToken, err := r.Cookie("auth-token")
 
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "token is invalid or expired", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "token is invalid or expired", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	userId, authTokenType, err := util.ParseJWT(authToken.Value)
 
 	if err != nil {
-		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "token is invalid or expired", Error: err.Error()})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "token is invalid or expired", Error: err.Error(), Code: "invalid_token"})
 	}
 
 	if authTokenType != "auth" {
 		return WriteJSON(w, http.StatusUnauthorized, Error{Message: "token is invalid.", Error: "incorrect token type", Code: "invalid_token"})
 	}
 
-	user, err = s.store.GetUserByID(uuid.MustParse(userId))
+	user, err = s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 	if err != nil {
-		return WriteJSON(w, http.StatusNotFound, Error{Message: "user not found", Error: err.Error()})
+		return WriteJSON(w, http.StatusNotFound, Error{Message: "user not found", Error: err.Error(), Code: "user_not_found"})
 	}
 
 	user.AvatarUrl = cloudfrontUrl
 	user.AvatarThumbnailUrl = thumbCloudfrontUrl
+	user.AvatarSize = int64(len(buf))
 
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.store.UpdateUser(r.Context(), user); err != nil {
 		return err
 	}
 
+	if err := s.recordUsage(r.Context(), user, models.UsageStorageBytes, user.AvatarSize-previousAvatarSize); err != nil {
+		fmt.Printf("Error recording storage usage: %v\n", err)
+	}
+
+	w.Header().Set("ETag", userETag(user))
 	return WriteJSON(w, http.StatusOK, map[string]any{"location": cloudfrontUrl, "file_type": fileType})
 }
 
@@ -1458,7 +1777,11 @@ func (s *Server) handleDeleteAvatar(w http.ResponseWriter, r *http.Request) erro
 	user, _, err := getUserIdentity(s, r)
 
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_token"})
+	}
+
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
 	}
 
 	avatarUrl := user.AvatarUrl
@@ -1470,40 +1793,50 @@ func (s *Server) handleDeleteAvatar(w http.ResponseWriter, r *http.Request) erro
 	}
 
 	if avatarThumbUrl == "" {
-		err = util.DeleteFileFromS3(avatarUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 	}
 
 	if avatarUrl == "" {
-		err = util.DeleteFileFromS3(avatarThumbUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarThumbUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 	}
 
 	if avatarUrl != "" && avatarThumbUrl != "" {
-		err = util.DeleteFileFromS3(avatarUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 
-		err = util.DeleteFileFromS3(avatarThumbUrl)
+		err = util.DeleteFileFromS3(r.Context(), avatarThumbUrl)
 
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+			return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "file_storage_error"})
 		}
 	}
 
+	avatarSize := user.AvatarSize
+
 	user.AvatarUrl = ""
 	user.AvatarThumbnailUrl = ""
+	user.AvatarSize = 0
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error()})
+	if err := s.store.UpdateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: err.Error(), Code: "invalid_request"})
+	}
+
+	if err := s.recordUsage(r.Context(), user, models.UsageStorageBytes, -avatarSize); err != nil {
+		fmt.Printf("Error recording storage usage: %v\n", err)
 	}
 
 	return WriteJSON(w, http.StatusNoContent, nil)
@@ -1516,6 +1849,10 @@ func (s *Server) handleChangeUserPassword(w http.ResponseWriter, r *http.Request
 		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired", Code: "bad_token"})
 	}
 
+	if !ifMatch(r, user) {
+		return WriteJSON(w, http.StatusPreconditionFailed, Error{Error: "user has changed since it was read.", Code: "precondition_failed"})
+	}
+
 	// validate input
 	changePasswordReq := new(models.ChangeUserPasswordRequest)
 	if err := json.NewDecoder(r.Body).Decode(changePasswordReq); err != nil {
@@ -1554,28 +1891,8 @@ func (s *Server) handleChangeUserPassword(w http.ResponseWriter, r *http.Request
 	// validate new password is strong
 	eightOrMore, number, upper, special := util.ValidatePassword(changePasswordReq.NewPassword)
 
-	var errorMessages []string
-	if !eightOrMore {
-		errorMessages = append(errorMessages, "be at least 8 characters long")
-	}
-	if !number {
-		errorMessages = append(errorMessages, "contain at least one number")
-	}
-	if !upper {
-		errorMessages = append(errorMessages, "contain at least one uppercase letter")
-	}
-	if !special {
-		errorMessages = append(errorMessages, "contain at least one special character")
-	}
-
-	if len(errorMessages) > 0 {
-		errorMessage := "Password must " + strings.Join(errorMessages, ", ")
-		if len(errorMessages) > 1 {
-			lastIndex := len(errorMessages) - 1
-			errorMessage = strings.Join(errorMessages[:lastIndex], ", ") + ", and " + errorMessages[lastIndex]
-			errorMessage = "Password must " + errorMessage
-		}
-		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMessage})
+	if errorMessage := passwordRequirementsMessage(middleware.GetLocale(w), eightOrMore, number, upper, special); errorMessage != "" {
+		return WriteJSON(w, http.StatusBadRequest, Error{Message: "invalid request", Error: errorMessage, Code: "weak_password", localized: true})
 	}
 
 	hashedPassword, err := hashAndSaltPassword(changePasswordReq.NewPassword)
@@ -1584,22 +1901,22 @@ func (s *Server) handleChangeUserPassword(w http.ResponseWriter, r *http.Request
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "server_error"})
 	}
 
+	// update security version
+	now := time.Now()
+
 	user.HashedPassword = hashedPassword
+	user.SecurityVersionChangedAt = &now
 
-	if err := s.store.UpdateUser(user); err != nil {
+	if err := s.changePassword(r.Context(), user, "settings"); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
 		fmt.Printf("error updating user with new password: %s\n", err)
 
 		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "server_error"})
 	}
 
-	// update security version
-	now := time.Now()
-
-	user.SecurityVersionChangedAt = &now
-
-	if err := s.store.UpdateUser(user); err != nil {
-		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
-	}
+	s.notify(r.Context(), user, models.NotificationPasswordChanged)
 
 	return WriteJSON(w, http.StatusOK, Response{Message: "password changed successfully.", Code: "password_changed"})
 }
@@ -1617,7 +1934,7 @@ func (s *Server) handleGetAllTokens(w http.ResponseWriter, r *http.Request) erro
 	//	return WriteJSON(w, http.StatusUnauthorized, ApiError{Message: "user is not authenticated", Error: err.Error()})
 	//}
 
-	//user, err := s.store.GetUserByID(uuid.MustParse(userId))
+	//user, err := s.store.GetUserByID(r.Context(), uuid.MustParse(userId))
 	//if err != nil {
 	//	return WriteJSON(w, http.StatusUnauthorized, ApiError{Message: "user is not authenticated", Error: err.Error()})
 	//}
@@ -1631,17 +1948,16 @@ func (s *Server) handleGetAllTokens(w http.ResponseWriter, r *http.Request) erro
 	return WriteJSON(w, http.StatusOK, nil)
 }
 
+// handleGetSubscriptions returns the user's own subscription as the store last synced it from
+// Stripe's webhooks.
 func (s *Server) handleGetSubscriptions(w http.ResponseWriter, r *http.Request) error {
-	stripe.Key = "sk_test_51QWzbYATosx6QLQbAPARQD3lnSU4NvBQffCHrtc4KGcOYPnVlsLGvB6tVEfyrWCHVVpPrdiztbpkHhCjHDDLnRYb00NfHC2mH9"
-
-	params := &stripe.SubscriptionParams{}
-	result, err := subscription.Get("sub_1MowQVLkdIwHu7ixeRlqHVzs", params)
-
+	user, _, err := getUserIdentity(s, r)
 	if err != nil {
-		return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
 	}
 
-	return WriteJSON(w, http.StatusOK, nil)
+	sub, _ := s.store.GetSubscriptionByUserID(r.Context(), user.ID)
+	return WriteJSON(w, http.StatusOK, models.NewSubscriptionState(sub))
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -1650,14 +1966,25 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 		return nil
 	}
 
+	locale := middleware.GetLocale(w)
+
 	// Handle error case with null message
 	if err, ok := v.(Error); ok {
+		if translated, ok := i18n.Error(locale, err.Code); ok && !err.localized {
+			err.Error = translated
+		}
+
 		if err.Message == "" {
 			v = Error{Error: err.Error, Code: err.Code}
 		} else {
 			v = Error{Message: err.Message, Error: err.Error, Code: err.Code}
 		}
 	} else if resp, ok := v.(Response); ok {
+		if translated, ok := i18n.Message(locale, resp.Code); ok {
+			resp.Message = translated
+			v = resp
+		}
+
 		// Only include data if it's not nil and not empty
 		if resp.Data == nil || isEmptyData(resp.Data) {
 			// Create new response without data field
@@ -1696,7 +2023,33 @@ func isEmptyData(data interface{}) bool {
 func makeHttpHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, Error{Message: fmt.Sprintf("cannot %s %s", r.Method, r.URL.Path), Error: err.Error()})
+			if errors.Is(err, storage.ErrConflict) {
+				WriteJSON(w, http.StatusConflict, Error{Error: "this was changed by another request. reload and try again.", Code: "conflict"})
+				return
+			}
+			WriteJSON(w, http.StatusBadRequest, Error{Message: fmt.Sprintf("cannot %s %s", r.Method, r.URL.Path), Error: err.Error(), Code: "invalid_request"})
+		}
+	}
+}
+
+// userETag identifies the stored version of a user. It changes on every write.
+func userETag(user *models.User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// ifMatch reports whether the request's If-Match header, if it sent one, names the user's current
+// version. Clients send back the ETag they read so an edit made from a stale copy is refused.
+func ifMatch(r *http.Request, user *models.User) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	etag := userETag(user)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
 		}
 	}
+	return false
 }
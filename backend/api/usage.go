@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/colecaccamise/go-backend/emails"
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v80"
+	"github.com/stripe/stripe-go/v80/billing/meterevent"
+)
+
+// MeterApiUsage counts requests made with an api key and rejects them once the hard limit is reached.
+func (s *Server) MeterApiUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, authType, err := getUserIdentity(s, r)
+		if err != nil || authType != "apiKey" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := s.getPlan(r.Context(), user.ID).Limits[models.UsageApiRequests]
+		periodStart, periodEnd := models.UsagePeriod(time.Now())
+
+		if limit.Hard > 0 {
+			used, err := s.store.GetUsageTotal(r.Context(), user.ID, models.UsageApiRequests, periodStart, periodEnd)
+			if err != nil {
+				_ = WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+				return
+			}
+
+			if used >= limit.Hard {
+				_ = WriteJSON(w, http.StatusTooManyRequests, Error{Error: "api request quota exceeded for this billing period.", Code: "quota_exceeded"})
+				return
+			}
+		}
+
+		if err := s.recordUsage(r.Context(), user, models.UsageApiRequests, 1); err != nil {
+			fmt.Printf("Error recording usage: %v\n", err)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleGetUsage(w http.ResponseWriter, r *http.Request) error {
+	user, _, err := getUserIdentity(s, r)
+	if err != nil {
+		return WriteJSON(w, http.StatusUnauthorized, Error{Error: "token is invalid or expired.", Code: "invalid_token"})
+	}
+
+	plan := s.getPlan(r.Context(), user.ID)
+	periodStart, periodEnd := models.UsagePeriod(time.Now())
+
+	usage := models.UsageResponse{
+		Plan:        plan.ID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+	}
+
+	for _, metric := range []string{models.UsageApiRequests, models.UsageStorageBytes} {
+		used, err := s.getUsedQuantity(r.Context(), user.ID, metric, time.Now())
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		buckets, err := s.store.GetUsageBuckets(r.Context(), user.ID, metric, periodStart, periodEnd)
+		if err != nil {
+			return WriteJSON(w, http.StatusInternalServerError, Error{Error: "internal server error.", Code: "internal_server_error"})
+		}
+
+		// roll hourly buckets up into days
+		var daily []models.UsageBucketResponse
+		for _, bucket := range buckets {
+			day := bucket.BucketStart.UTC().Truncate(24 * time.Hour)
+			if len(daily) > 0 && daily[len(daily)-1].Start.Equal(day) {
+				daily[len(daily)-1].Quantity += bucket.Quantity
+				continue
+			}
+			daily = append(daily, models.UsageBucketResponse{Start: day, Quantity: bucket.Quantity})
+		}
+
+		if daily == nil {
+			daily = []models.UsageBucketResponse{}
+		}
+
+		limit := plan.Limits[metric]
+		usage.Metrics = append(usage.Metrics, models.UsageMetricResponse{
+			Metric:    metric,
+			Used:      used,
+			SoftLimit: limit.Soft,
+			HardLimit: limit.Hard,
+			Buckets:   daily,
+		})
+	}
+
+	return WriteJSON(w, http.StatusOK, usage)
+}
+
+// getPlan returns the user's current plan, defaulting to free when they have no subscription.
+func (s *Server) getPlan(ctx context.Context, userID uuid.UUID) models.Plan {
+	sub, err := s.store.GetSubscriptionByUserID(ctx, userID)
+	if err != nil || sub == nil {
+		return models.GetPlan(models.PlanFree)
+	}
+	return models.GetPlan(sub.Plan)
+}
+
+// getUsedQuantity returns usage for the period containing at. Storage is a running total
+// across all time, everything else resets each period.
+func (s *Server) getUsedQuantity(ctx context.Context, userID uuid.UUID, metric string, at time.Time) (int64, error) {
+	if metric == models.UsageStorageBytes {
+		return s.store.GetUsageTotal(ctx, userID, metric, time.Time{}, at.Add(time.Hour))
+	}
+	periodStart, periodEnd := models.UsagePeriod(at)
+	return s.store.GetUsageTotal(ctx, userID, metric, periodStart, periodEnd)
+}
+
+// recordUsage stores a usage event and emails the user the first time a threshold is crossed.
+func (s *Server) recordUsage(ctx context.Context, user *models.User, metric string, quantity int64) error {
+	if quantity == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	if err := s.store.RecordUsage(ctx, user.ID, metric, quantity, now); err != nil {
+		return err
+	}
+
+	// only increases can cross a threshold
+	if quantity < 0 {
+		return nil
+	}
+
+	limit := s.getPlan(ctx, user.ID).Limits[metric]
+	if limit.Soft == 0 && limit.Hard == 0 {
+		return nil
+	}
+
+	used, err := s.getUsedQuantity(ctx, user.ID, metric, now)
+	if err != nil {
+		return err
+	}
+
+	level := ""
+	if limit.Hard > 0 && used >= limit.Hard {
+		level = models.UsageWarningHard
+	} else if limit.Soft > 0 && used >= limit.Soft {
+		level = models.UsageWarningSoft
+	}
+
+	if level == "" {
+		return nil
+	}
+
+	periodStart, _ := models.UsagePeriod(now)
+	created, err := s.store.CreateUsageWarning(ctx, &models.UsageWarning{
+		UserID:      user.ID,
+		Metric:      metric,
+		PeriodStart: periodStart,
+		Level:       level,
+	})
+	if err != nil || !created {
+		return err
+	}
+
+	return s.queueUserTemplate(ctx, user, models.NotificationUsage, emails.UsageWarning, emails.UsageWarningData{
+		Metric:  metric,
+		Used:    used,
+		Limit:   limit.Hard,
+		Reached: level == models.UsageWarningHard,
+		URL:     billingURL(),
+	})
+}
+
+// ReportUsage pushes usage that hasn't been reported yet to stripe as meter events. Requests are
+// reported per bucket as the amount added since the last report, and the requests meter sums
+// them. Storage is a running total that can go down, so each customer gets one event with their
+// current total, and the storage meter must use the "last" aggregation so it isn't summed.
+func (s *Server) ReportUsage(ctx context.Context) error {
+	buckets, err := s.store.GetUnreportedUsageBuckets(ctx, 500)
+	if err != nil {
+		return err
+	}
+
+	storageBuckets := map[uuid.UUID][]*models.UsageBucket{}
+	for _, bucket := range buckets {
+		if bucket.Metric == models.UsageStorageBytes {
+			storageBuckets[bucket.UserID] = append(storageBuckets[bucket.UserID], bucket)
+			continue
+		}
+
+		identifier := fmt.Sprintf("%s-%d-%d", bucket.ID, bucket.ReportedQuantity, bucket.Quantity)
+		if err := s.reportUsage(ctx, bucket.UserID, bucket.Metric, bucket.Quantity-bucket.ReportedQuantity, identifier, bucket); err != nil {
+			return err
+		}
+	}
+
+	for userID, dirty := range storageBuckets {
+		now := time.Now()
+		total, err := s.getUsedQuantity(ctx, userID, models.UsageStorageBytes, now)
+		if err != nil {
+			return err
+		}
+
+		// the total can return to an earlier value, so the time keeps the identifier unique
+		identifier := fmt.Sprintf("%s-%s-%d", userID, models.UsageStorageBytes, now.UnixNano())
+		if err := s.reportUsage(ctx, userID, models.UsageStorageBytes, total, identifier, dirty...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reportUsage sends one meter event for the user and marks the buckets it covers as reported. A
+// failed event is logged and left for the next run.
+func (s *Server) reportUsage(ctx context.Context, userID uuid.UUID, metric string, value int64, identifier string, buckets ...*models.UsageBucket) error {
+	sub, _ := s.store.GetSubscriptionByUserID(ctx, userID)
+	if sub == nil || sub.StripeCustomerID == "" {
+		// nothing to bill against yet
+		return nil
+	}
+
+	params := &stripe.BillingMeterEventParams{
+		EventName:  stripe.String(metric),
+		Identifier: stripe.String(identifier),
+		Payload: map[string]string{
+			"stripe_customer_id": sub.StripeCustomerID,
+			"value":              strconv.FormatInt(value, 10),
+		},
+		Timestamp: stripe.Int64(time.Now().Unix()),
+	}
+	params.Context = ctx
+
+	if _, err := meterevent.New(params); err != nil {
+		fmt.Printf("Error reporting %s usage for user %s: %v\n", metric, userID, err)
+		return nil
+	}
+
+	for _, bucket := range buckets {
+		if err := s.store.MarkUsageBucketReported(ctx, bucket); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
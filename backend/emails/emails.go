@@ -0,0 +1,218 @@
+package emails
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/i18n"
+)
+
+//go:embed templates
+var files embed.FS
+
+const (
+	ConfirmEmail         = "confirm_email"
+	ConfirmEmailReminder = "confirm_email_reminder"
+	ResendConfirmation   = "resend_confirmation"
+	ResetPassword        = "reset_password"
+	EmailChange          = "email_change"
+	EmailChangeNotice    = "email_change_notice"
+	AccountDeletion      = "account_deletion"
+	AccountPurged        = "account_purged"
+	TrialEnding          = "trial_ending"
+	TrialEnded           = "trial_ended"
+	PlanDowngraded       = "plan_downgraded"
+	PaymentFailed        = "payment_failed"
+	PaymentRetryFailed   = "payment_retry_failed"
+	PaymentFinalNotice   = "payment_final_notice"
+	UsageWarning         = "usage_warning"
+	AffiliateStatement   = "affiliate_statement"
+)
+
+type LinkData struct {
+	URL string
+}
+
+type ConfirmEmailReminderData struct {
+	URL           string
+	ExpiresInDays int
+}
+
+type EmailChangeData struct {
+	URL      string
+	NewEmail string
+}
+
+type EmailChangeNoticeData struct {
+	NewEmail string
+}
+
+type AccountDeletionData struct {
+	RecoveryDays int
+}
+
+// PlanData is for billing emails about the named plan, linking to the billing settings.
+type PlanData struct {
+	Plan string
+	URL  string
+}
+
+type TrialEndingData struct {
+	Plan   string
+	EndsAt time.Time
+	URL    string
+}
+
+type DunningData struct {
+	Plan        string
+	GraceEndsAt time.Time
+	URL         string
+}
+
+// UsageWarningData is for a usage limit that's close, or Reached. Metric is a models usage metric.
+type UsageWarningData struct {
+	Metric  string
+	Used    int64
+	Limit   int64
+	Reached bool
+	URL     string
+}
+
+// AffiliateStatementData is for the payout of the month starting at Period. Amount is formatted
+// with its currency.
+type AffiliateStatementData struct {
+	Period      time.Time
+	Amount      string
+	Commissions int64
+	URL         string
+}
+
+// samples hold the data each template is previewed with.
+var samples = map[string]any{
+	ConfirmEmail:         LinkData{URL: "https://example.com/auth/confirm?token=preview"},
+	ConfirmEmailReminder: ConfirmEmailReminderData{URL: "https://example.com/auth/confirm?token=preview", ExpiresInDays: 4},
+	ResendConfirmation:   LinkData{URL: "https://example.com/auth/confirm?token=preview"},
+	ResetPassword:        LinkData{URL: "https://example.com/auth/reset-password?token=preview"},
+	EmailChange:          EmailChangeData{URL: "https://example.com/auth/confirm?token=preview", NewEmail: "new@example.com"},
+	EmailChangeNotice:    EmailChangeNoticeData{NewEmail: "new@example.com"},
+	AccountDeletion:      AccountDeletionData{RecoveryDays: 90},
+	AccountPurged:        AccountDeletionData{RecoveryDays: 90},
+	TrialEnding:          TrialEndingData{Plan: "Pro", EndsAt: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), URL: "https://example.com/settings/billing"},
+	TrialEnded:           PlanData{Plan: "Pro", URL: "https://example.com/settings/billing"},
+	PlanDowngraded:       PlanData{Plan: "Pro", URL: "https://example.com/settings/billing"},
+	PaymentFailed:        DunningData{Plan: "Pro", GraceEndsAt: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), URL: "https://example.com/settings/billing"},
+	PaymentRetryFailed:   DunningData{Plan: "Pro", GraceEndsAt: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), URL: "https://example.com/settings/billing"},
+	PaymentFinalNotice:   DunningData{Plan: "Pro", GraceEndsAt: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), URL: "https://example.com/settings/billing"},
+	UsageWarning:         UsageWarningData{Metric: "api_requests", Used: 9000, Limit: 10000, URL: "https://example.com/settings/billing"},
+	AffiliateStatement:   AffiliateStatementData{Period: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Amount: "$120.00", Commissions: 6, URL: "https://example.com/settings/affiliates"},
+}
+
+// templates are keyed by locale, then template name.
+var templates = map[string]map[string]*template.Template{}
+
+func init() {
+	locales, err := files.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range locales {
+		if !entry.IsDir() {
+			continue
+		}
+
+		locale := entry.Name()
+		funcs := template.FuncMap{
+			"appName": appName,
+			"appURL":  func() string { return os.Getenv("APP_URL") },
+			"locale":  func() string { return locale },
+			"t":       func(key string, args ...any) string { return i18n.T(locale, key, args...) },
+			"date":    func(t time.Time) string { return i18n.Date(locale, t) },
+			"month":   func(t time.Time) string { return i18n.Month(locale, t) },
+			"button": func(label string, url string) map[string]string {
+				return map[string]string{"Label": label, "URL": url}
+			},
+		}
+
+		templates[locale] = map[string]*template.Template{}
+		for name := range samples {
+			templates[locale][name] = template.Must(template.New(name).Funcs(funcs).ParseFS(files,
+				"templates/layout.html",
+				"templates/button.html",
+				fmt.Sprintf("templates/%s/common.html", locale),
+				fmt.Sprintf("templates/%s/%s.html", locale, name),
+			))
+		}
+	}
+}
+
+// Email is a rendered template ready to send.
+type Email struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// Render renders the named template in the locale with its layout and a plain-text alternative.
+// Locales without translations fall back to the default locale.
+func Render(name string, locale string, data any) (*Email, error) {
+	localized, ok := templates[locale]
+	if !ok {
+		localized = templates[i18n.DefaultLocale]
+	}
+
+	tmpl, ok := localized[name]
+	if !ok {
+		return nil, fmt.Errorf("email template %s not found", name)
+	}
+
+	var subject, body, content bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "layout", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.ExecuteTemplate(&content, "content", data); err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		Subject: strings.TrimSpace(html.UnescapeString(subject.String())),
+		HTML:    body.String(),
+		Text:    ToText(content.String()),
+	}, nil
+}
+
+// Preview renders the named template in the locale with sample data.
+func Preview(name string, locale string) (*Email, error) {
+	data, ok := samples[name]
+	if !ok {
+		return nil, fmt.Errorf("email template %s not found", name)
+	}
+	return Render(name, locale, data)
+}
+
+// Names returns every registered template name in order.
+func Names() []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appName() string {
+	if name := os.Getenv("EMAIL_FROM_NAME"); name != "" {
+		return name
+	}
+	return "Dashboard"
+}
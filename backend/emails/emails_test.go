@@ -0,0 +1,135 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/colecaccamise/go-backend/i18n"
+)
+
+// TestRender renders every template in every locale with its sample data.
+func TestRender(t *testing.T) {
+	for _, locale := range i18n.Supported() {
+		for _, name := range Names() {
+			email, err := Render(name, locale, samples[name])
+			if err != nil {
+				t.Errorf("Render(%s, %s): %v", name, locale, err)
+				continue
+			}
+
+			if email.Subject == "" || strings.ContainsAny(email.Subject, "<>\n") {
+				t.Errorf("%s/%s: subject = %q", locale, name, email.Subject)
+			}
+			if !strings.Contains(email.HTML, `<html lang="`+locale+`">`) || !strings.Contains(email.HTML, "</html>") {
+				t.Errorf("%s/%s: HTML isn't wrapped in the layout", locale, name)
+			}
+			if email.Text == "" || strings.Contains(email.Text, "<") {
+				t.Errorf("%s/%s: text = %q", locale, name, email.Text)
+			}
+			for _, part := range []string{email.Subject, email.HTML, email.Text} {
+				if strings.Contains(part, "%!") || strings.Contains(part, "<no value>") {
+					t.Errorf("%s/%s: rendered with a formatting error: %q", locale, name, part)
+				}
+			}
+
+			if locale != i18n.DefaultLocale {
+				if english, _ := Render(name, i18n.DefaultLocale, samples[name]); english.Subject == email.Subject {
+					t.Errorf("%s/%s: subject %q isn't translated", locale, name, email.Subject)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderData(t *testing.T) {
+	tests := map[string]struct {
+		name   string
+		locale string
+		data   any
+		want   []string
+	}{
+		"link": {
+			ConfirmEmail, "en", LinkData{URL: "https://example.com/confirm?token=abc"},
+			[]string{"https://example.com/confirm?token=abc"},
+		},
+		"localized date": {
+			TrialEnding, "es", samples[TrialEnding],
+			[]string{"15 de marzo de 2024", "Pro"},
+		},
+		"localized month": {
+			AffiliateStatement, "fr", samples[AffiliateStatement],
+			[]string{"février 2024", "$120.00", "6 commissions"},
+		},
+		"approaching limit": {
+			UsageWarning, "en", UsageWarningData{Metric: "api_requests", Used: 900, Limit: 1000},
+			[]string{"approaching your usage limit", "900 of your 1000 API requests"},
+		},
+		"reached limit": {
+			UsageWarning, "fr", UsageWarningData{Metric: "storage_bytes", Used: 1000, Limit: 1000, Reached: true},
+			[]string{"atteint votre limite", "1000 octets de stockage sur 1000"},
+		},
+		"unsupported locale": {
+			AccountPurged, "de", samples[AccountPurged],
+			[]string{"permanently deleted", `<html lang="en">`},
+		},
+	}
+	for name, test := range tests {
+		email, err := Render(test.name, test.locale, test.data)
+		if err != nil {
+			t.Errorf("%s: Render: %v", name, err)
+			continue
+		}
+
+		rendered := email.Subject + "\n" + email.HTML + "\n" + email.Text
+		for _, want := range test.want {
+			if !strings.Contains(rendered, want) {
+				t.Errorf("%s: rendered email doesn't contain %q", name, want)
+			}
+		}
+	}
+
+	if _, err := Render("no_such_template", "en", nil); err == nil {
+		t.Error("Render of an unknown template succeeded")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	for _, name := range Names() {
+		email, err := Preview(name, "fr")
+		if err != nil {
+			t.Errorf("Preview(%s): %v", name, err)
+			continue
+		}
+		if rendered, _ := Render(name, "fr", samples[name]); rendered.HTML != email.HTML {
+			t.Errorf("Preview(%s) doesn't render the sample data", name)
+		}
+	}
+
+	if _, err := Preview("no_such_template", "en"); err == nil {
+		t.Error("Preview of an unknown template succeeded")
+	}
+	if len(Names()) != len(samples) {
+		t.Errorf("Names() returned %d templates, want %d", len(Names()), len(samples))
+	}
+}
+
+func TestToText(t *testing.T) {
+	tests := map[string]struct {
+		html string
+		want string
+	}{
+		"paragraphs":   {"<h1>Hello</h1><p>First</p><p>Second</p>", "Hello\n\nFirst\n\nSecond\n"},
+		"link":         {`<p><a href="https://example.com">Open</a></p>`, "Open: https://example.com\n"},
+		"bare link":    {`<a href="https://example.com">https://example.com</a>`, "https://example.com\n"},
+		"line break":   {"one<br>two<br />three", "one\n\ntwo\n\nthree\n"},
+		"entities":     {"<p>Tom &amp; Jerry&#39;s &lt;3</p>", "Tom & Jerry's <3\n"},
+		"whitespace":   {"<p>\n\t  lots   of\t space  \n</p>", "lots of space\n"},
+		"blank lines":  {"<p>a</p>\n\n\n\n<p>b</p>", "a\n\nb\n"},
+		"nested label": {`<a href="https://example.com"><b>Go</b></a>`, "Go: https://example.com\n"},
+	}
+	for name, test := range tests {
+		if got := ToText(test.html); got != test.want {
+			t.Errorf("%s: ToText(%q) = %q, want %q", name, test.html, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package emails
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	anchorPattern     = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a\s*>`)
+	blockEndPattern   = regexp.MustCompile(`(?i)</(p|h[1-6]|div|tr|li)\s*>|<br\s*/?>`)
+	tagPattern        = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacePattern      = regexp.MustCompile(`[ \t]+`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// ToText converts rendered email HTML into a readable plain-text alternative. Links keep
+// their URL so they stay usable in clients that don't render HTML.
+func ToText(body string) string {
+	body = anchorPattern.ReplaceAllStringFunc(body, func(anchor string) string {
+		match := anchorPattern.FindStringSubmatch(anchor)
+		href := match[1]
+		label := strings.TrimSpace(tagPattern.ReplaceAllString(match[2], ""))
+		if label == "" || label == href {
+			return href
+		}
+		return label + ": " + href
+	})
+	body = blockEndPattern.ReplaceAllString(body, "\n\n")
+	body = tagPattern.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spacePattern.ReplaceAllString(line, " "))
+	}
+	body = strings.Join(lines, "\n")
+
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(body, "\n\n")) + "\n"
+}
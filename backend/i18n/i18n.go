@@ -0,0 +1,155 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed locales/*.json
+var files embed.FS
+
+const DefaultLocale = "en"
+
+// Catalog holds a locale's translations. Errors and Messages are keyed by the API response
+// codes, Strings by free-form keys used to build dynamic messages.
+type Catalog struct {
+	Errors   map[string]string `json:"errors"`
+	Messages map[string]string `json:"messages"`
+	Strings  map[string]string `json:"strings"`
+}
+
+var catalogs = map[string]*Catalog{}
+
+func init() {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range entries {
+		data, err := files.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		catalog := new(Catalog)
+		if err := json.Unmarshal(data, catalog); err != nil {
+			panic(fmt.Sprintf("invalid locale file %s: %v", entry.Name(), err))
+		}
+
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+}
+
+// Supported returns every locale with a catalog.
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Error returns the translated error for an API error code.
+func Error(locale string, code string) (string, bool) {
+	return lookup(locale, func(c *Catalog) map[string]string { return c.Errors }, code)
+}
+
+// Message returns the translated message for an API response code.
+func Message(locale string, code string) (string, bool) {
+	return lookup(locale, func(c *Catalog) map[string]string { return c.Messages }, code)
+}
+
+// T returns the translated string for key formatted with args, falling back to the
+// default locale and then to the key itself.
+func T(locale string, key string, args ...any) string {
+	text, ok := lookup(locale, func(c *Catalog) map[string]string { return c.Strings }, key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Date formats t as a full date in the locale, like "March 5, 2024".
+func Date(locale string, t time.Time) string {
+	return T(locale, "date.day", t.Day(), monthName(locale, t.Month()), t.Year())
+}
+
+// Month formats t as a month and year in the locale, like "March 2024".
+func Month(locale string, t time.Time) string {
+	return T(locale, "date.month", t.Day(), monthName(locale, t.Month()), t.Year())
+}
+
+func monthName(locale string, month time.Month) string {
+	names := strings.Split(T(locale, "date.months"), ",")
+	if len(names) != 12 {
+		return month.String()
+	}
+	return names[month-1]
+}
+
+func lookup(locale string, section func(*Catalog) map[string]string, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	for _, l := range []string{locale, DefaultLocale} {
+		if catalog, ok := catalogs[l]; ok {
+			if text, ok := section(catalog)[key]; ok {
+				return text, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Match picks the best supported locale from an Accept-Language header.
+func Match(acceptLanguage string) string {
+	best, bestQuality := DefaultLocale, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		locale := Normalize(tag)
+		if IsSupported(locale) && quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+
+	return best
+}
+
+// Normalize reduces a language tag like "fr-CA" to its base language.
+func Normalize(tag string) string {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	base, _, _ = strings.Cut(base, "_")
+	return base
+}
@@ -0,0 +1,181 @@
+package i18n_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/colecaccamise/go-backend/api"
+	"github.com/colecaccamise/go-backend/i18n"
+	"github.com/colecaccamise/go-backend/middleware"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]struct {
+		tag  string
+		want string
+	}{
+		"base":       {"fr", "fr"},
+		"region":     {"fr-CA", "fr"},
+		"underscore": {"es_MX", "es"},
+		"case":       {"EN-gb", "en"},
+		"spaces":     {" de ", "de"},
+		"empty":      {"", ""},
+	}
+	for name, test := range tests {
+		if got := i18n.Normalize(test.tag); got != test.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", name, test.tag, got, test.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		want   string
+	}{
+		"empty":               {"", "en"},
+		"supported":           {"fr", "fr"},
+		"region":              {"es-MX", "es"},
+		"highest quality":     {"en;q=0.2, es;q=0.9", "es"},
+		"first of equals":     {"fr-CA,fr;q=0.9,en;q=0.8", "fr"},
+		"unsupported skipped": {"de-DE,es;q=0.5", "es"},
+		"nothing supported":   {"de, ja", "en"},
+		"wildcard":            {"*", "en"},
+		"invalid quality":     {"fr;q=abc, es", "es"},
+	}
+	for name, test := range tests {
+		if got := i18n.Match(test.header); got != test.want {
+			t.Errorf("%s: Match(%q) = %q, want %q", name, test.header, got, test.want)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	if got, ok := i18n.Error("de", "invalid_token"); !ok || got != "Token is invalid or expired." {
+		t.Errorf("Error in an unsupported locale = %q, %v, want the default locale's", got, ok)
+	}
+	if _, ok := i18n.Error("fr", "no_such_code"); ok {
+		t.Error("Error found a translation for an unknown code")
+	}
+	if got := i18n.T("fr", "no.such.key"); got != "no.such.key" {
+		t.Errorf("T for an unknown key = %q, want the key", got)
+	}
+}
+
+func TestDate(t *testing.T) {
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		date  string
+		month string
+	}{
+		"en": {"March 5, 2024", "March 2024"},
+		"es": {"5 de marzo de 2024", "marzo de 2024"},
+		"fr": {"5 mars 2024", "mars 2024"},
+		"de": {"March 5, 2024", "March 2024"},
+	}
+	for locale, test := range tests {
+		if got := i18n.Date(locale, day); got != test.date {
+			t.Errorf("Date(%q) = %q, want %q", locale, got, test.date)
+		}
+		if got := i18n.Month(locale, day); got != test.month {
+			t.Errorf("Month(%q) = %q, want %q", locale, got, test.month)
+		}
+	}
+}
+
+// TestCatalogParity checks every locale translates exactly the keys of the default locale.
+func TestCatalogParity(t *testing.T) {
+	load := func(locale string) *i18n.Catalog {
+		data, err := os.ReadFile(path.Join("locales", locale+".json"))
+		if err != nil {
+			t.Fatalf("reading %s: %v", locale, err)
+		}
+		catalog := new(i18n.Catalog)
+		if err := json.Unmarshal(data, catalog); err != nil {
+			t.Fatalf("parsing %s: %v", locale, err)
+		}
+		return catalog
+	}
+
+	sections := map[string]func(*i18n.Catalog) map[string]string{
+		"errors":   func(c *i18n.Catalog) map[string]string { return c.Errors },
+		"messages": func(c *i18n.Catalog) map[string]string { return c.Messages },
+		"strings":  func(c *i18n.Catalog) map[string]string { return c.Strings },
+	}
+
+	base := load(i18n.DefaultLocale)
+	for _, locale := range i18n.Supported() {
+		catalog := load(locale)
+		for name, section := range sections {
+			for key := range section(base) {
+				if _, ok := section(catalog)[key]; !ok {
+					t.Errorf("%s is missing %s.%s", locale, name, key)
+				}
+			}
+			for key := range section(catalog) {
+				if _, ok := section(base)[key]; !ok {
+					t.Errorf("%s has %s.%s, which %s doesn't", locale, name, key, i18n.DefaultLocale)
+				}
+			}
+		}
+	}
+
+	if !slices.Equal(i18n.Supported(), []string{"en", "es", "fr"}) {
+		t.Errorf("Supported() = %v", i18n.Supported())
+	}
+}
+
+// TestWriteJSON checks API responses are translated by their code into the request's locale.
+func TestWriteJSON(t *testing.T) {
+	tests := map[string]struct {
+		language string
+		body     any
+		want     map[string]string
+	}{
+		"error": {
+			"fr",
+			api.Error{Error: "token is invalid or expired.", Code: "invalid_token"},
+			map[string]string{"error": "Le jeton est invalide ou a expiré.", "code": "invalid_token"},
+		},
+		"message": {
+			"fr-CA",
+			api.Response{Message: "checkout started.", Code: "checkout_started"},
+			map[string]string{"message": "Paiement lancé.", "code": "checkout_started"},
+		},
+		"default locale": {
+			"",
+			api.Error{Error: "token is invalid or expired.", Code: "invalid_token"},
+			map[string]string{"error": "Token is invalid or expired.", "code": "invalid_token"},
+		},
+		"unknown code": {
+			"es",
+			api.Error{Error: "something odd.", Code: "no_such_code"},
+			map[string]string{"error": "something odd.", "code": "no_such_code"},
+		},
+	}
+	for name, test := range tests {
+		handler := middleware.Locale(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			api.WriteJSON(w, http.StatusBadRequest, test.body)
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", test.language)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		var got map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: decoding %q: %v", name, w.Body.String(), err)
+		}
+		if got["error"] != test.want["error"] || got["message"] != test.want["message"] || got["code"] != test.want["code"] {
+			t.Errorf("%s: WriteJSON wrote %v, want %v", name, got, test.want)
+		}
+	}
+}
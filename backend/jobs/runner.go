@@ -0,0 +1,359 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+)
+
+// leaderLockKey is the advisory lock held by the replica that enqueues scheduled jobs.
+const leaderLockKey = 4_210_391
+
+// Handler runs a job. Returning an error retries the job with backoff until it runs out of
+// attempts. The context is cancelled when the job times out or the runner stops.
+type Handler func(ctx context.Context, job *models.Job) error
+
+type definition struct {
+	handler     Handler
+	maxAttempts int
+	timeout     time.Duration
+	spec        string
+	schedule    Schedule
+}
+
+// Option configures a registered job.
+type Option func(*definition)
+
+// MaxAttempts sets how often a job runs before it's dead-lettered. The default is 5.
+func MaxAttempts(n int) Option {
+	return func(d *definition) { d.maxAttempts = n }
+}
+
+// Timeout sets how long a single run may take. The default is 10 minutes.
+func Timeout(timeout time.Duration) Option {
+	return func(d *definition) { d.timeout = timeout }
+}
+
+// Every runs the job on a cron schedule, see ParseSchedule.
+func Every(spec string) Option {
+	return func(d *definition) { d.spec = spec }
+}
+
+// Runner executes queued jobs on every replica and, on the one replica holding the leader lock,
+// enqueues scheduled runs.
+type Runner struct {
+	store       storage.JobRepository
+	id          string
+	workers     int
+	poll        time.Duration
+	retention   time.Duration
+	definitions map[string]*definition
+	leader      atomic.Bool
+}
+
+// NewRunner creates a runner with JOB_WORKERS workers (default 4) polling every
+// JOB_POLL_SECONDS (default 1), keeping succeeded jobs for JOB_RETENTION_DAYS (default 7).
+func NewRunner(store storage.JobRepository) *Runner {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return &Runner{
+		store:       store,
+		id:          fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix)),
+		workers:     util.GetEnvInt("JOB_WORKERS", 4),
+		poll:        time.Duration(util.GetEnvInt("JOB_POLL_SECONDS", 1)) * time.Second,
+		retention:   time.Duration(util.GetEnvInt("JOB_RETENTION_DAYS", 7)) * 24 * time.Hour,
+		definitions: map[string]*definition{},
+	}
+}
+
+// Register adds a job handler under name. It must be called before Run.
+func (r *Runner) Register(name string, handler Handler, opts ...Option) error {
+	if _, ok := r.definitions[name]; ok {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+
+	d := &definition{handler: handler, maxAttempts: 5, timeout: 10 * time.Minute}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.spec != "" {
+		schedule, err := ParseSchedule(d.spec)
+		if err != nil {
+			return err
+		}
+		d.schedule = schedule
+	}
+
+	r.definitions[name] = d
+	return nil
+}
+
+// Enqueue queues a one-off run of the named job with payload encoded as JSON.
+func (r *Runner) Enqueue(ctx context.Context, name string, payload any) error {
+	return r.EnqueueAt(ctx, name, payload, time.Now())
+}
+
+func (r *Runner) EnqueueAt(ctx context.Context, name string, payload any, runAt time.Time) error {
+	d, ok := r.definitions[name]
+	if !ok {
+		return fmt.Errorf("job %s is not registered", name)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return r.store.EnqueueJob(ctx, &models.Job{
+		Name:        name,
+		Payload:     string(data),
+		RunAt:       runAt,
+		MaxAttempts: d.maxAttempts,
+	})
+}
+
+func (r *Runner) ID() string {
+	return r.id
+}
+
+// IsLeader reports whether this replica currently schedules recurring jobs.
+func (r *Runner) IsLeader() bool {
+	return r.leader.Load()
+}
+
+// Run starts the workers and leader election and blocks until ctx is cancelled and running
+// jobs have returned.
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r.work(ctx, fmt.Sprintf("%s/%d", r.id, worker))
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.lead(ctx)
+	}()
+
+	wg.Wait()
+}
+
+func (r *Runner) work(ctx context.Context, workerID string) {
+	ticker := time.NewTicker(r.poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// drain the queue before waiting for the next tick
+		for ctx.Err() == nil {
+			job, err := r.store.ClaimJob(ctx, workerID, time.Now().Add(-r.staleAfter()))
+			if err != nil {
+				fmt.Printf("Error claiming job: %v\n", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+
+			r.execute(ctx, job)
+		}
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, job *models.Job) {
+	// record the outcome even if the runner is stopping while the job finishes
+	record := context.WithoutCancel(ctx)
+
+	d, ok := r.definitions[job.Name]
+	if !ok {
+		job.LastError = fmt.Sprintf("job %s is not registered", job.Name)
+		if err := r.store.FailJob(record, job, nil); err != nil {
+			reportSettleError("failing", job, err)
+		}
+		return
+	}
+
+	err := r.call(ctx, d, job)
+	if err == nil {
+		if err := r.store.CompleteJob(record, job); err != nil {
+			reportSettleError("completing", job, err)
+		}
+		return
+	}
+
+	fmt.Printf("Error running job %s %s (attempt %d): %v\n", job.Name, job.ID, job.Attempts, err)
+
+	job.LastError = err.Error()
+	if err := r.store.FailJob(record, job, retryAt(job.Attempts, job.MaxAttempts)); err != nil {
+		reportSettleError("failing", job, err)
+	}
+}
+
+// reportSettleError logs a job outcome that couldn't be recorded. A lost lease means the job ran
+// past staleAfter and another worker has claimed it again, so this attempt's outcome is dropped.
+func reportSettleError(action string, job *models.Job, err error) {
+	if errors.Is(err, storage.ErrJobLeaseLost) {
+		fmt.Printf("Lost the lease on job %s %s (attempt %d), another worker has claimed it\n", job.Name, job.ID, job.Attempts)
+		return
+	}
+	fmt.Printf("Error %s job %s: %v\n", action, job.ID, err)
+}
+
+// call runs the handler with the job's timeout, turning panics into errors so one bad job
+// can't take down the worker.
+func (r *Runner) call(ctx context.Context, d *definition, job *models.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+
+	return d.handler(ctx, job)
+}
+
+// staleAfter is how long a running job can go without finishing before another worker takes
+// it over, a minute past the longest registered timeout.
+func (r *Runner) staleAfter() time.Duration {
+	longest := 10 * time.Minute
+	for _, d := range r.definitions {
+		longest = max(longest, d.timeout)
+	}
+	return longest + time.Minute
+}
+
+// retryAt backs off exponentially from 30 seconds, capped at an hour, and returns nil once the
+// job has used all its attempts.
+func retryAt(attempts int, maxAttempts int) *time.Time {
+	if attempts >= maxAttempts {
+		return nil
+	}
+
+	delay := time.Duration(math.Pow(2, float64(attempts-1))) * 30 * time.Second
+	delay = min(delay, time.Hour)
+
+	next := time.Now().Add(delay)
+	return &next
+}
+
+// lead competes for the leader lock and, while holding it, enqueues due scheduled jobs and
+// prunes old succeeded jobs.
+func (r *Runner) lead(ctx context.Context) {
+	var lock storage.Lock
+	defer func() {
+		if lock != nil {
+			_ = lock.Release()
+			r.leader.Store(false)
+		}
+	}()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if lock != nil {
+			if err := lock.Alive(ctx); err != nil {
+				fmt.Printf("Lost job leader lock: %v\n", err)
+				_ = lock.Release()
+				lock = nil
+				r.leader.Store(false)
+			}
+		}
+
+		if lock == nil {
+			acquired, err := r.store.TryAdvisoryLock(ctx, leaderLockKey)
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("Error acquiring job leader lock: %v\n", err)
+			}
+
+			if acquired != nil {
+				lock = acquired
+				r.leader.Store(true)
+				fmt.Printf("Job runner %s is now the leader\n", r.id)
+
+				if err := r.syncSchedules(ctx); err != nil {
+					fmt.Printf("Error syncing job schedules: %v\n", err)
+				}
+			}
+		}
+
+		if lock != nil {
+			if err := r.enqueueDue(ctx); err != nil {
+				fmt.Printf("Error enqueueing scheduled jobs: %v\n", err)
+			}
+
+			if _, err := r.store.DeleteFinishedJobs(ctx, time.Now().Add(-r.retention)); err != nil {
+				fmt.Printf("Error deleting finished jobs: %v\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) syncSchedules(ctx context.Context) error {
+	now := time.Now().UTC()
+	for name, d := range r.definitions {
+		if d.schedule == nil {
+			continue
+		}
+		if err := r.store.SyncJobSchedule(ctx, name, d.spec, d.schedule.Next(now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) enqueueDue(ctx context.Context) error {
+	schedules, err := r.store.GetJobSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, schedule := range schedules {
+		d, ok := r.definitions[schedule.Name]
+		if !ok || d.schedule == nil || schedule.NextRunAt.After(now) {
+			continue
+		}
+
+		// runs missed while no replica was leading are collapsed into one
+		if err := r.store.EnqueueScheduledJob(ctx, schedule, d.schedule.Next(now), d.maxAttempts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAt(t *testing.T) {
+	tests := map[string]struct {
+		attempts    int
+		maxAttempts int
+		want        time.Duration
+		dead        bool
+	}{
+		"first attempt":    {1, 5, 30 * time.Second, false},
+		"second attempt":   {2, 5, time.Minute, false},
+		"fourth attempt":   {4, 10, 4 * time.Minute, false},
+		"capped":           {9, 20, time.Hour, false},
+		"last attempt":     {3, 3, 0, true},
+		"past the maximum": {5, 3, 0, true},
+	}
+	for name, test := range tests {
+		before := time.Now()
+		got := retryAt(test.attempts, test.maxAttempts)
+		after := time.Now()
+
+		if test.dead {
+			if got != nil {
+				t.Errorf("%s: retryAt(%d, %d) = %v, want nil", name, test.attempts, test.maxAttempts, got)
+			}
+			continue
+		}
+		if got == nil || got.Before(before.Add(test.want)) || got.After(after.Add(test.want)) {
+			t.Errorf("%s: retryAt(%d, %d) = %v, want %v from now", name, test.attempts, test.maxAttempts, got, test.want)
+		}
+	}
+}
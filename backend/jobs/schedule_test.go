@@ -0,0 +1,62 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// a friday
+	after := time.Date(2024, 3, 15, 10, 30, 45, 0, time.UTC)
+
+	tests := map[string]struct {
+		spec string
+		want time.Time
+	}{
+		"hourly":            {"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		"daily":             {"@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		"weekly":            {"@weekly", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		"monthly":           {"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		"yearly":            {"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"every":             {"@every 90s", time.Date(2024, 3, 15, 10, 32, 15, 0, time.UTC)},
+		"step":              {"*/15 * * * *", time.Date(2024, 3, 15, 10, 45, 0, 0, time.UTC)},
+		"ranges":            {"0 9-17 * * 1-5", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		"sunday as 7":       {"30 2 * * 7", time.Date(2024, 3, 17, 2, 30, 0, 0, time.UTC)},
+		"list":              {"0 0 1,15 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		"either day field":  {"0 0 13 * 5", time.Date(2024, 3, 22, 0, 0, 0, 0, time.UTC)},
+		"leap day":          {"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		"surrounding space": {" 5 4 * * * ", time.Date(2024, 3, 16, 4, 5, 0, 0, time.UTC)},
+	}
+	for name, test := range tests {
+		schedule, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%s: ParseSchedule(%q) = %v", name, test.spec, err)
+			continue
+		}
+		if got := schedule.Next(after); !got.Equal(test.want) {
+			t.Errorf("%s: Next(%v) = %v, want %v", name, after, got, test.want)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":             "",
+		"too few fields":    "* * * *",
+		"minute too large":  "60 * * * *",
+		"hour too large":    "* 24 * * *",
+		"day of month zero": "* * 0 * *",
+		"month too large":   "* * * 13 *",
+		"weekday too large": "* * * * 8",
+		"zero step":         "*/0 * * * *",
+		"backwards range":   "5-1 * * * *",
+		"not a number":      "a * * * *",
+		"interval too fast": "@every 500ms",
+		"bad interval":      "@every soon",
+	}
+	for name, spec := range tests {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%s: ParseSchedule(%q) succeeded, want an error", name, spec)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is a single email. Text is optional and sent as the plain-text alternative. Category
+// is the notification category the recipient can opt out of, if any. IdempotencyKey identifies
+// the email across retries so a provider that supports it sends it once.
+type Message struct {
+	To             string
+	Subject        string
+	HTML           string
+	Text           string
+	Headers        map[string]string
+	Category       string
+	IdempotencyKey string
+}
+
+type Mailer interface {
+	Send(context.Context, *Message) error
+}
+
+// sendTimeout bounds a single delivery to the provider.
+const sendTimeout = 30 * time.Second
+
+// New returns the mailer selected by MAIL_DRIVER: resend (default), smtp, file or memory.
+func New() (Mailer, error) {
+	from := fromAddress()
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "resend":
+		// bounces and complaints come in through the webhook, which rejects everything unsigned
+		if os.Getenv("ENVIRONMENT") == "production" && os.Getenv("EMAIL_WEBHOOK_SECRET") == "" {
+			return nil, errors.New("EMAIL_WEBHOOK_SECRET must be set in production")
+		}
+		return NewResendMailer(os.Getenv("RESEND_API_KEY"), from), nil
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "1025"
+		}
+		return NewSMTPMailer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	case "file":
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return NewFileMailer(dir, from)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %s", driver)
+	}
+}
+
+func fromAddress() string {
+	return fmt.Sprintf("%s <%s>", os.Getenv("EMAIL_FROM_NAME"), os.Getenv("EMAIL_FROM_ADDRESS"))
+}
+
+// buildMIME renders the message as an RFC 5322 email with HTML and plain-text alternatives.
+func buildMIME(from string, msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	// a stable Message-ID lets the receiving side drop a retried copy
+	messageID := randomID()
+	if msg.IdempotencyKey != "" {
+		messageID = msg.IdempotencyKey
+	}
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   fmt.Sprintf("<%s@%s>", messageID, messageDomain(from)),
+		"MIME-Version": "1.0",
+		"Content-Type": fmt.Sprintf("multipart/alternative; boundary=%s", body.Boundary()),
+	}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&out, "%s: %s\r\n", key, headers[key])
+	}
+	out.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+func messageDomain(address string) string {
+	address = strings.TrimSuffix(address, ">")
+	if i := strings.LastIndex(address, "@"); i >= 0 && i < len(address)-1 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrOptedOut = errors.New("recipient opted out of this category")
+
+// PreferenceList reports whether an address turned off a category of email.
+type PreferenceList interface {
+	IsEmailOptedOut(ctx context.Context, email string, category string) (bool, error)
+}
+
+type preferenceMailer struct {
+	next Mailer
+	list PreferenceList
+}
+
+// WithPreferences wraps a mailer so it refuses to send a categorized message to an address that
+// opted out of the category. Uncategorized messages are always sent.
+func WithPreferences(next Mailer, list PreferenceList) Mailer {
+	return &preferenceMailer{next: next, list: list}
+}
+
+func (m *preferenceMailer) Send(ctx context.Context, msg *Message) error {
+	if msg.Category == "" {
+		return m.next.Send(ctx, msg)
+	}
+
+	optedOut, err := m.list.IsEmailOptedOut(ctx, msg.To, msg.Category)
+	if err != nil {
+		return err
+	}
+
+	if optedOut {
+		return fmt.Errorf("%w: %s (%s)", ErrOptedOut, msg.To, msg.Category)
+	}
+
+	return m.next.Send(ctx, msg)
+}
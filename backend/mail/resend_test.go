@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	now := time.Now()
+	payload := []byte(`{"type":"email.bounced"}`)
+	secret := "whsec_" + base64.StdEncoding.EncodeToString([]byte("signing secret"))
+
+	sign := func(key []byte, at time.Time) http.Header {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("msg_1." + timestamp + "."))
+		mac.Write(payload)
+
+		header := http.Header{}
+		header.Set("svix-id", "msg_1")
+		header.Set("svix-timestamp", timestamp)
+		header.Set("svix-signature", "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		return header
+	}
+
+	tests := map[string]struct {
+		secret string
+		header http.Header
+		valid  bool
+	}{
+		"signed":         {secret, sign([]byte("signing secret"), now), true},
+		"wrong key":      {secret, sign([]byte("another secret"), now), false},
+		"replayed":       {secret, sign([]byte("signing secret"), now.Add(-10*time.Minute)), false},
+		"unsigned":       {secret, http.Header{}, false},
+		"no secret":      {"", sign(nil, now), false},
+		"empty secret":   {"whsec_", sign(nil, now), false},
+		"invalid secret": {"whsec_not base64", sign(nil, now), false},
+	}
+	for name, test := range tests {
+		err := VerifyWebhook(test.secret, test.header, payload, now)
+		if test.valid && err != nil {
+			t.Errorf("%s: VerifyWebhook = %v, want it accepted", name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: VerifyWebhook = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
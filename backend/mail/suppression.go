@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+// SuppressionList reports whether an address has bounced or complained.
+type SuppressionList interface {
+	IsEmailSuppressed(context.Context, string) (bool, error)
+}
+
+type suppressingMailer struct {
+	next Mailer
+	list SuppressionList
+}
+
+// WithSuppression wraps a mailer so it refuses to send to suppressed addresses.
+func WithSuppression(next Mailer, list SuppressionList) Mailer {
+	return &suppressingMailer{next: next, list: list}
+}
+
+func (m *suppressingMailer) Send(ctx context.Context, msg *Message) error {
+	suppressed, err := m.list.IsEmailSuppressed(ctx, msg.To)
+	if err != nil {
+		return err
+	}
+
+	if suppressed {
+		return fmt.Errorf("%w: %s", ErrSuppressed, msg.To)
+	}
+
+	return m.next.Send(ctx, msg)
+}
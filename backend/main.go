@@ -1,19 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/colecaccamise/go-backend/api"
+	"github.com/colecaccamise/go-backend/jobs"
+	"github.com/colecaccamise/go-backend/mail"
 	"github.com/colecaccamise/go-backend/storage"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	fmt.Println("Starting server...")
-
 	listenAddr := flag.String("listenAddr", ":8000", "The server address to listen on.")
 	flag.Parse()
 
@@ -28,14 +29,33 @@ func main() {
 		log.Fatalf("Error creating postgres store: %s", err.Error())
 	}
 
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(store, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error migrating: %s", err.Error())
+		}
+		return
+	}
+
+	fmt.Println("Starting server...")
+
 	err = store.Init()
 	if err != nil {
 		log.Fatalf("Error initializing postgres store: %s", err.Error())
 	}
 
-	server := api.NewServer(*listenAddr, store)
+	mailer, err := mail.New()
+	if err != nil {
+		log.Fatalf("Error creating mailer: %s", err.Error())
+	}
+
+	server := api.NewServer(*listenAddr, store, mail.WithPreferences(mail.WithSuppression(mailer, store), store))
+
+	runner := jobs.NewRunner(store)
+	if err := server.RegisterJobs(runner); err != nil {
+		log.Fatalf("Error registering jobs: %s", err.Error())
+	}
 
-	// todo initiate cron tasks
+	go runner.Run(context.Background())
 
 	log.Fatal(server.Start())
 }
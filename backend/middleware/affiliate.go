@@ -3,25 +3,53 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
 )
 
-func Affiliate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// read in "via" query param
-		via := r.URL.Query().Get("via")
+// Affiliate records a click for ?via= links from active affiliates and remembers the
+// affiliate in a cookie so the signup can be attributed to them.
+func Affiliate(store storage.Storage) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// read in "via" query param
+			if via := r.URL.Query().Get("via"); via != "" {
+				TrackAffiliate(w, r, store, via, r.URL.Path)
+			}
 
-		fmt.Println("visitor refered by", via)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		// check db if affiliate exists
+// TrackAffiliate records a click on an active affiliate's link to landingPath and sets the
+// affiliate cookie. It reports whether code belonged to one.
+func TrackAffiliate(w http.ResponseWriter, r *http.Request, store storage.Storage, code string, landingPath string) bool {
+	// check db if affiliate exists
+	affiliate, err := store.GetAffiliateByCode(r.Context(), code)
+	if err != nil || affiliate.Status != models.AffiliateActive {
+		return false
+	}
 
-		// set affiliate cookie
-		http.SetCookie(w, &http.Cookie{
-			Name: "affiliate",
-			Value: via,
-			MaxAge: 60 * 60 * 24 * 30, // 30 days,
-			HttpOnly: true,
-		})
+	err = store.CreateAffiliateClick(r.Context(), &models.AffiliateClick{
+		AffiliateID: affiliate.ID,
+		LandingPath: landingPath,
+		Referrer:    r.Referer(),
+	})
+	if err != nil {
+		fmt.Printf("Error recording affiliate click: %v\n", err)
+	}
 
-		next.ServeHTTP(w, r) 
+	// set affiliate cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "affiliate",
+		Value:    affiliate.Code,
+		Path:     "/",
+		MaxAge:   60 * 60 * 24 * 30, // 30 days,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	})
-}
\ No newline at end of file
+	return true
+}
@@ -1,12 +1,72 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
 	"github.com/colecaccamise/go-backend/util"
+	"github.com/google/uuid"
 )
 
+// Identity is who made the request. It's resolved once by Authenticate and shared by every
+// middleware and handler after it.
+type Identity struct {
+	UserID uuid.UUID
+	// AuthType is "authToken" or "apiKey".
+	AuthType string
+	// User is loaded by whatever needs it first, and the rest of the request reuses it.
+	User *models.User
+}
+
+type identityKey struct{}
+
+// GetIdentity returns the request's identity, or nil if it isn't authenticated.
+func GetIdentity(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// Authenticate resolves the request's identity from the auth-token cookie or, failing that, the
+// X-API-KEY header, which must belong to a stored api token. Requests without valid credentials
+// carry on without an identity, so VerifyAuth decides which routes need one.
+func Authenticate(tokens storage.TokenRepository) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity, err := resolveIdentity(r, tokens); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func resolveIdentity(r *http.Request, tokens storage.TokenRepository) (*Identity, error) {
+	if authToken, err := r.Cookie("auth-token"); err == nil {
+		userId, authTokenType, err := util.ParseJWT(authToken.Value)
+		if err == nil && authTokenType == "auth" {
+			if id, err := uuid.Parse(userId); err == nil {
+				return &Identity{UserID: id, AuthType: "authToken"}, nil
+			}
+		}
+	}
+
+	if apiKey := r.Header.Get("X-API-KEY"); apiKey != "" {
+		token, err := tokens.GetApiTokenByHashedToken(r.Context(), util.HashApiKey(apiKey))
+		if err != nil {
+			return nil, errors.New("invalid api key")
+		}
+		return &Identity{UserID: token.UserID, AuthType: "apiKey"}, nil
+	}
+
+	return nil, errors.New("no valid authentication method")
+}
+
+// VerifyAuth rejects requests Authenticate couldn't identify.
 func VerifyAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authToken, _ := r.Cookie("auth-token")
@@ -23,8 +83,7 @@ func VerifyAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		authenticated, _, err := util.IsAuthenticated(authToken, apiKey)
-		if err != nil || !authenticated {
+		if GetIdentity(r.Context()) == nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{
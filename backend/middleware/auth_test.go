@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/storage"
+	"github.com/colecaccamise/go-backend/util"
+	"github.com/google/uuid"
+)
+
+func TestVerifyAuthApiKeys(t *testing.T) {
+	store := storage.NewMemoryStore()
+	userID := uuid.New()
+	if err := store.CreateApiToken(context.Background(), &models.ApiToken{HashedToken: util.HashApiKey("valid-key"), UserID: userID}); err != nil {
+		t.Fatalf("CreateApiToken: %v", err)
+	}
+
+	var identity *Identity
+	handler := CreateStack(Authenticate(store), VerifyAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = GetIdentity(r.Context())
+	}))
+
+	tests := map[string]struct {
+		apiKey string
+		status int
+	}{
+		"valid key":   {"valid-key", http.StatusOK},
+		"unknown key": {"made-up-key", http.StatusUnauthorized},
+		"no key":      {"", http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		identity = nil
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.apiKey != "" {
+			r.Header.Set("X-API-KEY", test.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, test.status)
+		}
+		if test.status == http.StatusOK && (identity == nil || identity.UserID != userID || identity.AuthType != "apiKey") {
+			t.Errorf("%s: identity = %+v, want the key's user", name, identity)
+		}
+	}
+}
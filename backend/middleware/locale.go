@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/colecaccamise/go-backend/i18n"
+)
+
+type localeWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+// Locale resolves the request locale from Accept-Language so responses can be translated.
+func Locale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapped := &localeWriter{
+			ResponseWriter: w,
+			locale:         i18n.Match(r.Header.Get("Accept-Language")),
+		}
+
+		w.Header().Add("Vary", "Accept-Language")
+
+		next.ServeHTTP(wrapped, r)
+	})
+}
+
+// GetLocale returns the locale resolved for the response, or the default locale.
+func GetLocale(w http.ResponseWriter) string {
+	if lw, ok := w.(*localeWriter); ok {
+		return lw.locale
+	}
+	return i18n.DefaultLocale
+}
+
+// SetLocale overrides the response locale, e.g. with the authenticated user's preference.
+func SetLocale(w http.ResponseWriter, locale string) {
+	if lw, ok := w.(*localeWriter); ok && i18n.IsSupported(locale) {
+		lw.locale = locale
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, e.g. to flush streams.
+func (w *localeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AffiliateActive   = "active"
+	AffiliateDisabled = "disabled"
+)
+
+const (
+	CommissionPending  = "pending"
+	CommissionApproved = "approved"
+	CommissionReversed = "reversed"
+	CommissionPaid     = "paid"
+)
+
+type Affiliate struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
+	Code              string    `gorm:"uniqueIndex;not null" json:"code"`
+	CommissionPercent int       `gorm:"not null" json:"commission_percent"`
+	Status            string    `gorm:"not null;default:active" json:"status"`
+	CreatedAt         time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type AffiliateClick struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	AffiliateID uuid.UUID `gorm:"type:uuid;index;not null" json:"affiliate_id"`
+	LandingPath string    `gorm:"" json:"landing_path"`
+	Referrer    string    `gorm:"" json:"referrer"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// AffiliateReferral attributes a signed up user to the affiliate whose link they arrived from.
+type AffiliateReferral struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	AffiliateID uuid.UUID `gorm:"type:uuid;index;not null" json:"affiliate_id"`
+	UserID      uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type Commission struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	AffiliateID     uuid.UUID  `gorm:"type:uuid;index;not null" json:"affiliate_id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null" json:"user_id"`
+	StripeInvoiceID string     `gorm:"uniqueIndex;not null" json:"-"`
+	Amount          int64      `gorm:"not null" json:"amount"`
+	Currency        string     `gorm:"not null" json:"currency"`
+	ReversedAmount  int64      `gorm:"not null;default:0" json:"reversed_amount"`
+	Status          string     `gorm:"index;not null;default:pending" json:"status"`
+	PayoutBatchID   *uuid.UUID `gorm:"type:uuid;index;default:null" json:"payout_batch_id"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// CommissionAdjustment claws back the refunded part of a commission that was already batched for
+// payout. Its amount is negative and it's deducted from the affiliate's next payout.
+type CommissionAdjustment struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	CommissionID  uuid.UUID  `gorm:"type:uuid;index;not null" json:"commission_id"`
+	AffiliateID   uuid.UUID  `gorm:"type:uuid;index;not null" json:"affiliate_id"`
+	Amount        int64      `gorm:"not null" json:"amount"`
+	Currency      string     `gorm:"not null" json:"currency"`
+	PayoutBatchID *uuid.UUID `gorm:"type:uuid;index;default:null" json:"payout_batch_id"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateAffiliateRequest struct {
+	Code string `json:"code"`
+}
+
+type AffiliateStats struct {
+	Clicks      int64 `json:"clicks"`
+	Signups     int64 `json:"signups"`
+	Conversions int64 `json:"conversions"`
+	Pending     int64 `json:"pending"`
+	Approved    int64 `json:"approved"`
+	Paid        int64 `json:"paid"`
+}
+
+type AffiliateDashboardResponse struct {
+	Code              string `json:"code"`
+	Link              string `json:"link"`
+	CommissionPercent int    `json:"commission_percent"`
+	Clicks            int64  `json:"clicks"`
+	Signups           int64  `json:"signups"`
+	Conversions       int64  `json:"conversions"`
+	PendingEarnings   int64  `json:"pending_earnings"`
+	OwedPayout        int64  `json:"owed_payout"`
+	PaidOut           int64  `json:"paid_out"`
+}
+
+const (
+	PayoutBatchOpen = "open"
+	PayoutBatchPaid = "paid"
+)
+
+// PayoutBatch groups the approved commissions settled in one monthly payout run.
+type PayoutBatch struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PeriodStart time.Time  `gorm:"uniqueIndex;not null" json:"period_start"`
+	PeriodEnd   time.Time  `gorm:"not null" json:"period_end"`
+	Status      string     `gorm:"not null;default:open" json:"status"`
+	PaidAt      *time.Time `gorm:"default:null" json:"paid_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// AffiliatePayout is the total owed to one affiliate in a payout batch, per currency.
+type AffiliatePayout struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PayoutBatchID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_affiliate_payout" json:"payout_batch_id"`
+	AffiliateID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_affiliate_payout" json:"affiliate_id"`
+	Currency        string    `gorm:"not null;uniqueIndex:idx_affiliate_payout" json:"currency"`
+	Amount          int64     `gorm:"not null" json:"amount"`
+	CommissionCount int64     `gorm:"not null" json:"commission_count"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type PayoutBatchResponse struct {
+	PayoutBatch
+	Payouts []*AffiliatePayout `json:"payouts"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditUserPurged      = "user.purged"
+	AuditUserExpired     = "user.expired"
+	AuditPasswordChanged = "user.password_changed"
+	AuditEmailChanged    = "user.email_changed"
+	AuditCouponOverused  = "coupon.overused"
+)
+
+// AuditEvent is an append-only record of a sensitive action. UserID may point at a user that no
+// longer exists, so Metadata must never hold PII.
+type AuditEvent struct {
+	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Action    string         `gorm:"not null;index" json:"action"`
+	UserID    *uuid.UUID     `gorm:"type:uuid;index;default:null" json:"user_id"`
+	Metadata  map[string]any `gorm:"serializer:json" json:"metadata"`
+	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PlanFree       = "free"
+	PlanBasic      = "basic"
+	PlanPro        = "pro"
+	PlanPremium    = "premium"
+	PlanEnterprise = "enterprise"
+)
+
+// UsageLimit is the per-period quota for a metric. A zero value means unlimited.
+type UsageLimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+type Plan struct {
+	ID     string                `json:"id"`
+	Name   string                `json:"name"`
+	Limits map[string]UsageLimit `json:"limits"`
+}
+
+const megabyte = 1024 * 1024
+
+var Plans = map[string]Plan{
+	PlanFree: {
+		ID:   PlanFree,
+		Name: "Free",
+		Limits: map[string]UsageLimit{
+			UsageApiRequests:  {Soft: 800, Hard: 1_000},
+			UsageStorageBytes: {Soft: 8 * megabyte, Hard: 10 * megabyte},
+		},
+	},
+	PlanBasic: {
+		ID:   PlanBasic,
+		Name: "Basic",
+		Limits: map[string]UsageLimit{
+			UsageApiRequests:  {Soft: 8_000, Hard: 10_000},
+			UsageStorageBytes: {Soft: 80 * megabyte, Hard: 100 * megabyte},
+		},
+	},
+	PlanPro: {
+		ID:   PlanPro,
+		Name: "Pro",
+		Limits: map[string]UsageLimit{
+			UsageApiRequests:  {Soft: 80_000, Hard: 100_000},
+			UsageStorageBytes: {Soft: 800 * megabyte, Hard: 1024 * megabyte},
+		},
+	},
+	PlanPremium: {
+		ID:   PlanPremium,
+		Name: "Premium",
+		Limits: map[string]UsageLimit{
+			UsageStorageBytes: {Soft: 8 * 1024 * megabyte, Hard: 10 * 1024 * megabyte},
+		},
+	},
+	PlanEnterprise: {
+		ID:     PlanEnterprise,
+		Name:   "Enterprise",
+		Limits: map[string]UsageLimit{},
+	},
+}
+
+// GetPlan returns the plan with the given id, falling back to the free plan.
+func GetPlan(id string) Plan {
+	if plan, ok := Plans[id]; ok {
+		return plan
+	}
+	return Plans[PlanFree]
+}
+
+const (
+	SubscriptionTrialing = "trialing"
+	SubscriptionActive   = "active"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionCanceled = "canceled"
+	SubscriptionExpired  = "expired"
+)
+
+type Subscription struct {
+	ID                   uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID               uuid.UUID  `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
+	Plan                 string     `gorm:"not null;default:free" json:"plan"`
+	Status               string     `gorm:"index" json:"status"`
+	StripeCustomerID     string     `gorm:"default:null;index" json:"-"`
+	StripeSubscriptionID string     `gorm:"default:null" json:"-"`
+	TrialEndsAt          *time.Time `gorm:"default:null" json:"trial_ends_at"`
+	TrialReminderSentAt  *time.Time `gorm:"default:null" json:"-"`
+	PaymentFailedAt      *time.Time `gorm:"default:null" json:"payment_failed_at"`
+	FailedInvoiceID      string     `gorm:"default:null" json:"-"`
+	GraceEndsAt          *time.Time `gorm:"default:null" json:"grace_ends_at"`
+	DunningStep          int        `gorm:"default:0" json:"-"`
+	NextDunningAt        *time.Time `gorm:"default:null" json:"-"`
+	CreatedAt            time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt            time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type StartTrialRequest struct {
+	Plan string `json:"plan"`
+}
+
+// SubscriptionState is the billing summary the dashboard uses to show trial and payment banners.
+type SubscriptionState struct {
+	Plan          string     `json:"plan"`
+	Status        string     `json:"status,omitempty"`
+	TrialEndsAt   *time.Time `json:"trial_ends_at,omitempty"`
+	TrialDaysLeft *int       `json:"trial_days_left,omitempty"`
+	InGracePeriod bool       `json:"in_grace_period"`
+	GraceEndsAt   *time.Time `json:"grace_ends_at,omitempty"`
+}
+
+func NewSubscriptionState(sub *Subscription) *SubscriptionState {
+	if sub == nil {
+		return &SubscriptionState{Plan: PlanFree}
+	}
+
+	state := &SubscriptionState{
+		Plan:   sub.Plan,
+		Status: sub.Status,
+	}
+
+	if sub.Status == SubscriptionTrialing && sub.TrialEndsAt != nil {
+		daysLeft := int(time.Until(*sub.TrialEndsAt).Hours()/24 + 0.5)
+		if daysLeft < 0 {
+			daysLeft = 0
+		}
+		state.TrialEndsAt = sub.TrialEndsAt
+		state.TrialDaysLeft = &daysLeft
+	}
+
+	if sub.Status == SubscriptionPastDue && sub.GraceEndsAt != nil {
+		state.InGracePeriod = true
+		state.GraceEndsAt = sub.GraceEndsAt
+	}
+
+	return state
+}
+
+type Invoice struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	StripeInvoiceID string     `gorm:"uniqueIndex;not null" json:"-"`
+	Number          string     `gorm:"" json:"number"`
+	Status          string     `gorm:"" json:"status"`
+	Description     string     `gorm:"" json:"description"`
+	Currency        string     `gorm:"" json:"currency"`
+	Subtotal        int64      `gorm:"default:0" json:"subtotal"`
+	Discount        int64      `gorm:"default:0" json:"discount"`
+	Tax             int64      `gorm:"default:0" json:"tax"`
+	Total           int64      `gorm:"default:0" json:"total"`
+	AmountPaid      int64      `gorm:"default:0" json:"amount_paid"`
+	PeriodStart     *time.Time `gorm:"default:null" json:"period_start"`
+	PeriodEnd       *time.Time `gorm:"default:null" json:"period_end"`
+	PaidAt          *time.Time `gorm:"default:null" json:"paid_at"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type InvoiceListResponse struct {
+	Invoices []*Invoice `json:"invoices"`
+	Page     int        `json:"page"`
+	PerPage  int        `json:"per_page"`
+	Total    int64      `json:"total"`
+	HasMore  bool       `json:"has_more"`
+}
+
+// BillingProfile holds the customer details printed on receipts.
+type BillingProfile struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"-"`
+	UserID       uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"-"`
+	Name         string    `gorm:"" json:"name"`
+	CompanyName  string    `gorm:"" json:"company_name"`
+	AddressLine1 string    `gorm:"" json:"address_line1"`
+	AddressLine2 string    `gorm:"" json:"address_line2"`
+	City         string    `gorm:"" json:"city"`
+	State        string    `gorm:"" json:"state"`
+	PostalCode   string    `gorm:"" json:"postal_code"`
+	Country      string    `gorm:"" json:"country"`
+	TaxID        string    `gorm:"" json:"tax_id"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type UpdateBillingProfileRequest struct {
+	Name         string `json:"name"`
+	CompanyName  string `json:"company_name"`
+	AddressLine1 string `json:"address_line1"`
+	AddressLine2 string `json:"address_line2"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+	PostalCode   string `json:"postal_code"`
+	Country      string `json:"country"`
+	TaxID        string `json:"tax_id"`
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	CouponDurationOnce      = "once"
+	CouponDurationRepeating = "repeating"
+	CouponDurationForever   = "forever"
+)
+
+type Coupon struct {
+	ID               uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Code             string         `gorm:"uniqueIndex;not null" json:"code"`
+	PercentOff       int            `gorm:"default:0" json:"percent_off,omitempty"`
+	AmountOff        int64          `gorm:"default:0" json:"amount_off,omitempty"`
+	Currency         string         `gorm:"" json:"currency,omitempty"`
+	Duration         string         `gorm:"not null" json:"duration"`
+	DurationInMonths int            `gorm:"default:0" json:"duration_in_months,omitempty"`
+	MaxRedemptions   int            `gorm:"default:0" json:"max_redemptions"`
+	TimesRedeemed    int            `gorm:"default:0" json:"times_redeemed"`
+	ExpiresAt        *time.Time     `gorm:"default:null" json:"expires_at"`
+	AllowedPlans     pq.StringArray `gorm:"type:text[]" json:"allowed_plans"`
+	Active           bool           `gorm:"default:true" json:"active"`
+	StripeCouponID   string         `gorm:"default:null" json:"-"`
+	CreatedAt        time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+const (
+	CouponReserved = "reserved"
+	CouponRedeemed = "redeemed"
+)
+
+// CouponRedemption records a user redeeming a coupon. A user can redeem each coupon once. A
+// checkout reserves the redemption until ExpiresAt, and it counts against the coupon's limit
+// from then on.
+type CouponRedemption struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	CouponID          uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_coupon_redemption" json:"coupon_id"`
+	UserID            uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_coupon_redemption" json:"user_id"`
+	Plan              string     `gorm:"" json:"plan"`
+	Status            string     `gorm:"not null;default:redeemed" json:"status"`
+	ExpiresAt         *time.Time `gorm:"default:null" json:"-"`
+	CheckoutSessionID string     `gorm:"" json:"-"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateCouponRequest struct {
+	Code             string     `json:"code"`
+	PercentOff       int        `json:"percent_off"`
+	AmountOff        int64      `json:"amount_off"`
+	Currency         string     `json:"currency"`
+	Duration         string     `json:"duration"`
+	DurationInMonths int        `json:"duration_in_months"`
+	MaxRedemptions   int        `json:"max_redemptions"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	AllowedPlans     []string   `json:"allowed_plans"`
+}
+
+type CheckoutRequest struct {
+	Plan   string `json:"plan"`
+	Coupon string `json:"coupon"`
+}
+
+type ValidateCouponRequest struct {
+	Plan   string `json:"plan"`
+	Coupon string `json:"coupon"`
+}
+
+// AllowsPlan reports whether the coupon can be applied to the plan. No allowed plans means any plan.
+func (c *Coupon) AllowsPlan(plan string) bool {
+	if len(c.AllowedPlans) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedPlans {
+		if allowed == plan {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EmailEventDelivered  = "delivered"
+	EmailEventBounced    = "bounced"
+	EmailEventComplained = "complained"
+)
+
+// EmailEvent is a delivery event reported by the mail provider. ProviderEventID makes
+// redelivered webhooks idempotent.
+type EmailEvent struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ProviderEventID string    `gorm:"uniqueIndex;not null" json:"-"`
+	ProviderEmailID string    `gorm:"index" json:"provider_email_id"`
+	Type            string    `gorm:"not null" json:"type"`
+	Email           string    `gorm:"index;not null" json:"email"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// EmailSuppression is an address we stop sending to after a hard bounce or spam complaint.
+type EmailSuppression struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Email     string    `gorm:"uniqueIndex;not null" json:"email"`
+	Reason    string    `gorm:"not null" json:"reason"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
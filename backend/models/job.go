@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Job is one run of a registered job handler. Scheduled runs carry the time they were due in
+// ScheduledFor, which is unique per job name so a run is only ever enqueued once.
+type Job struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name         string     `gorm:"not null;uniqueIndex:idx_job_scheduled_run" json:"name"`
+	Payload      string     `gorm:"type:jsonb;not null;default:'{}'" json:"payload"`
+	ScheduledFor *time.Time `gorm:"default:null;uniqueIndex:idx_job_scheduled_run" json:"scheduled_for"`
+	Status       string     `gorm:"not null;default:pending;index:idx_job_status_run_at" json:"status"`
+	RunAt        time.Time  `gorm:"not null;index:idx_job_status_run_at" json:"run_at"`
+	Attempts     int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts  int        `gorm:"not null" json:"max_attempts"`
+	LockedBy     string     `gorm:"" json:"locked_by,omitempty"`
+	LockedAt     *time.Time `gorm:"default:null" json:"locked_at"`
+	LastError    string     `gorm:"" json:"last_error,omitempty"`
+	FinishedAt   *time.Time `gorm:"default:null" json:"finished_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// JobSchedule tracks when a recurring job is next due. Only the leader replica enqueues runs.
+type JobSchedule struct {
+	Name      string     `gorm:"primary_key" json:"name"`
+	Spec      string     `gorm:"not null" json:"spec"`
+	NextRunAt time.Time  `gorm:"not null" json:"next_run_at"`
+	LastRunAt *time.Time `gorm:"default:null" json:"last_run_at"`
+	UpdatedAt time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type JobStatusResponse struct {
+	WorkerID  string           `json:"worker_id"`
+	Leader    bool             `json:"leader"`
+	Counts    map[string]int64 `json:"counts"`
+	Schedules []*JobSchedule   `json:"schedules"`
+	Jobs      []*Job           `json:"jobs"`
+}
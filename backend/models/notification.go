@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	NotificationEmailChangeRequested     = "email_change_requested"
+	NotificationAccountDeletionRequested = "account_deletion_requested"
+	NotificationPasswordChanged          = "password_changed"
+)
+
+// Notification is an in-app notice shown in the dashboard's notification center. Title and Body
+// are rendered in the user's locale when the notification is created.
+type Notification struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index:idx_notification_user_created" json:"-"`
+	Type      string     `gorm:"not null" json:"type"`
+	Title     string     `gorm:"not null" json:"title"`
+	Body      string     `gorm:"" json:"body"`
+	Link      string     `gorm:"" json:"link,omitempty"`
+	ReadAt    *time.Time `gorm:"default:null" json:"read_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime;index:idx_notification_user_created" json:"created_at"`
+}
+
+type NotificationsResponse struct {
+	Notifications []*Notification `json:"notifications"`
+	Unread        int64           `json:"unread"`
+}
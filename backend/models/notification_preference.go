@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	NotificationSecurity  = "security"
+	NotificationBilling   = "billing"
+	NotificationUsage     = "usage"
+	NotificationAffiliate = "affiliate"
+	NotificationProduct   = "product"
+	NotificationDigest    = "digest"
+)
+
+// NotificationCategory groups emails a user can opt in or out of. Required categories are
+// always sent; marketing categories aren't transactional and carry one-click unsubscribe links.
+type NotificationCategory struct {
+	ID        string
+	Required  bool
+	Marketing bool
+}
+
+var NotificationCategories = []NotificationCategory{
+	{ID: NotificationSecurity, Required: true},
+	{ID: NotificationBilling},
+	{ID: NotificationUsage},
+	{ID: NotificationAffiliate},
+	{ID: NotificationProduct, Marketing: true},
+	{ID: NotificationDigest, Marketing: true},
+}
+
+func GetNotificationCategory(id string) (NotificationCategory, bool) {
+	for _, category := range NotificationCategories {
+		if category.ID == id {
+			return category, true
+		}
+	}
+	return NotificationCategory{}, false
+}
+
+// NotificationPreference overrides the default for one category. No row means emails are on.
+type NotificationPreference struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_notification_preference" json:"user_id"`
+	Category  string    `gorm:"not null;uniqueIndex:idx_notification_preference" json:"category"`
+	Email     bool      `gorm:"not null" json:"email"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NotificationPreferenceResponse struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Email    bool   `json:"email"`
+	Required bool   `json:"required"`
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	Email map[string]bool `json:"email"`
+}
+
+// NewNotificationPreferencesResponse lists every category with the user's saved choice applied.
+func NewNotificationPreferencesResponse(preferences []*NotificationPreference) []NotificationPreferenceResponse {
+	saved := map[string]bool{}
+	for _, preference := range preferences {
+		saved[preference.Category] = preference.Email
+	}
+
+	response := make([]NotificationPreferenceResponse, 0, len(NotificationCategories))
+	for _, category := range NotificationCategories {
+		email, ok := saved[category.ID]
+		if !ok || category.Required {
+			email = true
+		}
+
+		response = append(response, NotificationPreferenceResponse{
+			Category: category.ID,
+			Email:    email,
+			Required: category.Required,
+		})
+	}
+	return response
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OrganizationOwner  = "owner"
+	OrganizationMember = "member"
+)
+
+// Organization is a tenant. Its rows, and the rows of every tenant table, are only visible to a
+// store running WithTenant for it.
+type Organization struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type OrganizationMembership struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrganizationID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_organization_memberships_member" json:"organization_id"`
+	UserID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_organization_memberships_member;index" json:"user_id"`
+	Role           string    `gorm:"not null" json:"role"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+	OutboxSkipped = "skipped"
+)
+
+// OutboxEmail is an email waiting to be delivered by the outbox worker. It's written in the
+// same transaction as the change that triggered it, so the two can't disagree.
+type OutboxEmail struct {
+	ID            uuid.UUID         `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	To            string            `gorm:"not null" json:"to"`
+	Subject       string            `gorm:"not null" json:"subject"`
+	HTML          string            `gorm:"not null" json:"-"`
+	Text          string            `gorm:"" json:"-"`
+	Headers       map[string]string `gorm:"serializer:json" json:"-"`
+	Category      string            `gorm:"" json:"category"`
+	Status        string            `gorm:"not null;default:pending;index:idx_outbox_due,priority:1" json:"status"`
+	Attempts      int               `gorm:"default:0" json:"attempts"`
+	NextAttemptAt time.Time         `gorm:"not null;index:idx_outbox_due,priority:2" json:"next_attempt_at"`
+	LastError     string            `gorm:"" json:"last_error"`
+	SentAt        *time.Time        `gorm:"default:null" json:"sent_at"`
+	CreatedAt     time.Time         `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time         `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	UsageApiRequests  = "api_requests"
+	UsageStorageBytes = "storage_bytes"
+)
+
+const (
+	UsageWarningSoft = "soft"
+	UsageWarningHard = "hard"
+)
+
+// UsageBucket aggregates billable events for a user and metric into hourly buckets.
+type UsageBucket struct {
+	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID           uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_usage_bucket" json:"user_id"`
+	Metric           string    `gorm:"not null;uniqueIndex:idx_usage_bucket" json:"metric"`
+	BucketStart      time.Time `gorm:"not null;uniqueIndex:idx_usage_bucket" json:"bucket_start"`
+	Quantity         int64     `gorm:"not null;default:0" json:"quantity"`
+	ReportedQuantity int64     `gorm:"not null;default:0" json:"-"`
+	CreatedAt        time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// UsageWarning records that a limit warning was sent so it only goes out once per period.
+type UsageWarning struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_usage_warning" json:"user_id"`
+	Metric      string    `gorm:"not null;uniqueIndex:idx_usage_warning" json:"metric"`
+	PeriodStart time.Time `gorm:"not null;uniqueIndex:idx_usage_warning" json:"period_start"`
+	Level       string    `gorm:"not null;uniqueIndex:idx_usage_warning" json:"level"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type UsageBucketResponse struct {
+	Start    time.Time `json:"start"`
+	Quantity int64     `json:"quantity"`
+}
+
+type UsageMetricResponse struct {
+	Metric    string                `json:"metric"`
+	Used      int64                 `json:"used"`
+	SoftLimit int64                 `json:"soft_limit,omitempty"`
+	HardLimit int64                 `json:"hard_limit,omitempty"`
+	Buckets   []UsageBucketResponse `json:"buckets"`
+}
+
+type UsageResponse struct {
+	Plan        string                `json:"plan"`
+	PeriodStart time.Time             `json:"period_start"`
+	PeriodEnd   time.Time             `json:"period_end"`
+	Metrics     []UsageMetricResponse `json:"metrics"`
+}
+
+// UsagePeriod returns the calendar month (UTC) containing t.
+func UsagePeriod(t time.Time) (start time.Time, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
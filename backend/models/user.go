@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type CreateUserRequest struct {
@@ -14,6 +15,7 @@ type CreateUserRequest struct {
 type UpdateUserRequest struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Locale    string `json:"locale"`
 }
 
 type UpdateUserEmailRequest struct {
@@ -41,35 +43,63 @@ type ChangePasswordRequest struct {
 }
 
 type User struct {
-	ID                      uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	FirstName               string     `gorm:"" json:"first_name"`
-	LastName                string     `gorm:"" json:"last_name"`
-	Email                   string     `gorm:"unique;not null" json:"email"`
-	UpdatedEmail            string     `gorm:"" json:"updated_email"`
-	CreatedAt               time.Time  `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt               time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
-	HashedPassword          string     `gorm:"" json:"hashed_password"`
-	UpdatedEmailAt          *time.Time `gorm:"default:null" json:"updated_email_at"`
-	UpdatedEmailConfirmedAt *time.Time `gorm:"default:null" json:"updated_email_confirmed_at"`
-	EmailConfirmedAt        *time.Time `gorm:"default:null" json:"email_confirmed_at"`
-	IsAdmin                 bool       `gorm:"default:false" json:"is_admin"`
-	AvatarUrl               string     `gorm:"default:null" json:"avatar_url"`
-	AvatarThumbnailUrl      string     `gorm:"default:null" json:"avatar_thumbnail_url"`
-	DeletedAt               *time.Time `gorm:"default:null" json:"deleted_at"`
-	RestoredAt              *time.Time `gorm:"default:null" json:"restored_at"`
-	SecurityVersionChangedAt *time.Time `gorm:"default:null" json:"security_version_changed_at"`
+	ID                       uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	FirstName                string         `gorm:"serializer:pii" json:"first_name"`
+	LastName                 string         `gorm:"serializer:pii" json:"last_name"`
+	Email                    string         `gorm:"serializer:pii;not null" json:"email"`
+	EmailIndex               string         `gorm:"default:null" json:"-"`
+	EmailGrams               pq.StringArray `gorm:"type:text[]" json:"-"`
+	UpdatedEmail             string         `gorm:"serializer:pii" json:"updated_email"`
+	CreatedAt                time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt                time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	HashedPassword           string         `gorm:"" json:"hashed_password"`
+	UpdatedEmailAt           *time.Time     `gorm:"default:null" json:"updated_email_at"`
+	UpdatedEmailConfirmedAt  *time.Time     `gorm:"default:null" json:"updated_email_confirmed_at"`
+	EmailConfirmedAt         *time.Time     `gorm:"default:null" json:"email_confirmed_at"`
+	IsAdmin                  bool           `gorm:"default:false" json:"is_admin"`
+	AvatarUrl                string         `gorm:"default:null" json:"avatar_url"`
+	AvatarThumbnailUrl       string         `gorm:"default:null" json:"avatar_thumbnail_url"`
+	AvatarSize               int64          `gorm:"default:0" json:"-"`
+	Locale                   string         `gorm:"default:null" json:"locale"`
+	DeletedAt                *time.Time     `gorm:"default:null" json:"deleted_at"`
+	RestoredAt               *time.Time     `gorm:"default:null" json:"restored_at"`
+	SecurityVersionChangedAt *time.Time     `gorm:"default:null" json:"security_version_changed_at"`
+	ConfirmationReminders    int            `gorm:"default:0" json:"-"`
+	Version                  int64          `gorm:"not null;default:1" json:"version"`
 }
 
 type UserIdentityResponse struct {
-	ID             uuid.UUID  `json:"id"`
-	FirstName      string     `json:"first_name"`
-	LastName       string     `json:"last_name"`
-	Email          string     `json:"email"`
-	EmailConfirmed bool       `json:"email_confirmed"`
-	UpdatedEmail   string     `json:"updated_email"`
-	IsAdmin        bool       `json:"is_admin"`
-	AvatarUrl      string     `json:"avatar_url"`
-	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	ID                 uuid.UUID          `json:"id"`
+	FirstName          string             `json:"first_name"`
+	LastName           string             `json:"last_name"`
+	Email              string             `json:"email"`
+	EmailConfirmed     bool               `json:"email_confirmed"`
+	EmailUndeliverable bool               `json:"email_undeliverable"`
+	UpdatedEmail       string             `json:"updated_email"`
+	IsAdmin            bool               `json:"is_admin"`
+	AvatarUrl          string             `json:"avatar_url"`
+	Locale             string             `json:"locale"`
+	DeletedAt          *time.Time         `json:"deleted_at,omitempty"`
+	Subscription       *SubscriptionState `json:"subscription,omitempty"`
+}
+
+// AdminUserResponse is a user as admins see them in the user listing.
+type AdminUserResponse struct {
+	ID               uuid.UUID  `json:"id"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	Email            string     `json:"email"`
+	EmailConfirmedAt *time.Time `json:"email_confirmed_at"`
+	IsAdmin          bool       `json:"is_admin"`
+	Locale           string     `json:"locale"`
+	CreatedAt        time.Time  `json:"created_at"`
+	DeletedAt        *time.Time `json:"deleted_at"`
+}
+
+type UserListResponse struct {
+	Users         []*AdminUserResponse `json:"users"`
+	NextCursor    string               `json:"next_cursor,omitempty"`
+	TotalEstimate int64                `json:"total_estimate"`
 }
 
 func NewUser(req *CreateUserRequest) *User {
@@ -88,9 +118,24 @@ func NewUserIdentityResponse(u *User) *UserIdentityResponse {
 		UpdatedEmail:   u.UpdatedEmail,
 		IsAdmin:        u.IsAdmin,
 		AvatarUrl:      u.AvatarUrl,
+		Locale:         u.Locale,
 		EmailConfirmed: u.EmailConfirmedAt != nil && *u.EmailConfirmedAt != time.Time{},
 		DeletedAt:      u.DeletedAt,
 	}
 }
 
+func NewAdminUserResponse(u *User) *AdminUserResponse {
+	return &AdminUserResponse{
+		ID:               u.ID,
+		FirstName:        u.FirstName,
+		LastName:         u.LastName,
+		Email:            u.Email,
+		EmailConfirmedAt: u.EmailConfirmedAt,
+		IsAdmin:          u.IsAdmin,
+		Locale:           u.Locale,
+		CreatedAt:        u.CreatedAt,
+		DeletedAt:        u.DeletedAt,
+	}
+}
+
 func ValidateUser(u *User) bool { return true }
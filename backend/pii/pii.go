@@ -0,0 +1,300 @@
+// Package pii encrypts personal data before it's stored. Every value gets its own data key,
+// which is sealed with a key-encryption key (KEK) from config, so rotating the KEK only rewraps
+// data keys. Email lookups go through a keyed blind index instead of the ciphertext.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// prefix marks encrypted values, anything without it is plaintext written before encryption.
+const prefix = "pii:v1:"
+
+var keyID = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+var ErrUnknownKey = errors.New("value is sealed with a key that isn't configured")
+
+// Keyring holds the KEKs and the blind index key. New values are sealed with the primary KEK;
+// the others are kept so values sealed before a rotation can still be read.
+//
+// A nil Keyring stores values in plaintext and hashes the index without a key, which is only
+// meant for local development.
+type Keyring struct {
+	primary string
+	keks    map[string]cipher.AEAD
+	index   []byte
+}
+
+// NewKeyring builds a keyring from 32 byte KEKs by id. Ids may only use letters, digits and
+// dashes.
+func NewKeyring(keks map[string][]byte, primary string, indexKey []byte) (*Keyring, error) {
+	if _, ok := keks[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q isn't one of the keys", primary)
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("the index key must be at least 32 bytes")
+	}
+
+	k := &Keyring{primary: primary, keks: map[string]cipher.AEAD{}, index: indexKey}
+	for id, key := range keks {
+		if !keyID.MatchString(id) {
+			return nil, fmt.Errorf("key id %q may only use letters, digits and dashes", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		k.keks[id] = aead
+	}
+
+	return k, nil
+}
+
+// FromEnv reads PII_KEYS, a comma separated list of id:base64 KEKs, PII_PRIMARY_KEY, the id new
+// values are sealed with (the first key by default), and PII_INDEX_KEY, the base64 blind index
+// key. Without PII_KEYS it returns a nil Keyring, which production refuses.
+func FromEnv() (*Keyring, error) {
+	raw := os.Getenv("PII_KEYS")
+	if raw == "" {
+		if os.Getenv("ENVIRONMENT") == "production" {
+			return nil, errors.New("PII_KEYS must be set in production")
+		}
+		return nil, nil
+	}
+
+	keks := map[string][]byte{}
+	primary := os.Getenv("PII_PRIMARY_KEY")
+	for _, entry := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("PII_KEYS entries must look like id:base64key, got %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("PII_KEYS key %s isn't base64: %w", id, err)
+		}
+		keks[id] = key
+		if primary == "" {
+			primary = id
+		}
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(os.Getenv("PII_INDEX_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("PII_INDEX_KEY isn't base64: %w", err)
+	}
+
+	return NewKeyring(keks, primary, indexKey)
+}
+
+// Encrypt seals plaintext with a new data key under the primary KEK. Empty values stay empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(k.primary, dek, data)
+}
+
+// Decrypt opens a value sealed by Encrypt. Plaintext values are returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	_, dek, data, err := k.unwrap(value)
+	if err != nil || dek == nil {
+		return value, err
+	}
+
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, data, nil)
+	return string(plaintext), err
+}
+
+// Reseal brings a stored value up to date: plaintext is encrypted and a data key sealed with an
+// old KEK is rewrapped with the primary one, leaving the data itself as it is. It reports
+// whether the value changed.
+func (k *Keyring) Reseal(value string) (string, bool, error) {
+	if k == nil || value == "" {
+		return value, false, nil
+	}
+	if !strings.HasPrefix(value, prefix) {
+		sealed, err := k.Encrypt(value)
+		return sealed, err == nil, err
+	}
+
+	id, dek, data, err := k.unwrap(value)
+	if err != nil || id == k.primary {
+		return value, false, err
+	}
+
+	rewrapped, err := k.wrap(k.primary, dek, data)
+	return rewrapped, err == nil, err
+}
+
+// Current is the prefix of values sealed with the primary KEK, so a store can find the ones
+// Reseal would change. It's empty for a nil Keyring.
+func (k *Keyring) Current() string {
+	if k == nil {
+		return ""
+	}
+	return prefix + k.primary + ":"
+}
+
+// BlindIndex is a keyed hash of the normalized email, so equal addresses can be found without
+// decrypting and the hash can't be reversed without the index key.
+func (k *Keyring) BlindIndex(email string) string {
+	normalized := []byte(strings.ToLower(strings.TrimSpace(email)))
+	if k == nil {
+		sum := sha256.Sum256(normalized)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write(normalized)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MinSearchLength is the shortest substring SearchTerms can look for. Keyed one and two character
+// grams would amount to a per-character frequency index of every email, so they aren't kept.
+const MinSearchLength = 3
+
+// SearchGrams are keyed hashes of every distinct three character run of the normalized email, so
+// an address can be found by part of it with SearchTerms. They're hashed apart from BlindIndex,
+// and cut to 8 bytes, so they can't be matched against it. Someone with the table still sees which
+// rows share a run and how common each run is, which is what substring search costs; what's in a
+// run stays behind the index key.
+func (k *Keyring) SearchGrams(email string) []string {
+	runes := []rune(strings.ToLower(strings.TrimSpace(email)))
+
+	seen := map[string]bool{}
+	var grams []string
+	for i := 0; i+MinSearchLength <= len(runes); i++ {
+		gram := k.gram(string(runes[i : i+MinSearchLength]))
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// SearchTerms are the grams an email containing substr has among its SearchGrams, one for each
+// of its three character runs, or none when substr is shorter than MinSearchLength. An email with
+// all of them usually contains substr, but not always, so matches must be checked once decrypted.
+func (k *Keyring) SearchTerms(substr string) []string {
+	runes := []rune(strings.ToLower(strings.TrimSpace(substr)))
+
+	var terms []string
+	for i := 0; i+MinSearchLength <= len(runes); i++ {
+		terms = append(terms, k.gram(string(runes[i:i+MinSearchLength])))
+	}
+	return terms
+}
+
+func (k *Keyring) gram(gram string) string {
+	if k == nil {
+		sum := sha256.Sum256([]byte("gram:" + gram))
+		return hex.EncodeToString(sum[:8])
+	}
+
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte("gram:" + gram))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// wrap seals the data key with the KEK, binding the KEK id to it, and formats the value as
+// pii:v1:<kek id>:<sealed data key>:<sealed data>.
+func (k *Keyring) wrap(id string, dek []byte, data []byte) (string, error) {
+	sealedKey, err := seal(k.keks[id], dek, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return prefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealedKey) + ":" + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// unwrap returns a nil data key for plaintext values.
+func (k *Keyring) unwrap(value string) (id string, dek []byte, data []byte, err error) {
+	if !strings.HasPrefix(value, prefix) {
+		return "", nil, nil, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("encrypted value is malformed")
+	}
+	id = parts[0]
+
+	if k == nil {
+		return id, nil, nil, ErrUnknownKey
+	}
+	kek, ok := k.keks[id]
+	if !ok {
+		return id, nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	sealedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return id, nil, nil, err
+	}
+	if data, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return id, nil, nil, err
+	}
+	if dek, err = open(kek, sealedKey, []byte(id)); err != nil {
+		return id, nil, nil, err
+	}
+
+	return id, dek, data, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("keys must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal prepends a random nonce to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,160 @@
+package pii
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+	t.Helper()
+
+	keks := map[string][]byte{}
+	for i, id := range ids {
+		keks[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	keys, err := NewKeyring(keks, primary, bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keys
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+
+	sealed, err := keys.Encrypt("ada@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(sealed, keys.Current()) || strings.Contains(sealed, "ada") {
+		t.Errorf("Encrypt = %q, want a value sealed with k1", sealed)
+	}
+	if again, _ := keys.Encrypt("ada@example.com"); again == sealed {
+		t.Error("Encrypt sealed the same value twice to the same ciphertext")
+	}
+
+	if plaintext, err := keys.Decrypt(sealed); err != nil || plaintext != "ada@example.com" {
+		t.Errorf("Decrypt = %q, %v", plaintext, err)
+	}
+	if plaintext, err := keys.Decrypt("legacy@example.com"); err != nil || plaintext != "legacy@example.com" {
+		t.Errorf("Decrypt of plaintext = %q, %v, want it unchanged", plaintext, err)
+	}
+	if empty, _ := keys.Encrypt(""); empty != "" {
+		t.Errorf("Encrypt of an empty value = %q, want it empty", empty)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-10] ^= 1
+	if _, err := keys.Decrypt(string(tampered)); err == nil {
+		t.Error("Decrypt accepted a tampered value")
+	}
+
+	other := testKeyring(t, "k2", "k2")
+	if _, err := other.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt without the key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestReseal(t *testing.T) {
+	old := testKeyring(t, "k1", "k1")
+	sealed, _ := old.Encrypt("Ada")
+
+	rotated := testKeyring(t, "k2", "k1", "k2")
+	resealed, changed, err := rotated.Reseal(sealed)
+	if err != nil || !changed {
+		t.Fatalf("Reseal = %v, %v, want a changed value", changed, err)
+	}
+	if !strings.HasPrefix(resealed, rotated.Current()) {
+		t.Errorf("Reseal = %q, want it sealed with k2", resealed)
+	}
+	// only the data key is rewrapped, the data stays as it was
+	if strings.Split(resealed, ":")[4] != strings.Split(sealed, ":")[4] {
+		t.Error("Reseal re-encrypted the data instead of rewrapping its key")
+	}
+	if plaintext, _ := rotated.Decrypt(resealed); plaintext != "Ada" {
+		t.Errorf("Decrypt after Reseal = %q, want Ada", plaintext)
+	}
+
+	if _, changed, _ := rotated.Reseal(resealed); changed {
+		t.Error("Reseal changed a value already sealed with the primary key")
+	}
+	if fresh, changed, _ := rotated.Reseal("Grace"); !changed || !strings.HasPrefix(fresh, rotated.Current()) {
+		t.Errorf("Reseal of plaintext = %q, %v, want it encrypted", fresh, changed)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+
+	if keys.BlindIndex(" Ada@Example.com") != keys.BlindIndex("ada@example.com") {
+		t.Error("BlindIndex differs for the same address in different case")
+	}
+	if keys.BlindIndex("ada@example.com") == keys.BlindIndex("grace@example.com") {
+		t.Error("BlindIndex is the same for different addresses")
+	}
+
+	var unkeyed *Keyring
+	if keys.BlindIndex("ada@example.com") == unkeyed.BlindIndex("ada@example.com") {
+		t.Error("BlindIndex ignored the index key")
+	}
+}
+
+func TestSearchGrams(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	grams := keys.SearchGrams("Ada@Example.com")
+
+	for _, substr := range []string{"a@e", "ADA", "a@example", "ada@example.com"} {
+		for _, term := range keys.SearchTerms(substr) {
+			if !slices.Contains(grams, term) {
+				t.Errorf("SearchTerms(%q) has a gram the email doesn't", substr)
+			}
+		}
+	}
+	for _, substr := range []string{"zzz", "grace", "ada@ex.com"} {
+		missing := false
+		for _, term := range keys.SearchTerms(substr) {
+			missing = missing || !slices.Contains(grams, term)
+		}
+		if !missing {
+			t.Errorf("SearchTerms(%q) matches an email that doesn't contain it", substr)
+		}
+	}
+
+	if slices.Contains(grams, keys.BlindIndex("a")) {
+		t.Error("SearchGrams can be matched against BlindIndex")
+	}
+
+	// only the 13 three character runs of ada@example.com, nothing shorter
+	if len(grams) != 13 {
+		t.Errorf("SearchGrams returned %d grams, want 13", len(grams))
+	}
+	for _, substr := range []string{"", "a", "@e"} {
+		if terms := keys.SearchTerms(substr); len(terms) != 0 {
+			t.Errorf("SearchTerms(%q) = %v, want none below MinSearchLength", substr, terms)
+		}
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	index := bytes.Repeat([]byte{2}, 32)
+
+	tests := map[string]struct {
+		keks    map[string][]byte
+		primary string
+		index   []byte
+	}{
+		"missing primary": {map[string][]byte{"k1": key}, "k2", index},
+		"short key":       {map[string][]byte{"k1": key[:16]}, "k1", index},
+		"short index key": {map[string][]byte{"k1": key}, "k1", index[:16]},
+		"bad id":          {map[string][]byte{"k:1": key}, "k:1", index},
+	}
+	for name, test := range tests {
+		if _, err := NewKeyring(test.keks, test.primary, test.index); err == nil {
+			t.Errorf("%s: NewKeyring succeeded", name)
+		}
+	}
+}
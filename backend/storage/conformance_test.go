@@ -0,0 +1,1328 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+// runConformance runs the behavior every Storage implementation must share. newStore must return
+// an empty store.
+func runConformance(t *testing.T, newStore func(t *testing.T) Storage) {
+	tests := map[string]func(*testing.T, Storage){
+		"users":         testUsers,
+		"user cleanup":  testUserCleanup,
+		"user versions": testUserVersions,
+		"user listing":  testUserListing,
+		"user search":   testUserSearch,
+		"tokens":        testTokens,
+		"sessions":      testSessions,
+		"audit":         testAudit,
+		"billing":       testBilling,
+		"coupons":       testCoupons,
+		"affiliates":    testAffiliates,
+		"usage":         testUsage,
+		"outbox":        testOutbox,
+		"notifications": testNotifications,
+		"jobs":          testJobs,
+		"advisory lock": testAdvisoryLock,
+		"transactions":  testTransactions,
+		"tenants":       testTenants,
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, store Storage, email string) *models.User {
+	t.Helper()
+
+	ctx := context.Background()
+	user := models.NewUser(&models.CreateUserRequest{Email: email, HashedPassword: "hash"})
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return user
+}
+
+func testUsers(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "ada@example.com")
+	if user.ID == uuid.Nil {
+		t.Fatal("CreateUser didn't assign an id")
+	}
+
+	if err := store.CreateUser(ctx, models.NewUser(&models.CreateUserRequest{Email: "ada@example.com"})); err == nil {
+		t.Error("CreateUser with a duplicate email succeeded")
+	}
+
+	email := &models.OutboxEmail{To: "ada@example.com", Subject: "Welcome", HTML: "<p>Hi</p>"}
+	user.FirstName = "Ada"
+	if err := store.UpdateUser(ctx, user, email); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	got, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.FirstName != "Ada" {
+		t.Errorf("FirstName = %q, want Ada", got.FirstName)
+	}
+	// without a replica configured the replica read is the primary's
+	if replica, err := store.GetUserByIDFromReplica(ctx, user.ID); err != nil || replica.FirstName != "Ada" {
+		t.Errorf("GetUserByIDFromReplica = %v, %v", replica, err)
+	}
+
+	got.LastName = "changed without saving"
+	if again, _ := store.GetUserByID(ctx, user.ID); again.LastName != "" {
+		t.Error("changing a returned user changed the stored user")
+	}
+
+	if _, err := store.GetUserByEmail(ctx, "ada@example.com"); err != nil {
+		t.Errorf("GetUserByEmail: %v", err)
+	}
+	if _, err := store.GetUserByEmail(ctx, "nobody@example.com"); err == nil {
+		t.Error("GetUserByEmail found a missing user")
+	}
+	if found, err := store.GetUserByEmail(ctx, "ADA@example.com"); err != nil || found.ID != user.ID {
+		t.Errorf("GetUserByEmail with different case = %v, %v, want the user", found, err)
+	}
+	if err := store.CreateUser(ctx, models.NewUser(&models.CreateUserRequest{Email: "Ada@Example.com"})); err == nil {
+		t.Error("CreateUser with the same email in different case succeeded")
+	}
+
+	if _, err := store.ReencryptUsers(ctx, 10); err != nil {
+		t.Errorf("ReencryptUsers: %v", err)
+	}
+	if again, _ := store.GetUserByID(ctx, user.ID); again.Email != "ada@example.com" || again.FirstName != "Ada" {
+		t.Errorf("after ReencryptUsers the user reads back as %s %s", again.FirstName, again.Email)
+	}
+
+	pending, _ := store.GetOutboxEmailsByStatus(ctx, models.OutboxPending, 10)
+	if len(pending) != 1 {
+		t.Errorf("UpdateUser queued %d emails, want 1", len(pending))
+	}
+
+	createUser(t, store, "grace@example.com")
+	if page, _ := store.ListUsers(ctx, UserQuery{}); len(page.Users) != 2 {
+		t.Errorf("ListUsers returned %d users, want 2", len(page.Users))
+	}
+
+	if err := store.DeleteUserByID(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUserByID: %v", err)
+	}
+	if _, err := store.GetUserByID(ctx, user.ID); err == nil {
+		t.Error("GetUserByID found a deleted user")
+	}
+}
+
+func testUserVersions(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "ada@example.com")
+	if user.Version != 1 {
+		t.Fatalf("Version = %d after CreateUser, want 1", user.Version)
+	}
+
+	stale, _ := store.GetUserByID(ctx, user.ID)
+	user.FirstName = "Ada"
+	if err := store.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if user.Version != 2 {
+		t.Errorf("Version = %d after UpdateUser, want 2", user.Version)
+	}
+
+	stale.LastName = "Lovelace"
+	if err := store.UpdateUser(ctx, stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateUser with a stale version = %v, want ErrConflict", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("a conflicting UpdateUser moved Version to %d", stale.Version)
+	}
+	if got, _ := store.GetUserByID(ctx, user.ID); got.FirstName != "Ada" || got.LastName != "" {
+		t.Errorf("stored user = %q %q, want only the first update applied", got.FirstName, got.LastName)
+	}
+
+	if err := store.RevokeSessions(ctx, user.ID, time.Now()); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	if err := store.UpdateUser(ctx, user); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateUser after RevokeSessions = %v, want ErrConflict", err)
+	}
+
+	missing := models.NewUser(&models.CreateUserRequest{Email: "nobody@example.com"})
+	missing.ID = uuid.New()
+	missing.Version = 1
+	if err := store.UpdateUser(ctx, missing); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateUser of a missing user = %v, want ErrConflict", err)
+	}
+}
+
+func testUserListing(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	users := map[string]*models.User{}
+	var mid time.Time
+	for _, name := range []string{"amy", "bob", "cat", "dan", "eve"} {
+		// keep created_at distinct at the database's microsecond precision
+		time.Sleep(time.Millisecond)
+		if name == "dan" {
+			mid = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+		users[name] = createUser(t, store, name+"@example.com")
+	}
+
+	now := time.Now()
+	for name, update := range map[string]func(*models.User){
+		"amy": func(u *models.User) { u.EmailConfirmedAt = &now },
+		"cat": func(u *models.User) { u.EmailConfirmedAt = &now },
+		"bob": func(u *models.User) { u.IsAdmin = true },
+		"eve": func(u *models.User) { u.DeletedAt = &now },
+	} {
+		update(users[name])
+		if err := store.UpdateUser(ctx, users[name]); err != nil {
+			t.Fatalf("UpdateUser(%s): %v", name, err)
+		}
+	}
+
+	// walk every page, checking each page is full until the last
+	walk := func(query UserQuery) []string {
+		t.Helper()
+		var emails []string
+		for {
+			page, err := store.ListUsers(ctx, query)
+			if err != nil {
+				t.Fatalf("ListUsers(%+v): %v", query, err)
+			}
+			for _, user := range page.Users {
+				emails = append(emails, strings.TrimSuffix(user.Email, "@example.com"))
+			}
+			if page.NextCursor == "" {
+				return emails
+			}
+			if len(page.Users) != query.Limit {
+				t.Fatalf("ListUsers returned a page of %d with a next cursor, want %d", len(page.Users), query.Limit)
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+
+	tests := []struct {
+		query UserQuery
+		want  string
+	}{
+		{UserQuery{Sort: UserSortEmail, Limit: 2}, "amy bob cat dan eve"},
+		{UserQuery{Sort: UserSortEmailDesc, Limit: 2}, "eve dan cat bob amy"},
+		{UserQuery{Sort: UserSortOldest, Limit: 3, CreatedAfter: &mid}, "dan eve"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, CreatedBefore: &mid}, "amy bob cat"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Email: "B@EX"}, "bob"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Email: "%@e"}, ""},
+		{UserQuery{Sort: UserSortNewest, Limit: 1, Email: "BOB@example.com"}, "bob"},
+		{UserQuery{Sort: UserSortNewest, Limit: 1, Email: "AT@"}, "cat"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Confirmed: ptr(true)}, "amy cat"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Confirmed: ptr(false), Deleted: ptr(false)}, "bob dan"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Deleted: ptr(true)}, "eve"},
+		{UserQuery{Sort: UserSortEmail, Limit: 2, Admin: ptr(true)}, "bob"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(walk(test.query), " "); got != test.want {
+			t.Errorf("ListUsers(%+v) = %q, want %q", test.query, got, test.want)
+		}
+	}
+
+	if got := strings.Join(walk(UserQuery{Limit: 2}), " "); got != "eve dan cat bob amy" {
+		t.Errorf("ListUsers newest first = %q, want %q", got, "eve dan cat bob amy")
+	}
+
+	page, err := store.ListUsers(ctx, UserQuery{Sort: UserSortEmail, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.TotalEstimate < 1 {
+		t.Errorf("TotalEstimate = %d, want an estimate above 0", page.TotalEstimate)
+	}
+	if _, err := store.ListUsers(ctx, UserQuery{Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("ListUsers with a cursor from another sort returned %v, want ErrInvalidCursor", err)
+	}
+	if _, err := store.ListUsers(ctx, UserQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("ListUsers with a garbage cursor returned %v, want ErrInvalidCursor", err)
+	}
+	if _, err := store.ListUsers(ctx, UserQuery{Email: " t@ "}); !errors.Is(err, ErrSearchTooShort) {
+		t.Errorf("ListUsers searching for two characters returned %v, want ErrSearchTooShort", err)
+	}
+	if _, err := store.ListUsers(ctx, UserQuery{Sort: "password"}); err == nil {
+		t.Error("ListUsers accepted an unknown sort")
+	}
+}
+
+// testUserSearch pages through an email search among users whose emails share every search gram
+// of the term without containing it, so a store that filters after the limit returns short pages.
+func testUserSearch(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	var want []string
+	for i := range 4 {
+		for j := range 3 {
+			createUser(t, store, fmt.Sprintf("abcx.bcd%d%d@example.com", i, j))
+			time.Sleep(time.Millisecond)
+		}
+		email := fmt.Sprintf("abcd%d@example.com", i)
+		createUser(t, store, email)
+		want = append(want, email)
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, sort := range []string{UserSortOldest, UserSortNewest, UserSortEmail} {
+		query := UserQuery{Sort: sort, Limit: 2, Email: "ABCD"}
+		var got []string
+		for {
+			page, err := store.ListUsers(ctx, query)
+			if err != nil {
+				t.Fatalf("ListUsers(%+v): %v", query, err)
+			}
+			for _, user := range page.Users {
+				got = append(got, user.Email)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			if len(page.Users) != query.Limit {
+				t.Fatalf("%s: ListUsers returned a page of %d with a next cursor, want %d", sort, len(page.Users), query.Limit)
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: searching for abcd listed %v, want %v", sort, got, want)
+		}
+	}
+}
+
+func testUserCleanup(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	deleted := createUser(t, store, "deleted@example.com")
+	deletedAt := time.Now().Add(-time.Hour)
+	deleted.DeletedAt = &deletedAt
+	if err := store.UpdateUser(ctx, deleted); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	active := createUser(t, store, "active@example.com")
+	if err := store.SaveNotificationPreferences(ctx, deleted.ID, map[string]bool{models.NotificationProduct: false}); err != nil {
+		t.Fatalf("SaveNotificationPreferences: %v", err)
+	}
+	if err := store.CreateAffiliate(ctx, &models.Affiliate{UserID: deleted.ID, Code: "deleted", CommissionPercent: 20}); err != nil {
+		t.Fatalf("CreateAffiliate: %v", err)
+	}
+
+	due, _ := store.GetUsersDeletedBefore(ctx, time.Now(), 10)
+	if len(due) != 1 || due[0].ID != deleted.ID {
+		t.Fatalf("GetUsersDeletedBefore = %v, want only the deleted user", due)
+	}
+
+	tombstone := &models.AuditEvent{Action: models.AuditUserPurged, UserID: &deleted.ID}
+	if err := store.PurgeUser(ctx, active, &models.AuditEvent{Action: models.AuditUserPurged}); !errors.Is(err, ErrUserNotDeleted) {
+		t.Errorf("PurgeUser(active) = %v, want ErrUserNotDeleted", err)
+	}
+	if err := store.PurgeUser(ctx, deleted, tombstone); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+
+	if prefs, _ := store.GetNotificationPreferences(ctx, deleted.ID); len(prefs) != 0 {
+		t.Error("PurgeUser kept notification preferences")
+	}
+	if affiliate, err := store.GetAffiliateByUserID(ctx, deleted.ID); err != nil || affiliate.Status != models.AffiliateDisabled {
+		t.Errorf("PurgeUser left the affiliate %v (%v), want it disabled", affiliate, err)
+	}
+	if events, _ := store.GetAuditEventsByUserID(ctx, deleted.ID, 10); len(events) != 1 {
+		t.Errorf("PurgeUser wrote %d audit events, want 1", len(events))
+	}
+
+	before := time.Now().Add(time.Minute)
+	if users, _ := store.GetUsersDueConfirmationReminder(ctx, before, 0, 10); len(users) != 1 {
+		t.Errorf("GetUsersDueConfirmationReminder returned %d users, want 1", len(users))
+	}
+
+	expired, _ := store.GetExpiredUnconfirmedUsers(ctx, before, 10)
+	if len(expired) != 1 {
+		t.Fatalf("GetExpiredUnconfirmedUsers returned %d users, want 1", len(expired))
+	}
+
+	confirmedAt := time.Now()
+	active.EmailConfirmedAt = &confirmedAt
+	if err := store.UpdateUser(ctx, active); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := store.ExpireUnconfirmedUser(ctx, active, &models.AuditEvent{Action: models.AuditUserExpired}); !errors.Is(err, ErrUserConfirmed) {
+		t.Errorf("ExpireUnconfirmedUser(confirmed) = %v, want ErrUserConfirmed", err)
+	}
+
+	requestedAt := time.Now().Add(-2 * time.Hour)
+	active.UpdatedEmail = "new@example.com"
+	active.UpdatedEmailAt = &requestedAt
+	if err := store.UpdateUser(ctx, active); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if cleared, _ := store.ClearStaleEmailChanges(ctx, time.Now().Add(-time.Hour)); cleared != 1 {
+		t.Errorf("ClearStaleEmailChanges cleared %d, want 1", cleared)
+	}
+	if got, _ := store.GetUserByID(ctx, active.ID); got.UpdatedEmail != "" {
+		t.Errorf("UpdatedEmail = %q after clearing", got.UpdatedEmail)
+	}
+}
+
+func testTokens(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "tokens@example.com")
+
+	token := &models.ApiToken{UserID: user.ID, HashedToken: "hashed", Name: "ci"}
+	if err := store.CreateApiToken(ctx, token); err != nil {
+		t.Fatalf("CreateApiToken: %v", err)
+	}
+	if err := store.CreateApiToken(ctx, &models.ApiToken{UserID: user.ID, HashedToken: "hashed"}); err == nil {
+		t.Error("CreateApiToken with a duplicate hash succeeded")
+	}
+
+	got, err := store.GetApiTokenByHashedToken(ctx, "hashed")
+	if err != nil || got.ID != token.ID {
+		t.Fatalf("GetApiTokenByHashedToken = %v, %v", got, err)
+	}
+
+	if tokens, _ := store.GetApiTokensByUserID(ctx, user.ID); len(tokens) != 1 {
+		t.Errorf("GetApiTokensByUserID returned %d tokens, want 1", len(tokens))
+	}
+
+	if err := store.DeleteApiToken(ctx, uuid.New(), token.ID); err == nil {
+		t.Error("DeleteApiToken deleted another user's token")
+	}
+	if err := store.DeleteApiToken(ctx, user.ID, token.ID); err != nil {
+		t.Fatalf("DeleteApiToken: %v", err)
+	}
+	if _, err := store.GetApiTokenByHashedToken(ctx, "hashed"); err == nil {
+		t.Error("GetApiTokenByHashedToken found a deleted token")
+	}
+}
+
+func testSessions(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "sessions@example.com")
+
+	at := time.Now().Truncate(time.Second)
+	if err := store.RevokeSessions(ctx, user.ID, at); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+
+	got, _ := store.GetUserByID(ctx, user.ID)
+	if got.SecurityVersionChangedAt == nil || !got.SecurityVersionChangedAt.Equal(at) {
+		t.Errorf("SecurityVersionChangedAt = %v, want %v", got.SecurityVersionChangedAt, at)
+	}
+
+	if err := store.RevokeSessions(ctx, uuid.New(), at); err == nil {
+		t.Error("RevokeSessions succeeded for a missing user")
+	}
+}
+
+func testAudit(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	userID := uuid.New()
+	for _, action := range []string{models.AuditUserExpired, models.AuditUserPurged} {
+		if err := store.CreateAuditEvent(ctx, &models.AuditEvent{Action: action, UserID: &userID, Metadata: map[string]any{"reason": "test"}}); err != nil {
+			t.Fatalf("CreateAuditEvent: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	events, err := store.GetAuditEventsByUserID(ctx, userID, 10)
+	if err != nil {
+		t.Fatalf("GetAuditEventsByUserID: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != models.AuditUserPurged {
+		t.Fatalf("GetAuditEventsByUserID = %v, want newest first", events)
+	}
+	if events[0].Metadata["reason"] != "test" {
+		t.Errorf("Metadata = %v", events[0].Metadata)
+	}
+
+	if limited, _ := store.GetAuditEventsByUserID(ctx, userID, 1); len(limited) != 1 {
+		t.Errorf("GetAuditEventsByUserID ignored the limit")
+	}
+}
+
+func testBilling(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "billing@example.com")
+
+	sub := &models.Subscription{UserID: user.ID, Status: models.SubscriptionActive, StripeCustomerID: "cus_1"}
+	if err := store.SaveSubscription(ctx, sub); err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+	if sub.Plan != models.PlanFree {
+		t.Errorf("Plan = %q, want the free plan by default", sub.Plan)
+	}
+
+	sub.Plan = models.PlanPro
+	if err := store.SaveSubscription(ctx, sub); err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+	if got, _ := store.GetSubscriptionByStripeCustomerID(ctx, "cus_1"); got == nil || got.Plan != models.PlanPro {
+		t.Errorf("GetSubscriptionByStripeCustomerID = %v, want the pro plan", got)
+	}
+	if _, err := store.GetSubscriptionByStripeCustomerID(ctx, "cus_unknown"); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("GetSubscriptionByStripeCustomerID of an unknown customer = %v, want ErrSubscriptionNotFound", err)
+	}
+	if subs, _ := store.GetSubscriptionsByStatus(ctx, models.SubscriptionActive); len(subs) != 1 {
+		t.Errorf("GetSubscriptionsByStatus returned %d subscriptions, want 1", len(subs))
+	}
+
+	inv := &models.Invoice{UserID: user.ID, StripeInvoiceID: "in_1", Status: "open", Total: 1000}
+	if err := store.SaveInvoice(ctx, inv); err != nil {
+		t.Fatalf("SaveInvoice: %v", err)
+	}
+	if err := store.SaveInvoice(ctx, &models.Invoice{UserID: user.ID, StripeInvoiceID: "in_1", Status: "paid", Total: 1000, AmountPaid: 1000}); err != nil {
+		t.Fatalf("SaveInvoice: %v", err)
+	}
+
+	invoices, total, err := store.GetInvoicesByUserID(ctx, user.ID, 10, 0)
+	if err != nil || total != 1 || len(invoices) != 1 {
+		t.Fatalf("GetInvoicesByUserID = %v, %d, %v, want one invoice", invoices, total, err)
+	}
+	if invoices[0].ID != inv.ID || invoices[0].Status != "paid" {
+		t.Errorf("SaveInvoice didn't upsert by stripe id: %+v", invoices[0])
+	}
+	if page, total, _ := store.GetInvoicesByUserID(ctx, user.ID, 10, 5); len(page) != 0 || total != 1 {
+		t.Errorf("GetInvoicesByUserID past the end = %d invoices, total %d", len(page), total)
+	}
+
+	if _, err := store.GetBillingProfileByUserID(ctx, user.ID); err == nil {
+		t.Error("GetBillingProfileByUserID found a missing profile")
+	}
+	if err := store.SaveBillingProfile(ctx, &models.BillingProfile{UserID: user.ID, Country: "US"}); err != nil {
+		t.Fatalf("SaveBillingProfile: %v", err)
+	}
+	if profile, _ := store.GetBillingProfileByUserID(ctx, user.ID); profile == nil || profile.Country != "US" {
+		t.Errorf("GetBillingProfileByUserID = %v", profile)
+	}
+}
+
+func testCoupons(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	coupon := &models.Coupon{Code: "LAUNCH", PercentOff: 20, Duration: models.CouponDurationOnce, MaxRedemptions: 1}
+	if err := store.CreateCoupon(ctx, coupon); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+	if !coupon.Active {
+		t.Error("CreateCoupon didn't default the coupon to active")
+	}
+	if err := store.CreateCoupon(ctx, &models.Coupon{Code: "LAUNCH", Duration: models.CouponDurationOnce}); err == nil {
+		t.Error("CreateCoupon with a duplicate code succeeded")
+	}
+
+	first, second := uuid.New(), uuid.New()
+	if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: first}); err != nil {
+		t.Fatalf("RedeemCoupon: %v", err)
+	}
+	if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: first}); !errors.Is(err, ErrCouponAlreadyRedeemed) {
+		t.Errorf("RedeemCoupon twice = %v, want ErrCouponAlreadyRedeemed", err)
+	}
+	if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: second}); !errors.Is(err, ErrCouponExhausted) {
+		t.Errorf("RedeemCoupon past the limit = %v, want ErrCouponExhausted", err)
+	}
+	if _, err := store.GetCouponRedemption(ctx, coupon.ID, second); err == nil {
+		t.Error("an exhausted redemption was kept")
+	}
+
+	got, err := store.GetCouponByCode(ctx, "LAUNCH")
+	if err != nil || got.TimesRedeemed != 1 {
+		t.Fatalf("GetCouponByCode = %v, %v, want one redemption", got, err)
+	}
+
+	got.Active = false
+	if err := store.UpdateCoupon(ctx, got); err != nil {
+		t.Fatalf("UpdateCoupon: %v", err)
+	}
+	if updated, _ := store.GetCouponByID(ctx, coupon.ID); updated.Active {
+		t.Error("UpdateCoupon didn't save a zero value")
+	}
+	if coupons, _ := store.GetAllCoupons(ctx); len(coupons) != 1 {
+		t.Errorf("GetAllCoupons returned %d coupons, want 1", len(coupons))
+	}
+
+	testCouponReservations(t, store)
+}
+
+func testCouponReservations(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	coupon := &models.Coupon{Code: "SPRING", PercentOff: 10, Duration: models.CouponDurationOnce, MaxRedemptions: 1}
+	if err := store.CreateCoupon(ctx, coupon); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+	timesRedeemed := func() int {
+		got, _ := store.GetCouponByID(ctx, coupon.ID)
+		return got.TimesRedeemed
+	}
+
+	buyer, other := uuid.New(), uuid.New()
+	firstCheckout := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.ReserveCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, ExpiresAt: &firstCheckout}); err != nil {
+		t.Fatalf("ReserveCoupon: %v", err)
+	}
+	if err := store.ReserveCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: other, ExpiresAt: &firstCheckout}); !errors.Is(err, ErrCouponExhausted) {
+		t.Errorf("ReserveCoupon past the limit = %v, want ErrCouponExhausted", err)
+	}
+
+	secondCheckout := firstCheckout.Add(time.Hour)
+	if err := store.ReserveCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, ExpiresAt: &secondCheckout}); err != nil {
+		t.Fatalf("ReserveCoupon again: %v", err)
+	}
+	if got := timesRedeemed(); got != 1 {
+		t.Errorf("TimesRedeemed = %d after reserving twice, want 1", got)
+	}
+
+	// the first checkout expiring leaves the second one's reservation alone
+	if err := store.ReleaseCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, ExpiresAt: &firstCheckout}); err != nil {
+		t.Fatalf("ReleaseCoupon: %v", err)
+	}
+	if got := timesRedeemed(); got != 1 {
+		t.Errorf("TimesRedeemed = %d after releasing a replaced reservation, want 1", got)
+	}
+
+	for range 2 {
+		if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, CheckoutSessionID: "cs_1"}); err != nil {
+			t.Fatalf("RedeemCoupon for the reserved checkout: %v", err)
+		}
+	}
+	if err := store.RedeemCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, CheckoutSessionID: "cs_2"}); !errors.Is(err, ErrCouponAlreadyRedeemed) {
+		t.Errorf("RedeemCoupon for another checkout = %v, want ErrCouponAlreadyRedeemed", err)
+	}
+	if err := store.ReleaseCoupon(ctx, &models.CouponRedemption{CouponID: coupon.ID, UserID: buyer, ExpiresAt: &secondCheckout}); err != nil {
+		t.Fatalf("ReleaseCoupon: %v", err)
+	}
+	if redemption, _ := store.GetCouponRedemption(ctx, coupon.ID, buyer); redemption == nil || redemption.Status != models.CouponRedeemed {
+		t.Errorf("redemption = %+v after releasing a redeemed coupon, want it kept", redemption)
+	}
+	if got := timesRedeemed(); got != 1 {
+		t.Errorf("TimesRedeemed = %d after redeeming, want 1", got)
+	}
+
+	// an abandoned checkout's reservation is given back once it expires
+	summer := &models.Coupon{Code: "SUMMER", PercentOff: 10, Duration: models.CouponDurationOnce, MaxRedemptions: 1}
+	if err := store.CreateCoupon(ctx, summer); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+	expired := time.Now().Add(-time.Second).Truncate(time.Second)
+	if err := store.ReserveCoupon(ctx, &models.CouponRedemption{CouponID: summer.ID, UserID: buyer, ExpiresAt: &expired}); err != nil {
+		t.Fatalf("ReserveCoupon: %v", err)
+	}
+	if err := store.ReserveCoupon(ctx, &models.CouponRedemption{CouponID: summer.ID, UserID: other, ExpiresAt: &firstCheckout}); err != nil {
+		t.Fatalf("ReserveCoupon after another expired: %v", err)
+	}
+	if err := store.ReleaseCoupon(ctx, &models.CouponRedemption{CouponID: summer.ID, UserID: other, ExpiresAt: &firstCheckout}); err != nil {
+		t.Fatalf("ReleaseCoupon: %v", err)
+	}
+	if got, _ := store.GetCouponByID(ctx, summer.ID); got.TimesRedeemed != 0 {
+		t.Errorf("TimesRedeemed = %d after every reservation was released, want 0", got.TimesRedeemed)
+	}
+}
+
+func testAffiliates(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	affiliate := &models.Affiliate{UserID: uuid.New(), Code: "friend", CommissionPercent: 20}
+	if err := store.CreateAffiliate(ctx, affiliate); err != nil {
+		t.Fatalf("CreateAffiliate: %v", err)
+	}
+	if affiliate.Status != models.AffiliateActive {
+		t.Errorf("Status = %q, want active by default", affiliate.Status)
+	}
+	if got, err := store.GetAffiliateByCode(ctx, "friend"); err != nil || got.ID != affiliate.ID {
+		t.Errorf("GetAffiliateByCode = %v, %v", got, err)
+	}
+
+	referred := uuid.New()
+	if err := store.CreateAffiliateClick(ctx, &models.AffiliateClick{AffiliateID: affiliate.ID, LandingPath: "/"}); err != nil {
+		t.Fatalf("CreateAffiliateClick: %v", err)
+	}
+	if err := store.CreateAffiliateReferral(ctx, &models.AffiliateReferral{AffiliateID: affiliate.ID, UserID: referred}); err != nil {
+		t.Fatalf("CreateAffiliateReferral: %v", err)
+	}
+	if err := store.CreateAffiliateReferral(ctx, &models.AffiliateReferral{AffiliateID: uuid.New(), UserID: referred}); err != nil {
+		t.Fatalf("CreateAffiliateReferral again: %v", err)
+	}
+	if referral, _ := store.GetAffiliateReferralByUserID(ctx, referred); referral == nil || referral.AffiliateID != affiliate.ID {
+		t.Errorf("the first referral didn't win: %v", referral)
+	}
+
+	for i, invoice := range []string{"in_1", "in_1", "in_2", "in_3"} {
+		commission := &models.Commission{AffiliateID: affiliate.ID, UserID: referred, StripeInvoiceID: invoice, Amount: int64(100 * (i + 1)), Currency: "usd"}
+		if err := store.CreateCommission(ctx, commission); err != nil {
+			t.Fatalf("CreateCommission: %v", err)
+		}
+	}
+
+	if reversed, _ := store.ReverseCommission(ctx, "in_3", 1000, 1000); reversed != 400 {
+		t.Errorf("ReverseCommission of a full refund reversed %d, want 400", reversed)
+	}
+	for _, want := range []int64{150, 0} {
+		if reversed, _ := store.ReverseCommission(ctx, "in_2", 500, 1000); reversed != want {
+			t.Errorf("ReverseCommission of a half refund reversed %d, want %d", reversed, want)
+		}
+	}
+
+	stats, err := store.GetAffiliateStats(ctx, affiliate.ID)
+	if err != nil {
+		t.Fatalf("GetAffiliateStats: %v", err)
+	}
+	want := models.AffiliateStats{Clicks: 1, Signups: 1, Conversions: 1, Pending: 250}
+	if *stats != want {
+		t.Errorf("GetAffiliateStats = %+v, want %+v", *stats, want)
+	}
+
+	start, _ := models.UsagePeriod(time.Now())
+	if _, err := store.CreatePayoutBatch(ctx, start, start.AddDate(0, 1, 0)); !errors.Is(err, ErrNoApprovedCommissions) {
+		t.Errorf("CreatePayoutBatch with nothing approved = %v, want ErrNoApprovedCommissions", err)
+	}
+
+	if approved, _ := store.ApproveCommissions(ctx, time.Now().Add(time.Minute)); approved != 2 {
+		t.Errorf("ApproveCommissions approved %d, want 2", approved)
+	}
+
+	lastMonth := start.AddDate(0, -1, 0)
+	if _, err := store.CreatePayoutBatch(ctx, lastMonth, start); !errors.Is(err, ErrNoApprovedCommissions) {
+		t.Errorf("CreatePayoutBatch for a period before the commissions = %v, want ErrNoApprovedCommissions", err)
+	}
+
+	batch, err := store.CreatePayoutBatch(ctx, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("CreatePayoutBatch: %v", err)
+	}
+	if _, err := store.CreatePayoutBatch(ctx, start, start.AddDate(0, 1, 0)); !errors.Is(err, ErrPayoutBatchExists) {
+		t.Errorf("CreatePayoutBatch twice = %v, want ErrPayoutBatchExists", err)
+	}
+
+	payouts, _ := store.GetAffiliatePayouts(ctx, batch.ID)
+	if len(payouts) != 1 || payouts[0].Amount != 250 || payouts[0].CommissionCount != 2 {
+		t.Fatalf("GetAffiliatePayouts = %+v, want one payout of 250 from 2 commissions", payouts)
+	}
+
+	// refunding a batched commission is deducted from the next payout instead
+	if reversed, _ := store.ReverseCommission(ctx, "in_1", 1000, 1000); reversed != 100 {
+		t.Errorf("ReverseCommission of a batched commission reversed %d, want 100", reversed)
+	}
+	if stats, _ := store.GetAffiliateStats(ctx, affiliate.ID); stats.Approved != 150 {
+		t.Errorf("Approved = %d with an outstanding adjustment, want 150", stats.Approved)
+	}
+
+	if err := store.MarkPayoutBatchPaid(ctx, batch); err != nil {
+		t.Fatalf("MarkPayoutBatchPaid: %v", err)
+	}
+	if got, _ := store.GetPayoutBatchByID(ctx, batch.ID); got.Status != models.PayoutBatchPaid || got.PaidAt == nil {
+		t.Errorf("GetPayoutBatchByID = %+v, want it paid", got)
+	}
+	if stats, _ := store.GetAffiliateStats(ctx, affiliate.ID); stats.Paid != 250 || stats.Approved != -100 {
+		t.Errorf("Paid, Approved = %d, %d after paying the batch, want 250, -100", stats.Paid, stats.Approved)
+	}
+
+	// the adjustment carries over until the affiliate has earned enough to cover it
+	nextMonth := start.AddDate(0, 1, 0)
+	for i, earned := range []struct {
+		invoice string
+		amount  int64
+	}{{"in_4", 50}, {"in_5", 200}} {
+		if err := store.CreateCommission(ctx, &models.Commission{AffiliateID: affiliate.ID, UserID: referred, StripeInvoiceID: earned.invoice, Amount: earned.amount, Currency: "usd"}); err != nil {
+			t.Fatalf("CreateCommission: %v", err)
+		}
+		store.ApproveCommissions(ctx, time.Now().Add(time.Minute))
+
+		next, err := store.CreatePayoutBatch(ctx, nextMonth.AddDate(0, i, 0), nextMonth.AddDate(0, i+1, 0))
+		if i == 0 {
+			if !errors.Is(err, ErrNoApprovedCommissions) {
+				t.Errorf("CreatePayoutBatch owing more than earned = %v, want ErrNoApprovedCommissions", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("CreatePayoutBatch: %v", err)
+		}
+		payouts, _ := store.GetAffiliatePayouts(ctx, next.ID)
+		if len(payouts) != 1 || payouts[0].Amount != 150 || payouts[0].CommissionCount != 2 {
+			t.Errorf("GetAffiliatePayouts = %+v, want one payout of 150 from 2 commissions", payouts)
+		}
+	}
+
+	if batches, _ := store.GetPayoutBatches(ctx); len(batches) != 2 {
+		t.Errorf("GetPayoutBatches returned %d batches, want 2", len(batches))
+	}
+}
+
+func testUsage(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	userID := uuid.New()
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, at := range []time.Time{hour.Add(5 * time.Minute), hour.Add(50 * time.Minute), hour.Add(time.Hour)} {
+		if err := store.RecordUsage(ctx, userID, models.UsageApiRequests, 2, at); err != nil {
+			t.Fatalf("RecordUsage: %v", err)
+		}
+	}
+
+	buckets, _ := store.GetUsageBuckets(ctx, userID, models.UsageApiRequests, hour, hour.Add(2*time.Hour))
+	if len(buckets) != 2 || buckets[0].Quantity != 4 || buckets[1].Quantity != 2 {
+		t.Fatalf("GetUsageBuckets = %+v, want hourly buckets of 4 and 2", buckets)
+	}
+	if total, _ := store.GetUsageTotal(ctx, userID, models.UsageApiRequests, hour, hour.Add(time.Hour)); total != 4 {
+		t.Errorf("GetUsageTotal = %d, want 4 with an exclusive end", total)
+	}
+
+	// usage is only reported once there's a stripe customer to bill
+	if unreported, _ := store.GetUnreportedUsageBuckets(ctx, 10); len(unreported) != 0 {
+		t.Errorf("GetUnreportedUsageBuckets returned %d buckets for a user without a stripe customer, want none", len(unreported))
+	}
+	if err := store.SaveSubscription(ctx, &models.Subscription{UserID: userID, Plan: models.PlanFree, StripeCustomerID: "cus_usage"}); err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	unreported, _ := store.GetUnreportedUsageBuckets(ctx, 10)
+	if len(unreported) != 2 {
+		t.Fatalf("GetUnreportedUsageBuckets returned %d buckets, want 2", len(unreported))
+	}
+	if err := store.MarkUsageBucketReported(ctx, unreported[0]); err != nil {
+		t.Fatalf("MarkUsageBucketReported: %v", err)
+	}
+	if unreported, _ := store.GetUnreportedUsageBuckets(ctx, 10); len(unreported) != 1 {
+		t.Errorf("GetUnreportedUsageBuckets returned %d buckets after reporting one, want 1", len(unreported))
+	}
+
+	warning := &models.UsageWarning{UserID: userID, Metric: models.UsageApiRequests, PeriodStart: hour, Level: models.UsageWarningSoft}
+	if created, _ := store.CreateUsageWarning(ctx, warning); !created {
+		t.Error("CreateUsageWarning didn't create the first warning")
+	}
+	warning = &models.UsageWarning{UserID: userID, Metric: models.UsageApiRequests, PeriodStart: hour, Level: models.UsageWarningSoft}
+	if created, _ := store.CreateUsageWarning(ctx, warning); created {
+		t.Error("CreateUsageWarning created a duplicate warning")
+	}
+}
+
+func testOutbox(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	later := time.Now().Add(time.Hour)
+	emails := []*models.OutboxEmail{
+		{To: "sent@example.com", Subject: "s", HTML: "h"},
+		{To: "retry@example.com", Subject: "s", HTML: "h"},
+		{To: "bounced@example.com", Subject: "s", HTML: "h"},
+		{To: "skipped@example.com", Subject: "s", HTML: "h"},
+		{To: "later@example.com", Subject: "s", HTML: "h", NextAttemptAt: later},
+	}
+	if err := store.EnqueueEmails(ctx, emails...); err != nil {
+		t.Fatalf("EnqueueEmails: %v", err)
+	}
+
+	retryAt := func(attempts int) *time.Time { return &later }
+	deliver := func(ctx context.Context, email *models.OutboxEmail) error {
+		switch email.To {
+		case "sent@example.com":
+			// the batch is claimed and committed before anything is sent
+			sending, _ := store.GetOutboxEmailsByStatus(ctx, models.OutboxSending, 10)
+			if !slices.ContainsFunc(sending, func(e *models.OutboxEmail) bool { return e.ID == email.ID }) {
+				t.Error("the email being delivered isn't marked sending")
+			}
+			if processed, _ := store.ProcessOutbox(ctx, 10, func(context.Context, *models.OutboxEmail) error { return nil }, retryAt); processed != 0 {
+				t.Errorf("a concurrent ProcessOutbox claimed %d emails already being sent", processed)
+			}
+		case "retry@example.com":
+			return errors.New("provider unavailable")
+		case "bounced@example.com":
+			return ErrUndeliverable
+		case "skipped@example.com":
+			return ErrSkipped
+		}
+		return nil
+	}
+
+	processed, err := store.ProcessOutbox(ctx, 10, deliver, retryAt)
+	if err != nil || processed != 4 {
+		t.Fatalf("ProcessOutbox = %d, %v, want 4 due emails", processed, err)
+	}
+
+	for status, want := range map[string]int{models.OutboxSent: 1, models.OutboxPending: 2, models.OutboxDead: 1, models.OutboxSkipped: 1} {
+		if got, _ := store.GetOutboxEmailsByStatus(ctx, status, 10); len(got) != want {
+			t.Errorf("%d %s emails, want %d", len(got), status, want)
+		}
+	}
+
+	if processed, _ := store.ProcessOutbox(ctx, 10, deliver, retryAt); processed != 0 {
+		t.Errorf("ProcessOutbox processed %d emails that weren't due", processed)
+	}
+
+	if err := store.RetryOutboxEmail(ctx, emails[0].ID); err == nil {
+		t.Error("RetryOutboxEmail retried a sent email")
+	}
+	if err := store.RetryOutboxEmail(ctx, emails[2].ID); err != nil {
+		t.Errorf("RetryOutboxEmail: %v", err)
+	}
+
+	event := &models.EmailEvent{ProviderEventID: "evt_1", Type: models.EmailEventBounced, Email: "bounced@example.com"}
+	if created, _ := store.CreateEmailEvent(ctx, event); !created {
+		t.Error("CreateEmailEvent didn't record the first event")
+	}
+	if created, _ := store.CreateEmailEvent(ctx, &models.EmailEvent{ProviderEventID: "evt_1", Type: models.EmailEventBounced, Email: "bounced@example.com"}); created {
+		t.Error("CreateEmailEvent recorded a duplicate event")
+	}
+
+	if err := store.SuppressEmail(ctx, &models.EmailSuppression{Email: "Bounced@Example.com", Reason: models.EmailEventBounced}); err != nil {
+		t.Fatalf("SuppressEmail: %v", err)
+	}
+	if err := store.SuppressEmail(ctx, &models.EmailSuppression{Email: "bounced@example.com", Reason: models.EmailEventBounced}); err != nil {
+		t.Fatalf("SuppressEmail again: %v", err)
+	}
+	if suppressed, _ := store.IsEmailSuppressed(ctx, "BOUNCED@example.com"); !suppressed {
+		t.Error("IsEmailSuppressed isn't case insensitive")
+	}
+
+	suppressions, _ := store.GetEmailSuppressions(ctx)
+	if len(suppressions) != 1 {
+		t.Fatalf("GetEmailSuppressions returned %d suppressions, want 1", len(suppressions))
+	}
+	if err := store.DeleteEmailSuppression(ctx, suppressions[0].ID); err != nil {
+		t.Fatalf("DeleteEmailSuppression: %v", err)
+	}
+	if err := store.DeleteEmailSuppression(ctx, suppressions[0].ID); err == nil {
+		t.Error("DeleteEmailSuppression deleted a missing suppression")
+	}
+}
+
+func testNotifications(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	user := createUser(t, store, "Notify@example.com")
+
+	if err := store.SaveNotificationPreferences(ctx, user.ID, map[string]bool{models.NotificationProduct: false, models.NotificationSecurity: false}); err != nil {
+		t.Fatalf("SaveNotificationPreferences: %v", err)
+	}
+	if err := store.SaveNotificationPreferences(ctx, user.ID, map[string]bool{models.NotificationUsage: false}); err != nil {
+		t.Fatalf("SaveNotificationPreferences: %v", err)
+	}
+	if prefs, _ := store.GetNotificationPreferences(ctx, user.ID); len(prefs) != 3 {
+		t.Errorf("GetNotificationPreferences returned %d preferences, want 3", len(prefs))
+	}
+
+	for category, want := range map[string]bool{models.NotificationProduct: true, models.NotificationSecurity: false, models.NotificationBilling: false} {
+		if got, _ := store.IsEmailOptedOut(ctx, "notify@EXAMPLE.com", category); got != want {
+			t.Errorf("IsEmailOptedOut(%s) = %v, want %v", category, got, want)
+		}
+	}
+	if got, _ := store.IsEmailOptedOut(ctx, "stranger@example.com", models.NotificationProduct); got {
+		t.Error("an address without an account is opted out")
+	}
+
+	for _, title := range []string{"first", "second"} {
+		if err := store.CreateNotification(ctx, &models.Notification{UserID: user.ID, Type: models.NotificationPasswordChanged, Title: title}); err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	notifications, _ := store.GetNotifications(ctx, user.ID, 10)
+	if len(notifications) != 2 || notifications[0].Title != "second" {
+		t.Fatalf("GetNotifications = %v, want newest first", notifications)
+	}
+
+	if err := store.MarkNotificationRead(ctx, uuid.New(), notifications[0].ID); err == nil {
+		t.Error("MarkNotificationRead marked another user's notification")
+	}
+	if err := store.MarkNotificationRead(ctx, user.ID, notifications[0].ID); err != nil {
+		t.Fatalf("MarkNotificationRead: %v", err)
+	}
+	if unread, _ := store.CountUnreadNotifications(ctx, user.ID); unread != 1 {
+		t.Errorf("CountUnreadNotifications = %d, want 1", unread)
+	}
+	if err := store.MarkAllNotificationsRead(ctx, user.ID); err != nil {
+		t.Fatalf("MarkAllNotificationsRead: %v", err)
+	}
+	if unread, _ := store.CountUnreadNotifications(ctx, user.ID); unread != 0 {
+		t.Errorf("CountUnreadNotifications = %d after marking all read", unread)
+	}
+
+	listenCtx, stopListening := context.WithCancel(ctx)
+	heard := make(chan *models.Notification, 100)
+	stopped := make(chan error)
+	go func() {
+		stopped <- store.ListenNotifications(listenCtx, func(n *models.Notification) { heard <- n })
+	}()
+
+	// the listener may not be listening yet, so keep notifying until it hears one
+	var got *models.Notification
+	for deadline := time.Now().Add(5 * time.Second); got == nil && time.Now().Before(deadline); {
+		if err := store.CreateNotification(ctx, &models.Notification{UserID: user.ID, Type: models.NotificationPasswordChanged, Title: "live"}); err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+		select {
+		case got = <-heard:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	stopListening()
+	if err := <-stopped; err == nil {
+		t.Error("ListenNotifications returned nil after its context was cancelled")
+	}
+
+	if got == nil || got.UserID != user.ID || got.Title != "live" {
+		t.Errorf("ListenNotifications heard %+v, want the live notification", got)
+	}
+}
+
+func testJobs(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	now := time.Now()
+	job := &models.Job{Name: "example", RunAt: now.Add(-time.Second), MaxAttempts: 3}
+	if err := store.EnqueueJob(ctx, job); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if err := store.EnqueueJob(ctx, &models.Job{Name: "example", RunAt: now.Add(time.Hour), MaxAttempts: 3}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	claimed, err := store.ClaimJob(ctx, "worker-1", now.Add(-time.Minute))
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("ClaimJob = %v, %v, want the due job", claimed, err)
+	}
+	if claimed.Status != models.JobRunning || claimed.Attempts != 1 || claimed.LockedBy != "worker-1" {
+		t.Errorf("claimed job = %+v", claimed)
+	}
+	if again, _ := store.ClaimJob(ctx, "worker-2", now.Add(-time.Minute)); again != nil {
+		t.Errorf("ClaimJob claimed %v while nothing was due", again)
+	}
+	stale, _ := store.ClaimJob(ctx, "worker-2", time.Now().Add(time.Minute))
+	if stale == nil || stale.ID != job.ID || stale.Attempts != 2 {
+		t.Fatalf("ClaimJob didn't reclaim the stale job: %v", stale)
+	}
+
+	// the first worker overran its lease, so its outcome is refused
+	if err := store.CompleteJob(ctx, claimed); !errors.Is(err, ErrJobLeaseLost) {
+		t.Errorf("CompleteJob after losing the lease = %v, want ErrJobLeaseLost", err)
+	}
+	if err := store.FailJob(ctx, claimed, nil); !errors.Is(err, ErrJobLeaseLost) {
+		t.Errorf("FailJob after losing the lease = %v, want ErrJobLeaseLost", err)
+	}
+
+	if err := store.FailJob(ctx, stale, nil); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+	if dead, _ := store.GetJobs(ctx, models.JobDead, 10); len(dead) != 1 {
+		t.Fatalf("GetJobs(dead) returned %d jobs, want 1", len(dead))
+	}
+	if err := store.RetryJob(ctx, claimed.ID); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+	if err := store.RetryJob(ctx, claimed.ID); err == nil {
+		t.Error("RetryJob retried a pending job")
+	}
+
+	claimed, _ = store.ClaimJob(ctx, "worker-1", now.Add(-time.Minute))
+	if claimed == nil || claimed.Attempts != 1 {
+		t.Fatalf("ClaimJob after retry = %v, want a fresh attempt", claimed)
+	}
+	if err := store.CompleteJob(ctx, claimed); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	if err := store.CompleteJob(ctx, claimed); !errors.Is(err, ErrJobLeaseLost) {
+		t.Errorf("CompleteJob twice = %v, want ErrJobLeaseLost", err)
+	}
+
+	counts, _ := store.GetJobCounts(ctx)
+	if counts[models.JobSucceeded] != 1 || counts[models.JobPending] != 1 {
+		t.Errorf("GetJobCounts = %v", counts)
+	}
+	if deleted, _ := store.DeleteFinishedJobs(ctx, time.Now().Add(time.Minute)); deleted != 1 {
+		t.Errorf("DeleteFinishedJobs deleted %d jobs, want 1", deleted)
+	}
+
+	first := now.Add(time.Minute).Truncate(time.Second)
+	if err := store.SyncJobSchedule(ctx, "nightly", "0 3 * * *", first); err != nil {
+		t.Fatalf("SyncJobSchedule: %v", err)
+	}
+	if err := store.SyncJobSchedule(ctx, "nightly", "0 3 * * *", first.Add(time.Hour)); err != nil {
+		t.Fatalf("SyncJobSchedule: %v", err)
+	}
+
+	schedules, _ := store.GetJobSchedules(ctx)
+	if len(schedules) != 1 || !schedules[0].NextRunAt.Equal(first) {
+		t.Fatalf("GetJobSchedules = %+v, want the next run kept while the spec is unchanged", schedules)
+	}
+
+	if err := store.EnqueueScheduledJob(ctx, schedules[0], first.Add(24*time.Hour), 1); err != nil {
+		t.Fatalf("EnqueueScheduledJob: %v", err)
+	}
+	schedules[0].NextRunAt = first
+	if err := store.EnqueueScheduledJob(ctx, schedules[0], first.Add(24*time.Hour), 1); err != nil {
+		t.Fatalf("EnqueueScheduledJob again: %v", err)
+	}
+
+	runs := 0
+	jobs, _ := store.GetJobs(ctx, "", 10)
+	for _, job := range jobs {
+		if job.Name == "nightly" {
+			runs++
+		}
+	}
+	if runs != 1 {
+		t.Errorf("scheduled run enqueued %d times, want once", runs)
+	}
+}
+
+func testAdvisoryLock(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	lock, err := store.TryAdvisoryLock(ctx, 42)
+	if err != nil || lock == nil {
+		t.Fatalf("TryAdvisoryLock = %v, %v", lock, err)
+	}
+	if err := lock.Alive(ctx); err != nil {
+		t.Errorf("Alive: %v", err)
+	}
+
+	if other, err := store.TryAdvisoryLock(ctx, 42); err != nil || other != nil {
+		t.Errorf("TryAdvisoryLock took a held lock: %v, %v", other, err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	again, err := store.TryAdvisoryLock(ctx, 42)
+	if err != nil || again == nil {
+		t.Fatalf("TryAdvisoryLock after release = %v, %v", again, err)
+	}
+	_ = again.Release()
+}
+
+func testTransactions(t *testing.T, store Storage) {
+	ctx := context.Background()
+	user := createUser(t, store, "ada@example.com")
+
+	failed := errors.New("failed")
+	err := store.WithTx(ctx, func(tx Storage) error {
+		user.FirstName = "Rolled back"
+		if err := tx.UpdateUser(ctx, user, &models.OutboxEmail{To: "ada@example.com", Subject: "Changed", HTML: "<p>Hi</p>"}); err != nil {
+			return err
+		}
+		if err := tx.CreateAuditEvent(ctx, &models.AuditEvent{Action: models.AuditUserPurged, UserID: &user.ID}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx = %v, want the callback's error", err)
+	}
+
+	user, err = store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.FirstName != "" {
+		t.Errorf("FirstName = %q after rollback, want it unchanged", user.FirstName)
+	}
+	if pending, _ := store.GetOutboxEmailsByStatus(ctx, models.OutboxPending, 10); len(pending) != 0 {
+		t.Errorf("rollback kept %d queued emails", len(pending))
+	}
+	if events, _ := store.GetAuditEventsByUserID(ctx, user.ID, 10); len(events) != 0 {
+		t.Errorf("rollback kept %d audit events", len(events))
+	}
+
+	err = store.WithTx(ctx, func(tx Storage) error {
+		user.FirstName = "Ada"
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+
+		nested := tx.WithTx(ctx, func(tx Storage) error {
+			if err := tx.CreateAuditEvent(ctx, &models.AuditEvent{Action: models.AuditUserPurged, UserID: &user.ID}); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(nested, failed) {
+			t.Errorf("nested WithTx = %v, want the callback's error", nested)
+		}
+
+		return tx.CreateAuditEvent(ctx, &models.AuditEvent{Action: models.AuditUserExpired, UserID: &user.ID})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if got, _ := store.GetUserByID(ctx, user.ID); got.FirstName != "Ada" {
+		t.Errorf("FirstName = %q after commit, want Ada", got.FirstName)
+	}
+	events, _ := store.GetAuditEventsByUserID(ctx, user.ID, 10)
+	if len(events) != 1 || events[0].Action != models.AuditUserExpired {
+		t.Errorf("audit events = %v, want only the one outside the rolled back savepoint", events)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.WithTx(cancelled, func(Storage) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("WithTx with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func testTenants(t *testing.T, store Storage) {
+	ctx := context.Background()
+	ada := createUser(t, store, "ada@example.com")
+	grace := createUser(t, store, "grace@example.com")
+	mallory := createUser(t, store, "mallory@example.com")
+
+	acme := &models.Organization{ID: uuid.New(), Name: "Acme"}
+	globex := &models.Organization{ID: uuid.New(), Name: "Globex"}
+
+	if err := store.CreateOrganization(ctx, acme, ada.ID); !errors.Is(err, ErrOutsideTenant) {
+		t.Fatalf("CreateOrganization without a tenant = %v, want ErrOutsideTenant", err)
+	}
+
+	if err := store.WithTenant(ctx, Tenant{OrgID: acme.ID, UserID: ada.ID}, func(tx Storage) error {
+		if err := tx.CreateOrganization(ctx, acme, ada.ID); err != nil {
+			return err
+		}
+		return tx.AddOrganizationMember(ctx, &models.OrganizationMembership{OrganizationID: acme.ID, UserID: grace.ID, Role: models.OrganizationMember})
+	}); err != nil {
+		t.Fatalf("creating Acme: %v", err)
+	}
+	if err := store.WithTenant(ctx, Tenant{OrgID: globex.ID, UserID: mallory.ID}, func(tx Storage) error {
+		return tx.CreateOrganization(ctx, globex, mallory.ID)
+	}); err != nil {
+		t.Fatalf("creating Globex: %v", err)
+	}
+
+	// a query that forgot its tenant sees nothing at all
+	if orgs, err := store.GetOrganizations(ctx); err != nil || len(orgs) != 0 {
+		t.Errorf("GetOrganizations without a tenant = %d organizations, %v, want none", len(orgs), err)
+	}
+	if _, err := store.GetOrganization(ctx, acme.ID); err == nil {
+		t.Error("GetOrganization without a tenant found Acme")
+	}
+	if members, err := store.GetOrganizationMembers(ctx, acme.ID); err != nil || len(members) != 0 {
+		t.Errorf("GetOrganizationMembers without a tenant = %d members, %v, want none", len(members), err)
+	}
+
+	names := func(orgs []*models.Organization) string {
+		var names []string
+		for _, org := range orgs {
+			names = append(names, org.Name)
+		}
+		return strings.Join(names, " ")
+	}
+
+	store.WithTenant(ctx, Tenant{OrgID: acme.ID, UserID: ada.ID}, func(tx Storage) error {
+		if orgs, _ := tx.GetOrganizations(ctx); names(orgs) != "Acme" {
+			t.Errorf("GetOrganizations in Acme = %q, want only Acme", names(orgs))
+		}
+		if members, _ := tx.GetOrganizationMembers(ctx, acme.ID); len(members) != 2 {
+			t.Errorf("GetOrganizationMembers in Acme = %d members, want 2", len(members))
+		}
+		if _, err := tx.GetOrganization(ctx, globex.ID); err == nil {
+			t.Error("GetOrganization in Acme found Globex")
+		}
+		if members, _ := tx.GetOrganizationMembers(ctx, globex.ID); len(members) != 0 {
+			t.Errorf("GetOrganizationMembers in Acme returned %d of Globex's members", len(members))
+		}
+
+		// a nested tenant is put back when it returns
+		tx.WithTenant(ctx, Tenant{OrgID: globex.ID, UserID: mallory.ID}, func(tx Storage) error {
+			if orgs, _ := tx.GetOrganizations(ctx); names(orgs) != "Globex" {
+				t.Errorf("GetOrganizations in nested Globex = %q, want only Globex", names(orgs))
+			}
+			return nil
+		})
+		if orgs, _ := tx.GetOrganizations(ctx); names(orgs) != "Acme" {
+			t.Errorf("GetOrganizations after the nested tenant = %q, want only Acme", names(orgs))
+		}
+		return nil
+	})
+
+	err := store.WithTenant(ctx, Tenant{OrgID: acme.ID, UserID: ada.ID}, func(tx Storage) error {
+		return tx.AddOrganizationMember(ctx, &models.OrganizationMembership{OrganizationID: globex.ID, UserID: ada.ID, Role: models.OrganizationMember})
+	})
+	if !errors.Is(err, ErrOutsideTenant) {
+		t.Errorf("AddOrganizationMember to another tenant = %v, want ErrOutsideTenant", err)
+	}
+
+	// naming an organization the user doesn't belong to grants nothing
+	store.WithTenant(ctx, Tenant{OrgID: acme.ID, UserID: mallory.ID}, func(tx Storage) error {
+		if _, err := tx.GetOrganization(ctx, acme.ID); !errors.Is(err, ErrOrganizationNotFound) {
+			t.Errorf("GetOrganization for Mallory in Acme = %v, want ErrOrganizationNotFound", err)
+		}
+		if members, _ := tx.GetOrganizationMembers(ctx, acme.ID); len(members) != 0 {
+			t.Errorf("GetOrganizationMembers for Mallory in Acme = %d members, want none", len(members))
+		}
+		return nil
+	})
+	err = store.WithTenant(ctx, Tenant{OrgID: acme.ID, UserID: mallory.ID}, func(tx Storage) error {
+		return tx.AddOrganizationMember(ctx, &models.OrganizationMembership{OrganizationID: acme.ID, UserID: mallory.ID, Role: models.OrganizationOwner})
+	})
+	if !errors.Is(err, ErrOutsideTenant) {
+		t.Errorf("AddOrganizationMember by a non-member = %v, want ErrOutsideTenant", err)
+	}
+	hooli := &models.Organization{ID: uuid.New(), Name: "Hooli"}
+	err = store.WithTenant(ctx, Tenant{OrgID: hooli.ID, UserID: mallory.ID}, func(tx Storage) error {
+		return tx.CreateOrganization(ctx, hooli, ada.ID)
+	})
+	if !errors.Is(err, ErrOutsideTenant) {
+		t.Errorf("CreateOrganization for another owner = %v, want ErrOutsideTenant", err)
+	}
+
+	// a user without an organization picked sees the ones they belong to and can't write
+	store.WithTenant(ctx, Tenant{UserID: grace.ID}, func(tx Storage) error {
+		if orgs, _ := tx.GetOrganizations(ctx); names(orgs) != "Acme" {
+			t.Errorf("GetOrganizations for Grace = %q, want Acme", names(orgs))
+		}
+		return nil
+	})
+	err = store.WithTenant(ctx, Tenant{UserID: grace.ID}, func(tx Storage) error {
+		return tx.AddOrganizationMember(ctx, &models.OrganizationMembership{OrganizationID: acme.ID, UserID: mallory.ID, Role: models.OrganizationMember})
+	})
+	if !errors.Is(err, ErrOutsideTenant) {
+		t.Errorf("AddOrganizationMember without a current organization = %v, want ErrOutsideTenant", err)
+	}
+
+	initech := &models.Organization{ID: uuid.New(), Name: "Initech"}
+	store.WithTenant(ctx, Tenant{OrgID: initech.ID, UserID: ada.ID}, func(tx Storage) error {
+		if err := tx.CreateOrganization(ctx, initech, ada.ID); err != nil {
+			t.Fatalf("CreateOrganization: %v", err)
+		}
+		return errors.New("roll back")
+	})
+	store.WithTenant(ctx, Tenant{UserID: ada.ID}, func(tx Storage) error {
+		if orgs, _ := tx.GetOrganizations(ctx); names(orgs) != "Acme" {
+			t.Errorf("GetOrganizations after a rolled back create = %q, want only Acme", names(orgs))
+		}
+		return nil
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
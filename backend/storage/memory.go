@@ -0,0 +1,596 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Storage for tests. It mirrors the constraints and ordering of
+// PostgresStore, and the conformance suite holds both to the same behavior. Records are copied
+// in and out so callers can't change stored state without going through the store.
+type MemoryStore struct {
+	mu sync.Mutex
+	// txMu serializes WithTx so a rollback can't undo another transaction's writes.
+	txMu sync.Mutex
+
+	memoryTables
+	locks map[int64]bool
+	// notificationListeners aren't tables, so a rollback leaves them alone.
+	notificationListeners map[chan *models.Notification]struct{}
+}
+
+// memoryTables is the state a transaction rolls back.
+type memoryTables struct {
+	users       map[uuid.UUID]*models.User
+	apiTokens   map[uuid.UUID]*models.ApiToken
+	auditEvents map[uuid.UUID]*models.AuditEvent
+
+	subscriptions     map[uuid.UUID]*models.Subscription
+	invoices          map[uuid.UUID]*models.Invoice
+	billingProfiles   map[uuid.UUID]*models.BillingProfile
+	coupons           map[uuid.UUID]*models.Coupon
+	couponRedemptions map[uuid.UUID]*models.CouponRedemption
+
+	affiliates         map[uuid.UUID]*models.Affiliate
+	affiliateClicks    map[uuid.UUID]*models.AffiliateClick
+	affiliateReferrals map[uuid.UUID]*models.AffiliateReferral
+	commissions        map[uuid.UUID]*models.Commission
+	payoutBatches      map[uuid.UUID]*models.PayoutBatch
+	affiliatePayouts   map[uuid.UUID]*models.AffiliatePayout
+
+	commissionAdjustments map[uuid.UUID]*models.CommissionAdjustment
+
+	usageBuckets  map[uuid.UUID]*models.UsageBucket
+	usageWarnings map[uuid.UUID]*models.UsageWarning
+
+	outbox            map[uuid.UUID]*models.OutboxEmail
+	emailEvents       map[uuid.UUID]*models.EmailEvent
+	emailSuppressions map[uuid.UUID]*models.EmailSuppression
+
+	notificationPreferences map[uuid.UUID]*models.NotificationPreference
+	notifications           map[uuid.UUID]*models.Notification
+
+	jobs         map[uuid.UUID]*models.Job
+	jobSchedules map[string]*models.JobSchedule
+
+	organizations           map[uuid.UUID]*models.Organization
+	organizationMemberships map[uuid.UUID]*models.OrganizationMembership
+}
+
+var _ Storage = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		memoryTables: memoryTables{
+			users:                   map[uuid.UUID]*models.User{},
+			apiTokens:               map[uuid.UUID]*models.ApiToken{},
+			auditEvents:             map[uuid.UUID]*models.AuditEvent{},
+			subscriptions:           map[uuid.UUID]*models.Subscription{},
+			invoices:                map[uuid.UUID]*models.Invoice{},
+			billingProfiles:         map[uuid.UUID]*models.BillingProfile{},
+			coupons:                 map[uuid.UUID]*models.Coupon{},
+			couponRedemptions:       map[uuid.UUID]*models.CouponRedemption{},
+			affiliates:              map[uuid.UUID]*models.Affiliate{},
+			affiliateClicks:         map[uuid.UUID]*models.AffiliateClick{},
+			affiliateReferrals:      map[uuid.UUID]*models.AffiliateReferral{},
+			commissions:             map[uuid.UUID]*models.Commission{},
+			payoutBatches:           map[uuid.UUID]*models.PayoutBatch{},
+			affiliatePayouts:        map[uuid.UUID]*models.AffiliatePayout{},
+			commissionAdjustments:   map[uuid.UUID]*models.CommissionAdjustment{},
+			usageBuckets:            map[uuid.UUID]*models.UsageBucket{},
+			usageWarnings:           map[uuid.UUID]*models.UsageWarning{},
+			outbox:                  map[uuid.UUID]*models.OutboxEmail{},
+			emailEvents:             map[uuid.UUID]*models.EmailEvent{},
+			emailSuppressions:       map[uuid.UUID]*models.EmailSuppression{},
+			notificationPreferences: map[uuid.UUID]*models.NotificationPreference{},
+			notifications:           map[uuid.UUID]*models.Notification{},
+			jobs:                    map[uuid.UUID]*models.Job{},
+			jobSchedules:            map[string]*models.JobSchedule{},
+			organizations:           map[uuid.UUID]*models.Organization{},
+			organizationMemberships: map[uuid.UUID]*models.OrganizationMembership{},
+		},
+		locks:                 map[int64]bool{},
+		notificationListeners: map[chan *models.Notification]struct{}{},
+	}
+}
+
+// WithTx snapshots every table and puts the snapshot back if fn fails. Transactions are
+// serialized with each other, but writes made outside one while it runs are lost if it rolls back.
+func (s *MemoryStore) WithTx(ctx context.Context, fn func(Storage) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	return s.runTx(ctx, nil, fn)
+}
+
+// WithTenant is WithTx with the tenant tables limited to tenant.
+func (s *MemoryStore) WithTenant(ctx context.Context, tenant Tenant, fn func(Storage) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	return s.runTx(ctx, &tenant, fn)
+}
+
+// memoryTx is the Storage handed to a WithTx callback. Its WithTx nests like a savepoint and
+// keeps the tenant, if there is one.
+type memoryTx struct {
+	*MemoryStore
+	tenant *Tenant
+}
+
+func (tx memoryTx) WithTx(ctx context.Context, fn func(Storage) error) error {
+	return tx.runTx(ctx, tx.tenant, fn)
+}
+
+func (tx memoryTx) WithTenant(ctx context.Context, tenant Tenant, fn func(Storage) error) error {
+	return tx.runTx(ctx, &tenant, fn)
+}
+
+func (s *MemoryStore) runTx(ctx context.Context, tenant *Tenant, fn func(Storage) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	snapshot := s.memoryTables.clone()
+	s.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.restore(snapshot)
+			panic(p)
+		}
+		if err != nil {
+			s.restore(snapshot)
+		}
+	}()
+
+	if err := fn(memoryTx{MemoryStore: s, tenant: tenant}); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (s *MemoryStore) restore(snapshot memoryTables) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memoryTables = snapshot
+}
+
+func (t *memoryTables) clone() memoryTables {
+	return memoryTables{
+		users:                   cloneTable(t.users),
+		apiTokens:               cloneTable(t.apiTokens),
+		auditEvents:             cloneTable(t.auditEvents),
+		subscriptions:           cloneTable(t.subscriptions),
+		invoices:                cloneTable(t.invoices),
+		billingProfiles:         cloneTable(t.billingProfiles),
+		coupons:                 cloneTable(t.coupons),
+		couponRedemptions:       cloneTable(t.couponRedemptions),
+		affiliates:              cloneTable(t.affiliates),
+		affiliateClicks:         cloneTable(t.affiliateClicks),
+		affiliateReferrals:      cloneTable(t.affiliateReferrals),
+		commissions:             cloneTable(t.commissions),
+		payoutBatches:           cloneTable(t.payoutBatches),
+		affiliatePayouts:        cloneTable(t.affiliatePayouts),
+		commissionAdjustments:   cloneTable(t.commissionAdjustments),
+		usageBuckets:            cloneTable(t.usageBuckets),
+		usageWarnings:           cloneTable(t.usageWarnings),
+		outbox:                  cloneTable(t.outbox),
+		emailEvents:             cloneTable(t.emailEvents),
+		emailSuppressions:       cloneTable(t.emailSuppressions),
+		notificationPreferences: cloneTable(t.notificationPreferences),
+		notifications:           cloneTable(t.notifications),
+		jobs:                    cloneTable(t.jobs),
+		jobSchedules:            cloneTable(t.jobSchedules),
+		organizations:           cloneTable(t.organizations),
+		organizationMemberships: cloneTable(t.organizationMemberships),
+	}
+}
+
+func (s *MemoryStore) CreateUser(_ context.Context, user *models.User, emails ...*models.OutboxEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findUser(func(u *models.User) bool { return sameEmail(u.Email, user.Email) }) != nil {
+		return fmt.Errorf("user already exists with email %s", user.Email)
+	}
+
+	now := time.Now()
+	assignID(&user.ID)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Version = 1
+	s.users[user.ID] = clone(user)
+
+	s.enqueueEmails(emails)
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(_ context.Context, user *models.User, emails ...*models.OutboxEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findUser(func(u *models.User) bool { return sameEmail(u.Email, user.Email) && u.ID != user.ID }) != nil {
+		return fmt.Errorf("user already exists with email %s", user.Email)
+	}
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrConflict
+	}
+
+	user.Version++
+	user.UpdatedAt = time.Now()
+	s.users[user.ID] = clone(user)
+
+	s.enqueueEmails(emails)
+	return nil
+}
+
+func (s *MemoryStore) GetUserByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found with id %s", id)
+	}
+	return clone(user), nil
+}
+
+func (s *MemoryStore) GetUserByIDFromReplica(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.GetUserByID(ctx, id)
+}
+
+func (s *MemoryStore) GetUserByEmail(_ context.Context, email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.findUser(func(u *models.User) bool { return sameEmail(u.Email, email) })
+	if user == nil {
+		return nil, fmt.Errorf("user not found with email %s", email)
+	}
+	return clone(user), nil
+}
+
+func (s *MemoryStore) ListUsers(_ context.Context, query UserQuery) (*UserPage, error) {
+	sort, cursor, limit, err := query.page()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := filter(s.users, func(u *models.User) bool { return matchesUserQuery(u, query) })
+	if isEmailSort(sort) && len(users) > maxEmailSortUsers {
+		return nil, ErrTooManyToSort
+	}
+
+	return pageUsers(sort, users, cursor, limit, int64(len(users))), nil
+}
+
+// ReencryptUsers has nothing to do, the memory store keeps nothing at rest.
+func (s *MemoryStore) ReencryptUsers(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+
+func matchesUserQuery(u *models.User, query UserQuery) bool {
+	switch {
+	case !containsEmail(u, query.Email):
+		return false
+	case query.Confirmed != nil && (u.EmailConfirmedAt != nil) != *query.Confirmed:
+		return false
+	case query.Deleted != nil && (u.DeletedAt != nil) != *query.Deleted:
+		return false
+	case query.Admin != nil && u.IsAdmin != *query.Admin:
+		return false
+	case query.CreatedAfter != nil && u.CreatedAt.Before(*query.CreatedAfter):
+		return false
+	case query.CreatedBefore != nil && !u.CreatedAt.Before(*query.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+func (s *MemoryStore) DeleteUserByID(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, id)
+	// the memberships' foreign key cascades
+	deleteWhere(s.organizationMemberships, func(m *models.OrganizationMembership) bool { return m.UserID == id })
+	return nil
+}
+
+func (s *MemoryStore) GetUsersDeletedBefore(_ context.Context, before time.Time, limit int) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := filter(s.users, func(u *models.User) bool { return u.DeletedAt != nil && u.DeletedAt.Before(before) })
+	slices.SortStableFunc(users, func(a, b *models.User) int { return a.DeletedAt.Compare(*b.DeletedAt) })
+	return take(users, limit), nil
+}
+
+func (s *MemoryStore) PurgeUser(_ context.Context, user *models.User, tombstone *models.AuditEvent, emails ...*models.OutboxEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.DeletedAt == nil {
+		return ErrUserNotDeleted
+	}
+
+	delete(s.users, user.ID)
+	s.deleteUserData(user.ID)
+	s.createAuditEvent(tombstone)
+	s.enqueueEmails(emails)
+	return nil
+}
+
+func (s *MemoryStore) GetUsersDueConfirmationReminder(_ context.Context, createdBefore time.Time, remindersSent int, limit int) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := filter(s.users, func(u *models.User) bool {
+		return u.EmailConfirmedAt == nil && u.DeletedAt == nil && u.CreatedAt.Before(createdBefore) && u.ConfirmationReminders <= remindersSent
+	})
+	sortByCreatedAt(users)
+	return take(users, limit), nil
+}
+
+func (s *MemoryStore) GetExpiredUnconfirmedUsers(_ context.Context, createdBefore time.Time, limit int) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := filter(s.users, func(u *models.User) bool { return u.EmailConfirmedAt == nil && u.CreatedAt.Before(createdBefore) })
+	sortByCreatedAt(users)
+	return take(users, limit), nil
+}
+
+func (s *MemoryStore) ExpireUnconfirmedUser(_ context.Context, user *models.User, tombstone *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.EmailConfirmedAt != nil {
+		return ErrUserConfirmed
+	}
+
+	delete(s.users, user.ID)
+	s.deleteUserData(user.ID)
+	s.createAuditEvent(tombstone)
+	return nil
+}
+
+func (s *MemoryStore) ClearStaleEmailChanges(_ context.Context, requestedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cleared int64
+	for _, user := range s.users {
+		if user.UpdatedEmail != "" && user.UpdatedEmailAt != nil && user.UpdatedEmailAt.Before(requestedBefore) {
+			user.UpdatedEmail = ""
+			user.UpdatedEmailAt = nil
+			user.Version++
+			user.UpdatedAt = time.Now()
+			cleared++
+		}
+	}
+	return cleared, nil
+}
+
+// deleteUserData mirrors the Postgres cascade for a hard-deleted user.
+func (s *MemoryStore) deleteUserData(userID uuid.UUID) {
+	deleteWhere(s.apiTokens, func(t *models.ApiToken) bool { return t.UserID == userID })
+	deleteWhere(s.notifications, func(n *models.Notification) bool { return n.UserID == userID })
+	deleteWhere(s.notificationPreferences, func(p *models.NotificationPreference) bool { return p.UserID == userID })
+	deleteWhere(s.usageBuckets, func(b *models.UsageBucket) bool { return b.UserID == userID })
+	deleteWhere(s.usageWarnings, func(w *models.UsageWarning) bool { return w.UserID == userID })
+	deleteWhere(s.billingProfiles, func(p *models.BillingProfile) bool { return p.UserID == userID })
+	deleteWhere(s.subscriptions, func(sub *models.Subscription) bool { return sub.UserID == userID })
+	deleteWhere(s.affiliateReferrals, func(r *models.AffiliateReferral) bool { return r.UserID == userID })
+	deleteWhere(s.organizationMemberships, func(m *models.OrganizationMembership) bool { return m.UserID == userID })
+
+	for _, affiliate := range s.affiliates {
+		if affiliate.UserID == userID {
+			affiliate.Status = models.AffiliateDisabled
+			affiliate.UpdatedAt = time.Now()
+		}
+	}
+}
+
+func (s *MemoryStore) findUser(match func(*models.User) bool) *models.User {
+	for _, user := range s.users {
+		if match(user) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateApiToken(_ context.Context, token *models.ApiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.apiTokens {
+		if existing.HashedToken == token.HashedToken {
+			return fmt.Errorf("api token already exists")
+		}
+	}
+
+	now := time.Now()
+	assignID(&token.ID)
+	token.CreatedAt = now
+	token.UpdatedAt = now
+	s.apiTokens[token.ID] = clone(token)
+	return nil
+}
+
+func (s *MemoryStore) GetApiTokenByHashedToken(_ context.Context, hashedToken string) (*models.ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.apiTokens {
+		if token.HashedToken == hashedToken {
+			return clone(token), nil
+		}
+	}
+	return nil, fmt.Errorf("api token not found")
+}
+
+func (s *MemoryStore) GetApiTokensByUserID(_ context.Context, userID uuid.UUID) ([]*models.ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := filter(s.apiTokens, func(t *models.ApiToken) bool { return t.UserID == userID })
+	slices.SortStableFunc(tokens, func(a, b *models.ApiToken) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return tokens, nil
+}
+
+func (s *MemoryStore) DeleteApiToken(_ context.Context, userID uuid.UUID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[id]
+	if !ok || token.UserID != userID {
+		return fmt.Errorf("api token not found with id %s", id)
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) RevokeSessions(_ context.Context, userID uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("user not found with id %s", userID)
+	}
+	user.SecurityVersionChangedAt = &at
+	user.Version++
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) CreateAuditEvent(_ context.Context, event *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.createAuditEvent(event)
+	return nil
+}
+
+func (s *MemoryStore) createAuditEvent(event *models.AuditEvent) {
+	assignID(&event.ID)
+	event.CreatedAt = time.Now()
+	s.auditEvents[event.ID] = clone(event)
+}
+
+func (s *MemoryStore) GetAuditEventsByUserID(_ context.Context, userID uuid.UUID, limit int) ([]*models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := filter(s.auditEvents, func(e *models.AuditEvent) bool { return e.UserID != nil && *e.UserID == userID })
+	slices.SortStableFunc(events, func(a, b *models.AuditEvent) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return take(events, limit), nil
+}
+
+// memoryLock is a MemoryStore advisory lock. It's only exclusive within the process.
+type memoryLock struct {
+	store *MemoryStore
+	key   int64
+}
+
+func (s *MemoryStore) TryAdvisoryLock(ctx context.Context, key int64) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks[key] {
+		return nil, nil
+	}
+	s.locks[key] = true
+	return &memoryLock{store: s, key: key}, nil
+}
+
+func (l *memoryLock) Alive(ctx context.Context) error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	if !l.store.locks[l.key] {
+		return fmt.Errorf("advisory lock %d is no longer held", l.key)
+	}
+	return ctx.Err()
+}
+
+func (l *memoryLock) Release() error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	delete(l.store.locks, l.key)
+	return nil
+}
+
+func assignID(id *uuid.UUID) {
+	if *id == uuid.Nil {
+		*id = uuid.New()
+	}
+}
+
+func clone[T any](v *T) *T {
+	c := *v
+	return &c
+}
+
+func cloneTable[K comparable, T any](records map[K]*T) map[K]*T {
+	cloned := make(map[K]*T, len(records))
+	for key, record := range records {
+		cloned[key] = clone(record)
+	}
+	return cloned
+}
+
+// filter returns copies of the records that match.
+func filter[K comparable, T any](records map[K]*T, match func(*T) bool) []*T {
+	matched := []*T{}
+	for _, record := range records {
+		if match(record) {
+			matched = append(matched, clone(record))
+		}
+	}
+	return matched
+}
+
+func deleteWhere[T any](records map[uuid.UUID]*T, match func(*T) bool) {
+	for id, record := range records {
+		if match(record) {
+			delete(records, id)
+		}
+	}
+}
+
+// take applies a query limit. A negative limit means no limit.
+func take[T any](records []T, limit int) []T {
+	if limit >= 0 && len(records) > limit {
+		return records[:limit]
+	}
+	return records
+}
+
+func sortByCreatedAt(users []*models.User) {
+	slices.SortStableFunc(users, func(a, b *models.User) int { return a.CreatedAt.Compare(b.CreatedAt) })
+}
+
+func sameEmail(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
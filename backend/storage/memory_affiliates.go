@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) CreateAffiliate(_ context.Context, affiliate *models.Affiliate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.affiliates {
+		if existing.UserID == affiliate.UserID || existing.Code == affiliate.Code {
+			return fmt.Errorf("affiliate already exists for user %s or code %s", affiliate.UserID, affiliate.Code)
+		}
+	}
+
+	now := time.Now()
+	assignID(&affiliate.ID)
+	if affiliate.Status == "" {
+		affiliate.Status = models.AffiliateActive
+	}
+	affiliate.CreatedAt = now
+	affiliate.UpdatedAt = now
+	s.affiliates[affiliate.ID] = clone(affiliate)
+	return nil
+}
+
+func (s *MemoryStore) GetAffiliateByID(_ context.Context, id uuid.UUID) (*models.Affiliate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	affiliate, ok := s.affiliates[id]
+	if !ok {
+		return nil, fmt.Errorf("affiliate not found with id %s", id)
+	}
+	return clone(affiliate), nil
+}
+
+func (s *MemoryStore) GetAffiliateByCode(_ context.Context, code string) (*models.Affiliate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, affiliate := range s.affiliates {
+		if affiliate.Code == code {
+			return clone(affiliate), nil
+		}
+	}
+	return nil, fmt.Errorf("affiliate not found with code %s", code)
+}
+
+func (s *MemoryStore) GetAffiliateByUserID(_ context.Context, userID uuid.UUID) (*models.Affiliate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, affiliate := range s.affiliates {
+		if affiliate.UserID == userID {
+			return clone(affiliate), nil
+		}
+	}
+	return nil, fmt.Errorf("affiliate not found for user %s", userID)
+}
+
+func (s *MemoryStore) CreateAffiliateClick(_ context.Context, click *models.AffiliateClick) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignID(&click.ID)
+	click.CreatedAt = time.Now()
+	s.affiliateClicks[click.ID] = clone(click)
+	return nil
+}
+
+func (s *MemoryStore) CreateAffiliateReferral(_ context.Context, referral *models.AffiliateReferral) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.affiliateReferrals {
+		if existing.UserID == referral.UserID {
+			return nil
+		}
+	}
+
+	assignID(&referral.ID)
+	referral.CreatedAt = time.Now()
+	s.affiliateReferrals[referral.ID] = clone(referral)
+	return nil
+}
+
+func (s *MemoryStore) GetAffiliateReferralByUserID(_ context.Context, userID uuid.UUID) (*models.AffiliateReferral, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, referral := range s.affiliateReferrals {
+		if referral.UserID == userID {
+			return clone(referral), nil
+		}
+	}
+	return nil, fmt.Errorf("referral not found for user %s", userID)
+}
+
+func (s *MemoryStore) CreateCommission(_ context.Context, commission *models.Commission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.commissions {
+		if existing.StripeInvoiceID == commission.StripeInvoiceID {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	assignID(&commission.ID)
+	if commission.Status == "" {
+		commission.Status = models.CommissionPending
+	}
+	commission.CreatedAt = now
+	commission.UpdatedAt = now
+	s.commissions[commission.ID] = clone(commission)
+	return nil
+}
+
+func (s *MemoryStore) GetAffiliateStats(_ context.Context, affiliateID uuid.UUID) (*models.AffiliateStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &models.AffiliateStats{}
+
+	for _, click := range s.affiliateClicks {
+		if click.AffiliateID == affiliateID {
+			stats.Clicks++
+		}
+	}
+
+	for _, referral := range s.affiliateReferrals {
+		if referral.AffiliateID == affiliateID {
+			stats.Signups++
+		}
+	}
+
+	converted := map[uuid.UUID]bool{}
+	for _, commission := range s.commissions {
+		if commission.AffiliateID != affiliateID {
+			continue
+		}
+
+		if commission.Status != models.CommissionReversed {
+			converted[commission.UserID] = true
+		}
+
+		switch commission.Status {
+		case models.CommissionPending:
+			stats.Pending += commission.Amount - commission.ReversedAmount
+		case models.CommissionApproved:
+			stats.Approved += commission.Amount - commission.ReversedAmount
+		case models.CommissionPaid:
+			stats.Paid += commission.Amount - commission.ReversedAmount
+		}
+	}
+	stats.Conversions = int64(len(converted))
+
+	for _, adjustment := range s.commissionAdjustments {
+		if adjustment.AffiliateID != affiliateID {
+			continue
+		}
+		if adjustment.PayoutBatchID != nil && s.payoutBatches[*adjustment.PayoutBatchID].Status == models.PayoutBatchPaid {
+			stats.Paid += adjustment.Amount
+		} else {
+			stats.Approved += adjustment.Amount
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *MemoryStore) ApproveCommissions(_ context.Context, createdBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var approved int64
+	for _, commission := range s.commissions {
+		if commission.Status == models.CommissionPending && commission.CreatedAt.Before(createdBefore) {
+			commission.Status = models.CommissionApproved
+			commission.UpdatedAt = time.Now()
+			approved++
+		}
+	}
+	return approved, nil
+}
+
+func (s *MemoryStore) ReverseCommission(_ context.Context, stripeInvoiceID string, refunded, charged int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var commission *models.Commission
+	for _, existing := range s.commissions {
+		if existing.StripeInvoiceID == stripeInvoiceID {
+			commission = existing
+		}
+	}
+	if commission == nil || commission.Status == models.CommissionReversed {
+		return 0, nil
+	}
+
+	reversed := commission.ReversedAmount
+	for _, adjustment := range s.commissionAdjustments {
+		if adjustment.CommissionID == commission.ID {
+			reversed -= adjustment.Amount
+		}
+	}
+
+	amount := commissionReversal(commission, reversed, refunded, charged)
+	if amount == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	if commission.PayoutBatchID == nil {
+		commission.ReversedAmount += amount
+		if commission.ReversedAmount == commission.Amount {
+			commission.Status = models.CommissionReversed
+		}
+		commission.UpdatedAt = now
+		return amount, nil
+	}
+
+	adjustment := &models.CommissionAdjustment{
+		ID:           uuid.New(),
+		CommissionID: commission.ID,
+		AffiliateID:  commission.AffiliateID,
+		Amount:       -amount,
+		Currency:     commission.Currency,
+		CreatedAt:    now,
+	}
+	s.commissionAdjustments[adjustment.ID] = adjustment
+	return amount, nil
+}
+
+func (s *MemoryStore) CreatePayoutBatch(_ context.Context, periodStart, periodEnd time.Time) (*models.PayoutBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.payoutBatches {
+		if existing.PeriodStart.Equal(periodStart) {
+			return nil, ErrPayoutBatchExists
+		}
+	}
+
+	// commissions earned by the end of the period, including earlier ones that were still on hold
+	var approved []*models.Commission
+	for _, commission := range s.commissions {
+		if commission.Status == models.CommissionApproved && commission.PayoutBatchID == nil && commission.CreatedAt.Before(periodEnd) {
+			approved = append(approved, commission)
+		}
+	}
+	var adjustments []*models.CommissionAdjustment
+	for _, adjustment := range s.commissionAdjustments {
+		if adjustment.PayoutBatchID == nil && adjustment.CreatedAt.Before(periodEnd) {
+			adjustments = append(adjustments, adjustment)
+		}
+	}
+
+	now := time.Now()
+	batch := &models.PayoutBatch{
+		ID:          uuid.New(),
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Status:      models.PayoutBatchOpen,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	payouts, commissions, adjustments := payoutBatchContents(batch.ID, approved, adjustments, now)
+	if len(payouts) == 0 {
+		return nil, ErrNoApprovedCommissions
+	}
+
+	s.payoutBatches[batch.ID] = clone(batch)
+	for _, payout := range payouts {
+		s.affiliatePayouts[payout.ID] = payout
+	}
+	for _, commission := range commissions {
+		batchID := batch.ID
+		commission.PayoutBatchID = &batchID
+		commission.UpdatedAt = now
+	}
+	for _, adjustment := range adjustments {
+		batchID := batch.ID
+		adjustment.PayoutBatchID = &batchID
+	}
+
+	return batch, nil
+}
+
+func (s *MemoryStore) GetPayoutBatches(_ context.Context) ([]*models.PayoutBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := filter(s.payoutBatches, func(*models.PayoutBatch) bool { return true })
+	slices.SortStableFunc(batches, func(a, b *models.PayoutBatch) int { return b.PeriodStart.Compare(a.PeriodStart) })
+	return batches, nil
+}
+
+func (s *MemoryStore) GetPayoutBatchByID(_ context.Context, id uuid.UUID) (*models.PayoutBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.payoutBatches[id]
+	if !ok {
+		return nil, fmt.Errorf("payout batch not found with id %s", id)
+	}
+	return clone(batch), nil
+}
+
+func (s *MemoryStore) GetAffiliatePayouts(_ context.Context, batchID uuid.UUID) ([]*models.AffiliatePayout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payouts := filter(s.affiliatePayouts, func(p *models.AffiliatePayout) bool { return p.PayoutBatchID == batchID })
+	slices.SortStableFunc(payouts, func(a, b *models.AffiliatePayout) int { return cmp.Compare(b.Amount, a.Amount) })
+	return payouts, nil
+}
+
+func (s *MemoryStore) MarkPayoutBatchPaid(_ context.Context, batch *models.PayoutBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	batch.Status = models.PayoutBatchPaid
+	batch.PaidAt = &now
+	batch.UpdatedAt = now
+	s.payoutBatches[batch.ID] = clone(batch)
+
+	for _, commission := range s.commissions {
+		if commission.PayoutBatchID != nil && *commission.PayoutBatchID == batch.ID {
+			commission.Status = models.CommissionPaid
+			commission.UpdatedAt = now
+		}
+	}
+	return nil
+}
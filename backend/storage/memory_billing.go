@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) GetSubscriptionByUserID(_ context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscriptions {
+		if sub.UserID == userID {
+			return clone(sub), nil
+		}
+	}
+	return nil, fmt.Errorf("%w for user %s", ErrSubscriptionNotFound, userID)
+}
+
+func (s *MemoryStore) GetSubscriptionByStripeCustomerID(_ context.Context, customerID string) (*models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscriptions {
+		if sub.StripeCustomerID == customerID {
+			return clone(sub), nil
+		}
+	}
+	return nil, fmt.Errorf("%w for customer %s", ErrSubscriptionNotFound, customerID)
+}
+
+func (s *MemoryStore) GetSubscriptionsByStatus(_ context.Context, status string) ([]*models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return filter(s.subscriptions, func(sub *models.Subscription) bool { return sub.Status == status }), nil
+}
+
+func (s *MemoryStore) SaveSubscription(_ context.Context, sub *models.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.subscriptions {
+		if existing.UserID == sub.UserID && existing.ID != sub.ID {
+			return fmt.Errorf("subscription already exists for user %s", sub.UserID)
+		}
+	}
+
+	now := time.Now()
+	if existing, ok := s.subscriptions[sub.ID]; ok {
+		sub.CreatedAt = existing.CreatedAt
+	} else {
+		assignID(&sub.ID)
+		sub.CreatedAt = now
+		if sub.Plan == "" {
+			sub.Plan = models.PlanFree
+		}
+	}
+	sub.UpdatedAt = now
+	s.subscriptions[sub.ID] = clone(sub)
+	return nil
+}
+
+func (s *MemoryStore) SaveInvoice(_ context.Context, inv *models.Invoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, existing := range s.invoices {
+		if existing.StripeInvoiceID != inv.StripeInvoiceID {
+			continue
+		}
+
+		existing.Number = inv.Number
+		existing.Status = inv.Status
+		existing.Description = inv.Description
+		existing.Currency = inv.Currency
+		existing.Subtotal = inv.Subtotal
+		existing.Discount = inv.Discount
+		existing.Tax = inv.Tax
+		existing.Total = inv.Total
+		existing.AmountPaid = inv.AmountPaid
+		existing.PeriodStart = inv.PeriodStart
+		existing.PeriodEnd = inv.PeriodEnd
+		existing.PaidAt = inv.PaidAt
+		existing.UpdatedAt = now
+		inv.ID = existing.ID
+		return nil
+	}
+
+	assignID(&inv.ID)
+	inv.CreatedAt = now
+	inv.UpdatedAt = now
+	s.invoices[inv.ID] = clone(inv)
+	return nil
+}
+
+func (s *MemoryStore) GetInvoiceByID(_ context.Context, id uuid.UUID) (*models.Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invoices[id]
+	if !ok {
+		return nil, fmt.Errorf("invoice not found with id %s", id)
+	}
+	return clone(inv), nil
+}
+
+func (s *MemoryStore) GetInvoicesByUserID(_ context.Context, userID uuid.UUID, limit, offset int) ([]*models.Invoice, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoices := filter(s.invoices, func(inv *models.Invoice) bool { return inv.UserID == userID })
+	slices.SortStableFunc(invoices, func(a, b *models.Invoice) int { return b.CreatedAt.Compare(a.CreatedAt) })
+
+	total := int64(len(invoices))
+	if offset > len(invoices) {
+		offset = len(invoices)
+	}
+	return take(invoices[offset:], limit), total, nil
+}
+
+func (s *MemoryStore) GetBillingProfileByUserID(_ context.Context, userID uuid.UUID) (*models.BillingProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, profile := range s.billingProfiles {
+		if profile.UserID == userID {
+			return clone(profile), nil
+		}
+	}
+	return nil, fmt.Errorf("billing profile not found for user %s", userID)
+}
+
+func (s *MemoryStore) SaveBillingProfile(_ context.Context, profile *models.BillingProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.billingProfiles {
+		if existing.UserID == profile.UserID && existing.ID != profile.ID {
+			return fmt.Errorf("billing profile already exists for user %s", profile.UserID)
+		}
+	}
+
+	now := time.Now()
+	if existing, ok := s.billingProfiles[profile.ID]; ok {
+		profile.CreatedAt = existing.CreatedAt
+	} else {
+		assignID(&profile.ID)
+		profile.CreatedAt = now
+	}
+	profile.UpdatedAt = now
+	s.billingProfiles[profile.ID] = clone(profile)
+	return nil
+}
+
+// CreateCoupon stores the coupon. Like the column default, a coupon created inactive comes
+// back active.
+func (s *MemoryStore) CreateCoupon(_ context.Context, coupon *models.Coupon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.coupons {
+		if existing.Code == coupon.Code {
+			return fmt.Errorf("coupon already exists with code %s", coupon.Code)
+		}
+	}
+
+	now := time.Now()
+	assignID(&coupon.ID)
+	coupon.Active = true
+	coupon.CreatedAt = now
+	coupon.UpdatedAt = now
+	s.coupons[coupon.ID] = clone(coupon)
+	return nil
+}
+
+func (s *MemoryStore) UpdateCoupon(_ context.Context, coupon *models.Coupon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.coupons[coupon.ID]; ok {
+		coupon.UpdatedAt = time.Now()
+		s.coupons[coupon.ID] = clone(coupon)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetCouponByID(_ context.Context, id uuid.UUID) (*models.Coupon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coupon, ok := s.coupons[id]
+	if !ok {
+		return nil, fmt.Errorf("coupon not found with id %s", id)
+	}
+	return clone(coupon), nil
+}
+
+func (s *MemoryStore) GetCouponByCode(_ context.Context, code string) (*models.Coupon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, coupon := range s.coupons {
+		if coupon.Code == code {
+			return clone(coupon), nil
+		}
+	}
+	return nil, fmt.Errorf("coupon not found with code %s", code)
+}
+
+func (s *MemoryStore) GetAllCoupons(_ context.Context) ([]*models.Coupon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coupons := filter(s.coupons, func(*models.Coupon) bool { return true })
+	slices.SortStableFunc(coupons, func(a, b *models.Coupon) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return coupons, nil
+}
+
+func (s *MemoryStore) GetCouponRedemption(_ context.Context, couponID, userID uuid.UUID) (*models.CouponRedemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, redemption := range s.couponRedemptions {
+		if redemption.CouponID == couponID && redemption.UserID == userID {
+			return clone(redemption), nil
+		}
+	}
+	return nil, fmt.Errorf("redemption not found")
+}
+
+func (s *MemoryStore) ReserveCoupon(_ context.Context, redemption *models.CouponRedemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemption.Status = models.CouponReserved
+	now := time.Now()
+	for id, existing := range s.couponRedemptions {
+		if existing.CouponID == redemption.CouponID && existing.Status == models.CouponReserved && existing.ExpiresAt != nil && !existing.ExpiresAt.After(now) {
+			s.releaseCouponRedemption(id)
+		}
+	}
+
+	existing := s.findCouponRedemption(redemption.CouponID, redemption.UserID)
+	if existing == nil {
+		return s.createCouponRedemption(redemption)
+	}
+	if existing.Status == models.CouponRedeemed {
+		return ErrCouponAlreadyRedeemed
+	}
+
+	redemption.ID = existing.ID
+	redemption.CreatedAt = existing.CreatedAt
+	s.couponRedemptions[existing.ID] = clone(redemption)
+	return nil
+}
+
+func (s *MemoryStore) RedeemCoupon(_ context.Context, redemption *models.CouponRedemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemption.Status = models.CouponRedeemed
+	existing := s.findCouponRedemption(redemption.CouponID, redemption.UserID)
+	if existing == nil {
+		return s.createCouponRedemption(redemption)
+	}
+	if existing.Status == models.CouponRedeemed {
+		if redemption.CheckoutSessionID != "" && existing.CheckoutSessionID == redemption.CheckoutSessionID {
+			redemption.ID = existing.ID
+			return nil
+		}
+		return ErrCouponAlreadyRedeemed
+	}
+
+	redemption.ID = existing.ID
+	redemption.ExpiresAt = nil
+	redemption.CreatedAt = existing.CreatedAt
+	s.couponRedemptions[existing.ID] = clone(redemption)
+	return nil
+}
+
+func (s *MemoryStore) ReleaseCoupon(_ context.Context, redemption *models.CouponRedemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.findCouponRedemption(redemption.CouponID, redemption.UserID)
+	if existing != nil && existing.Status == models.CouponReserved && existing.ExpiresAt != nil && redemption.ExpiresAt != nil && existing.ExpiresAt.Equal(*redemption.ExpiresAt) {
+		s.releaseCouponRedemption(existing.ID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) findCouponRedemption(couponID, userID uuid.UUID) *models.CouponRedemption {
+	for _, redemption := range s.couponRedemptions {
+		if redemption.CouponID == couponID && redemption.UserID == userID {
+			return redemption
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) createCouponRedemption(redemption *models.CouponRedemption) error {
+	coupon, ok := s.coupons[redemption.CouponID]
+	if !ok || (coupon.MaxRedemptions != 0 && coupon.TimesRedeemed >= coupon.MaxRedemptions) {
+		return ErrCouponExhausted
+	}
+
+	assignID(&redemption.ID)
+	redemption.CreatedAt = time.Now()
+	s.couponRedemptions[redemption.ID] = clone(redemption)
+
+	coupon.TimesRedeemed++
+	coupon.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) releaseCouponRedemption(id uuid.UUID) {
+	redemption := s.couponRedemptions[id]
+	delete(s.couponRedemptions, id)
+	if coupon, ok := s.coupons[redemption.CouponID]; ok && coupon.TimesRedeemed > 0 {
+		coupon.TimesRedeemed--
+		coupon.UpdatedAt = time.Now()
+	}
+}
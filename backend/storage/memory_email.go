@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) EnqueueEmails(_ context.Context, emails ...*models.OutboxEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueueEmails(emails)
+	return nil
+}
+
+func (s *MemoryStore) enqueueEmails(emails []*models.OutboxEmail) {
+	now := time.Now()
+	for _, email := range emails {
+		assignID(&email.ID)
+		email.Status = models.OutboxPending
+		if email.NextAttemptAt.IsZero() {
+			email.NextAttemptAt = now
+		}
+		email.CreatedAt = now
+		email.UpdatedAt = now
+		s.outbox[email.ID] = clone(email)
+	}
+}
+
+// ProcessOutbox delivers up to limit due emails. Like PostgresStore it claims them with a lease
+// first, so the store isn't locked while deliver runs and it can call back into the store.
+func (s *MemoryStore) ProcessOutbox(ctx context.Context, limit int, deliver func(context.Context, *models.OutboxEmail) error, retryAt func(attempts int) *time.Time) (int, error) {
+	s.mu.Lock()
+	now := time.Now()
+	due := filter(s.outbox, func(e *models.OutboxEmail) bool {
+		return (e.Status == models.OutboxPending || e.Status == models.OutboxSending) && !e.NextAttemptAt.After(now)
+	})
+	slices.SortStableFunc(due, func(a, b *models.OutboxEmail) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) })
+	due = take(due, limit)
+	for _, email := range due {
+		email.Status = models.OutboxSending
+		email.Attempts++
+		email.NextAttemptAt = now.Add(outboxLease)
+		email.UpdatedAt = now
+		s.outbox[email.ID] = clone(email)
+	}
+	s.mu.Unlock()
+
+	for _, email := range due {
+		settleOutboxEmail(email, deliver(ctx, email), retryAt)
+
+		s.mu.Lock()
+		if stored, ok := s.outbox[email.ID]; ok && stored.Status == models.OutboxSending && stored.Attempts == email.Attempts {
+			email.UpdatedAt = time.Now()
+			s.outbox[email.ID] = clone(email)
+		}
+		s.mu.Unlock()
+	}
+
+	return len(due), nil
+}
+
+func (s *MemoryStore) GetOutboxEmailsByStatus(_ context.Context, status string, limit int) ([]*models.OutboxEmail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	emails := filter(s.outbox, func(e *models.OutboxEmail) bool { return e.Status == status })
+	slices.SortStableFunc(emails, func(a, b *models.OutboxEmail) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return take(emails, limit), nil
+}
+
+func (s *MemoryStore) RetryOutboxEmail(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email, ok := s.outbox[id]
+	if !ok || email.Status != models.OutboxDead {
+		return fmt.Errorf("dead email not found with id %s", id)
+	}
+
+	now := time.Now()
+	email.Status = models.OutboxPending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.UpdatedAt = now
+	return nil
+}
+
+func (s *MemoryStore) CreateEmailEvent(_ context.Context, event *models.EmailEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.emailEvents {
+		if existing.ProviderEventID == event.ProviderEventID {
+			return false, nil
+		}
+	}
+
+	assignID(&event.ID)
+	event.CreatedAt = time.Now()
+	s.emailEvents[event.ID] = clone(event)
+	return true, nil
+}
+
+func (s *MemoryStore) SuppressEmail(_ context.Context, suppression *models.EmailSuppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suppression.Email = strings.ToLower(suppression.Email)
+	for _, existing := range s.emailSuppressions {
+		if existing.Email == suppression.Email {
+			return nil
+		}
+	}
+
+	assignID(&suppression.ID)
+	suppression.CreatedAt = time.Now()
+	s.emailSuppressions[suppression.ID] = clone(suppression)
+	return nil
+}
+
+func (s *MemoryStore) IsEmailSuppressed(_ context.Context, email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, suppression := range s.emailSuppressions {
+		if suppression.Email == strings.ToLower(email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) GetEmailSuppressions(_ context.Context) ([]*models.EmailSuppression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suppressions := filter(s.emailSuppressions, func(*models.EmailSuppression) bool { return true })
+	slices.SortStableFunc(suppressions, func(a, b *models.EmailSuppression) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return suppressions, nil
+}
+
+func (s *MemoryStore) DeleteEmailSuppression(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emailSuppressions[id]; !ok {
+		return fmt.Errorf("suppression not found with id %s", id)
+	}
+	delete(s.emailSuppressions, id)
+	return nil
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) EnqueueJob(_ context.Context, job *models.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueueJob(job)
+	return nil
+}
+
+func (s *MemoryStore) enqueueJob(job *models.Job) {
+	if job.ScheduledFor != nil {
+		for _, existing := range s.jobs {
+			if existing.Name == job.Name && existing.ScheduledFor != nil && existing.ScheduledFor.Equal(*job.ScheduledFor) {
+				return
+			}
+		}
+	}
+
+	now := time.Now()
+	assignID(&job.ID)
+	if job.Status == "" {
+		job.Status = models.JobPending
+	}
+	if job.Payload == "" {
+		job.Payload = "{}"
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	s.jobs[job.ID] = clone(job)
+}
+
+func (s *MemoryStore) ClaimJob(_ context.Context, workerID string, staleBefore time.Time) (*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	due := filter(s.jobs, func(j *models.Job) bool {
+		return (j.Status == models.JobPending && !j.RunAt.After(now)) ||
+			(j.Status == models.JobRunning && j.LockedAt != nil && j.LockedAt.Before(staleBefore))
+	})
+	if len(due) == 0 {
+		return nil, nil
+	}
+	slices.SortStableFunc(due, func(a, b *models.Job) int { return a.RunAt.Compare(b.RunAt) })
+
+	job := due[0]
+	job.Status = models.JobRunning
+	job.Attempts++
+	job.LockedBy = workerID
+	job.LockedAt = &now
+	job.UpdatedAt = now
+	s.jobs[job.ID] = clone(job)
+
+	return job, nil
+}
+
+func (s *MemoryStore) CompleteJob(_ context.Context, job *models.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.holdsJob(job) {
+		return fmt.Errorf("%w: job %s attempt %d", ErrJobLeaseLost, job.ID, job.Attempts)
+	}
+
+	now := time.Now()
+	job.Status = models.JobSucceeded
+	job.FinishedAt = &now
+	job.LastError = ""
+	job.UpdatedAt = now
+	s.jobs[job.ID] = clone(job)
+	return nil
+}
+
+func (s *MemoryStore) FailJob(_ context.Context, job *models.Job, retryAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.holdsJob(job) {
+		return fmt.Errorf("%w: job %s attempt %d", ErrJobLeaseLost, job.ID, job.Attempts)
+	}
+
+	now := time.Now()
+	job.LockedBy = ""
+	job.LockedAt = nil
+
+	if retryAt == nil {
+		job.Status = models.JobDead
+		job.FinishedAt = &now
+	} else {
+		job.Status = models.JobPending
+		job.RunAt = *retryAt
+	}
+
+	job.UpdatedAt = now
+	s.jobs[job.ID] = clone(job)
+	return nil
+}
+
+// holdsJob reports whether the job is still running the attempt it was claimed for.
+func (s *MemoryStore) holdsJob(job *models.Job) bool {
+	stored, ok := s.jobs[job.ID]
+	return ok && stored.Status == models.JobRunning && stored.LockedBy == job.LockedBy && stored.Attempts == job.Attempts
+}
+
+func (s *MemoryStore) GetJobs(_ context.Context, status string, limit int) ([]*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := filter(s.jobs, func(j *models.Job) bool { return status == "" || j.Status == status })
+	slices.SortStableFunc(jobs, func(a, b *models.Job) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return take(jobs, limit), nil
+}
+
+func (s *MemoryStore) GetJobCounts(_ context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[string]int64{}
+	for _, job := range s.jobs {
+		counts[job.Status]++
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) RetryJob(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Status != models.JobDead {
+		return fmt.Errorf("job not found with id %s", id)
+	}
+
+	now := time.Now()
+	job.Status = models.JobPending
+	job.Attempts = 0
+	job.RunAt = now
+	job.FinishedAt = nil
+	job.UpdatedAt = now
+	return nil
+}
+
+func (s *MemoryStore) DeleteFinishedJobs(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, job := range s.jobs {
+		if job.Status == models.JobSucceeded && job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			delete(s.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) SyncJobSchedule(_ context.Context, name string, spec string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.jobSchedules[name]
+	if !ok {
+		schedule = &models.JobSchedule{Name: name, NextRunAt: next}
+		s.jobSchedules[name] = schedule
+	} else if schedule.Spec != spec {
+		schedule.NextRunAt = next
+	}
+	schedule.Spec = spec
+	schedule.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) GetJobSchedules(_ context.Context) ([]*models.JobSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules := filter(s.jobSchedules, func(*models.JobSchedule) bool { return true })
+	slices.SortFunc(schedules, func(a, b *models.JobSchedule) int { return strings.Compare(a.Name, b.Name) })
+	return schedules, nil
+}
+
+func (s *MemoryStore) EnqueueScheduledJob(_ context.Context, schedule *models.JobSchedule, next time.Time, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := schedule.NextRunAt
+	s.enqueueJob(&models.Job{
+		Name:         schedule.Name,
+		ScheduledFor: &due,
+		RunAt:        time.Now(),
+		MaxAttempts:  maxAttempts,
+	})
+
+	schedule.LastRunAt = &due
+	schedule.NextRunAt = next
+	schedule.UpdatedAt = time.Now()
+	s.jobSchedules[schedule.Name] = clone(schedule)
+	return nil
+}
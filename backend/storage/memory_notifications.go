@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) GetNotificationPreferences(_ context.Context, userID uuid.UUID) ([]*models.NotificationPreference, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return filter(s.notificationPreferences, func(p *models.NotificationPreference) bool { return p.UserID == userID }), nil
+}
+
+func (s *MemoryStore) SaveNotificationPreferences(_ context.Context, userID uuid.UUID, email map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for category, enabled := range email {
+		preference := s.findNotificationPreference(userID, category)
+		if preference == nil {
+			preference = &models.NotificationPreference{ID: uuid.New(), UserID: userID, Category: category, CreatedAt: now}
+			s.notificationPreferences[preference.ID] = preference
+		}
+		preference.Email = enabled
+		preference.UpdatedAt = now
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsEmailOptedOut(_ context.Context, email string, category string) (bool, error) {
+	if c, ok := models.GetNotificationCategory(category); !ok || c.Required {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if !sameEmail(user.Email, email) {
+			continue
+		}
+		if preference := s.findNotificationPreference(user.ID, category); preference != nil && !preference.Email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) findNotificationPreference(userID uuid.UUID, category string) *models.NotificationPreference {
+	for _, preference := range s.notificationPreferences {
+		if preference.UserID == userID && preference.Category == category {
+			return preference
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateNotification(_ context.Context, notification *models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignID(&notification.ID)
+	notification.CreatedAt = time.Now()
+	s.notifications[notification.ID] = clone(notification)
+	for listener := range s.notificationListeners {
+		select {
+		case listener <- clone(notification):
+		default:
+			// a listener that's fallen behind misses it, like a dropped connection would
+		}
+	}
+	return nil
+}
+
+// ListenNotifications hears about notifications as they're created, even inside a transaction
+// that later rolls back, where Postgres would wait for the commit.
+func (s *MemoryStore) ListenNotifications(ctx context.Context, fn func(*models.Notification)) error {
+	listener := make(chan *models.Notification, 64)
+
+	s.mu.Lock()
+	s.notificationListeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.notificationListeners, listener)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case notification := <-listener:
+			fn(notification)
+		}
+	}
+}
+
+func (s *MemoryStore) GetNotifications(_ context.Context, userID uuid.UUID, limit int) ([]*models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifications := filter(s.notifications, func(n *models.Notification) bool { return n.UserID == userID })
+	slices.SortStableFunc(notifications, func(a, b *models.Notification) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return take(notifications, limit), nil
+}
+
+func (s *MemoryStore) CountUnreadNotifications(_ context.Context, userID uuid.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, notification := range s.notifications {
+		if notification.UserID == userID && notification.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) MarkNotificationRead(_ context.Context, userID uuid.UUID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification, ok := s.notifications[id]
+	if !ok || notification.UserID != userID {
+		return fmt.Errorf("notification not found with id %s", id)
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkAllNotificationsRead(_ context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, notification := range s.notifications {
+		if notification.UserID == userID && notification.ReadAt == nil {
+			notification.ReadAt = &now
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+// The organization methods live on memoryTx, which knows the tenant, and mirror the row-level
+// security policies of the Postgres tables. Outside WithTenant there's no tenant, so nothing is
+// visible.
+
+func (s *MemoryStore) CreateOrganization(ctx context.Context, org *models.Organization, owner uuid.UUID) error {
+	return memoryTx{MemoryStore: s}.CreateOrganization(ctx, org, owner)
+}
+
+func (s *MemoryStore) GetOrganization(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	return memoryTx{MemoryStore: s}.GetOrganization(ctx, id)
+}
+
+func (s *MemoryStore) GetOrganizations(ctx context.Context) ([]*models.Organization, error) {
+	return memoryTx{MemoryStore: s}.GetOrganizations(ctx)
+}
+
+func (s *MemoryStore) AddOrganizationMember(ctx context.Context, membership *models.OrganizationMembership) error {
+	return memoryTx{MemoryStore: s}.AddOrganizationMember(ctx, membership)
+}
+
+func (s *MemoryStore) GetOrganizationMembers(ctx context.Context, orgID uuid.UUID) ([]*models.OrganizationMembership, error) {
+	return memoryTx{MemoryStore: s}.GetOrganizationMembers(ctx, orgID)
+}
+
+func (tx memoryTx) CreateOrganization(_ context.Context, org *models.Organization, owner uuid.UUID) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	// the creator becomes the owner, the one membership added before anyone belongs to it
+	if !tx.isCurrentOrg(org.ID) || !tx.isCurrentUser(owner) {
+		return fmt.Errorf("%w: organization %s", ErrOutsideTenant, org.ID)
+	}
+	if _, ok := tx.organizations[org.ID]; ok {
+		return fmt.Errorf("organization already exists with id %s", org.ID)
+	}
+	if _, ok := tx.users[owner]; !ok {
+		return fmt.Errorf("user not found with id %s", owner)
+	}
+
+	now := time.Now()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+	tx.organizations[org.ID] = clone(org)
+
+	membership := &models.OrganizationMembership{ID: uuid.New(), OrganizationID: org.ID, UserID: owner, Role: models.OrganizationOwner, CreatedAt: now}
+	tx.organizationMemberships[membership.ID] = membership
+	return nil
+}
+
+func (tx memoryTx) GetOrganization(_ context.Context, id uuid.UUID) (*models.Organization, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	org, ok := tx.organizations[id]
+	if !ok || !tx.canSeeOrg(org) {
+		return nil, fmt.Errorf("%w with id %s", ErrOrganizationNotFound, id)
+	}
+	return clone(org), nil
+}
+
+func (tx memoryTx) GetOrganizations(_ context.Context) ([]*models.Organization, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	orgs := filter(tx.organizations, tx.canSeeOrg)
+	slices.SortStableFunc(orgs, func(a, b *models.Organization) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return orgs, nil
+}
+
+func (tx memoryTx) AddOrganizationMember(_ context.Context, membership *models.OrganizationMembership) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if !tx.isCurrentOrg(membership.OrganizationID) || !tx.isMember(membership.OrganizationID) {
+		return fmt.Errorf("%w: organization %s", ErrOutsideTenant, membership.OrganizationID)
+	}
+	if _, ok := tx.users[membership.UserID]; !ok {
+		return fmt.Errorf("user not found with id %s", membership.UserID)
+	}
+	for _, existing := range tx.organizationMemberships {
+		if existing.OrganizationID == membership.OrganizationID && existing.UserID == membership.UserID {
+			return fmt.Errorf("user %s is already a member of organization %s", membership.UserID, membership.OrganizationID)
+		}
+	}
+
+	assignID(&membership.ID)
+	membership.CreatedAt = time.Now()
+	tx.organizationMemberships[membership.ID] = clone(membership)
+	return nil
+}
+
+func (tx memoryTx) GetOrganizationMembers(_ context.Context, orgID uuid.UUID) ([]*models.OrganizationMembership, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	members := filter(tx.organizationMemberships, func(m *models.OrganizationMembership) bool {
+		return m.OrganizationID == orgID && tx.canSeeMembership(m)
+	})
+	slices.SortStableFunc(members, func(a, b *models.OrganizationMembership) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return members, nil
+}
+
+func (tx memoryTx) isCurrentOrg(id uuid.UUID) bool {
+	return tx.tenant != nil && tx.tenant.OrgID != uuid.Nil && tx.tenant.OrgID == id
+}
+
+func (tx memoryTx) isCurrentUser(id uuid.UUID) bool {
+	return tx.tenant != nil && tx.tenant.UserID != uuid.Nil && tx.tenant.UserID == id
+}
+
+// isMember reports whether the tenant's user belongs to the organization, which the current
+// organization is only trusted with.
+func (tx memoryTx) isMember(orgID uuid.UUID) bool {
+	for _, m := range tx.organizationMemberships {
+		if m.OrganizationID == orgID && tx.isCurrentUser(m.UserID) {
+			return true
+		}
+	}
+	return false
+}
+
+// canSeeOrg lets a user see the organizations they belong to.
+func (tx memoryTx) canSeeOrg(org *models.Organization) bool {
+	return tx.isMember(org.ID)
+}
+
+func (tx memoryTx) canSeeMembership(m *models.OrganizationMembership) bool {
+	return tx.isCurrentUser(m.UserID) || (tx.isCurrentOrg(m.OrganizationID) && tx.isMember(m.OrganizationID))
+}
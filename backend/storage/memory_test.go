@@ -0,0 +1,9 @@
+package storage
+
+import "testing"
+
+func TestMemoryStore(t *testing.T) {
+	runConformance(t, func(t *testing.T) Storage {
+		return NewMemoryStore()
+	})
+}
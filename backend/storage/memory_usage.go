@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+func (s *MemoryStore) RecordUsage(_ context.Context, userID uuid.UUID, metric string, quantity int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	start := at.UTC().Truncate(time.Hour)
+	for _, bucket := range s.usageBuckets {
+		if bucket.UserID == userID && bucket.Metric == metric && bucket.BucketStart.Equal(start) {
+			bucket.Quantity += quantity
+			bucket.UpdatedAt = now
+			return nil
+		}
+	}
+
+	bucket := &models.UsageBucket{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Metric:      metric,
+		BucketStart: start,
+		Quantity:    quantity,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	s.usageBuckets[bucket.ID] = bucket
+	return nil
+}
+
+func (s *MemoryStore) GetUsageTotal(ctx context.Context, userID uuid.UUID, metric string, from, to time.Time) (int64, error) {
+	buckets, _ := s.GetUsageBuckets(ctx, userID, metric, from, to)
+
+	var total int64
+	for _, bucket := range buckets {
+		total += bucket.Quantity
+	}
+	return total, nil
+}
+
+func (s *MemoryStore) GetUsageBuckets(_ context.Context, userID uuid.UUID, metric string, from, to time.Time) ([]*models.UsageBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buckets := filter(s.usageBuckets, func(b *models.UsageBucket) bool {
+		return b.UserID == userID && b.Metric == metric && !b.BucketStart.Before(from) && b.BucketStart.Before(to)
+	})
+	sortByBucketStart(buckets)
+	return buckets, nil
+}
+
+func (s *MemoryStore) GetUnreportedUsageBuckets(_ context.Context, limit int) ([]*models.UsageBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	billable := map[uuid.UUID]bool{}
+	for _, sub := range s.subscriptions {
+		if sub.StripeCustomerID != "" {
+			billable[sub.UserID] = true
+		}
+	}
+
+	buckets := filter(s.usageBuckets, func(b *models.UsageBucket) bool { return b.Quantity != b.ReportedQuantity && billable[b.UserID] })
+	sortByBucketStart(buckets)
+	return take(buckets, limit), nil
+}
+
+func (s *MemoryStore) MarkUsageBucketReported(_ context.Context, bucket *models.UsageBucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.usageBuckets[bucket.ID]; ok {
+		stored.ReportedQuantity = bucket.Quantity
+		stored.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateUsageWarning(_ context.Context, warning *models.UsageWarning) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.usageWarnings {
+		if existing.UserID == warning.UserID && existing.Metric == warning.Metric &&
+			existing.PeriodStart.Equal(warning.PeriodStart) && existing.Level == warning.Level {
+			return false, nil
+		}
+	}
+
+	assignID(&warning.ID)
+	warning.CreatedAt = time.Now()
+	s.usageWarnings[warning.ID] = clone(warning)
+	return true, nil
+}
+
+func sortByBucketStart(buckets []*models.UsageBucket) {
+	slices.SortStableFunc(buckets, func(a, b *models.UsageBucket) int { return a.BucketStart.Compare(b.BucketStart) })
+}
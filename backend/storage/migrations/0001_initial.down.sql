@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS "job_schedules";
+DROP TABLE IF EXISTS "jobs";
+DROP TABLE IF EXISTS "affiliate_payouts";
+DROP TABLE IF EXISTS "payout_batches";
+DROP TABLE IF EXISTS "commissions";
+DROP TABLE IF EXISTS "affiliate_referrals";
+DROP TABLE IF EXISTS "affiliate_clicks";
+DROP TABLE IF EXISTS "affiliates";
+DROP TABLE IF EXISTS "coupon_redemptions";
+DROP TABLE IF EXISTS "coupons";
+DROP TABLE IF EXISTS "billing_profiles";
+DROP TABLE IF EXISTS "invoices";
+DROP TABLE IF EXISTS "usage_warnings";
+DROP TABLE IF EXISTS "usage_buckets";
+DROP TABLE IF EXISTS "subscriptions";
+DROP TABLE IF EXISTS "audit_events";
+DROP TABLE IF EXISTS "notifications";
+DROP TABLE IF EXISTS "notification_preferences";
+DROP TABLE IF EXISTS "email_suppressions";
+DROP TABLE IF EXISTS "email_events";
+DROP TABLE IF EXISTS "outbox_emails";
+DROP TABLE IF EXISTS "api_tokens";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,336 @@
+-- Baseline schema. Matches what GORM AutoMigrate created, so it's a no-op on databases that
+-- predate versioned migrations.
+
+CREATE TABLE IF NOT EXISTS "users" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"first_name" text,
+	"last_name" text,
+	"email" text NOT NULL,
+	"updated_email" text,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	"hashed_password" text,
+	"updated_email_at" timestamptz DEFAULT null,
+	"updated_email_confirmed_at" timestamptz DEFAULT null,
+	"email_confirmed_at" timestamptz DEFAULT null,
+	"is_admin" boolean DEFAULT false,
+	"avatar_url" text DEFAULT null,
+	"avatar_thumbnail_url" text DEFAULT null,
+	"avatar_size" bigint DEFAULT 0,
+	"locale" text DEFAULT null,
+	"deleted_at" timestamptz DEFAULT null,
+	"restored_at" timestamptz DEFAULT null,
+	"security_version_changed_at" timestamptz DEFAULT null,
+	"confirmation_reminders" bigint DEFAULT 0,
+	PRIMARY KEY ("id"),
+	CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+
+CREATE TABLE IF NOT EXISTS "api_tokens" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"hashed_token" text,
+	"user_id" text,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	"name" text,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_tokens_hashed_token" ON "api_tokens" ("hashed_token");
+
+CREATE TABLE IF NOT EXISTS "outbox_emails" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"to" text NOT NULL,
+	"subject" text NOT NULL,
+	"html" text NOT NULL,
+	"text" text,
+	"headers" text,
+	"category" text,
+	"status" text NOT NULL DEFAULT 'pending',
+	"attempts" bigint DEFAULT 0,
+	"next_attempt_at" timestamptz NOT NULL,
+	"last_error" text,
+	"sent_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_outbox_due" ON "outbox_emails" ("status","next_attempt_at");
+
+CREATE TABLE IF NOT EXISTS "email_events" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"provider_event_id" text NOT NULL,
+	"provider_email_id" text,
+	"type" text NOT NULL,
+	"email" text NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_email_events_email" ON "email_events" ("email");
+CREATE INDEX IF NOT EXISTS "idx_email_events_provider_email_id" ON "email_events" ("provider_email_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_email_events_provider_event_id" ON "email_events" ("provider_event_id");
+
+CREATE TABLE IF NOT EXISTS "email_suppressions" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"email" text NOT NULL,
+	"reason" text NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_email_suppressions_email" ON "email_suppressions" ("email");
+
+CREATE TABLE IF NOT EXISTS "notification_preferences" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"category" text NOT NULL,
+	"email" boolean NOT NULL,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_notification_preference" ON "notification_preferences" ("user_id","category");
+
+CREATE TABLE IF NOT EXISTS "notifications" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"type" text NOT NULL,
+	"title" text NOT NULL,
+	"body" text,
+	"link" text,
+	"read_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_notification_user_created" ON "notifications" ("user_id","created_at");
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"action" text NOT NULL,
+	"user_id" uuid DEFAULT null,
+	"metadata" text,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_user_id" ON "audit_events" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events" ("action");
+
+CREATE TABLE IF NOT EXISTS "subscriptions" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"plan" text NOT NULL DEFAULT 'free',
+	"status" text,
+	"stripe_customer_id" text DEFAULT null,
+	"stripe_subscription_id" text DEFAULT null,
+	"trial_ends_at" timestamptz DEFAULT null,
+	"trial_reminder_sent_at" timestamptz DEFAULT null,
+	"payment_failed_at" timestamptz DEFAULT null,
+	"failed_invoice_id" text DEFAULT null,
+	"grace_ends_at" timestamptz DEFAULT null,
+	"dunning_step" bigint DEFAULT 0,
+	"next_dunning_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_subscriptions_stripe_customer_id" ON "subscriptions" ("stripe_customer_id");
+CREATE INDEX IF NOT EXISTS "idx_subscriptions_status" ON "subscriptions" ("status");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_subscriptions_user_id" ON "subscriptions" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "usage_buckets" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"metric" text NOT NULL,
+	"bucket_start" timestamptz NOT NULL,
+	"quantity" bigint NOT NULL DEFAULT 0,
+	"reported_quantity" bigint NOT NULL DEFAULT 0,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_usage_bucket" ON "usage_buckets" ("user_id","metric","bucket_start");
+
+CREATE TABLE IF NOT EXISTS "usage_warnings" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"metric" text NOT NULL,
+	"period_start" timestamptz NOT NULL,
+	"level" text NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_usage_warning" ON "usage_warnings" ("user_id","metric","period_start","level");
+
+CREATE TABLE IF NOT EXISTS "invoices" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"stripe_invoice_id" text NOT NULL,
+	"number" text,
+	"status" text,
+	"description" text,
+	"currency" text,
+	"subtotal" bigint DEFAULT 0,
+	"discount" bigint DEFAULT 0,
+	"tax" bigint DEFAULT 0,
+	"total" bigint DEFAULT 0,
+	"amount_paid" bigint DEFAULT 0,
+	"period_start" timestamptz DEFAULT null,
+	"period_end" timestamptz DEFAULT null,
+	"paid_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_invoices_stripe_invoice_id" ON "invoices" ("stripe_invoice_id");
+CREATE INDEX IF NOT EXISTS "idx_invoices_user_id" ON "invoices" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "billing_profiles" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"name" text,
+	"company_name" text,
+	"address_line1" text,
+	"address_line2" text,
+	"city" text,
+	"state" text,
+	"postal_code" text,
+	"country" text,
+	"tax_id" text,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_billing_profiles_user_id" ON "billing_profiles" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "coupons" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"code" text NOT NULL,
+	"percent_off" bigint DEFAULT 0,
+	"amount_off" bigint DEFAULT 0,
+	"currency" text,
+	"duration" text NOT NULL,
+	"duration_in_months" bigint DEFAULT 0,
+	"max_redemptions" bigint DEFAULT 0,
+	"times_redeemed" bigint DEFAULT 0,
+	"expires_at" timestamptz DEFAULT null,
+	"allowed_plans" text[],
+	"active" boolean DEFAULT true,
+	"stripe_coupon_id" text DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_coupons_code" ON "coupons" ("code");
+
+CREATE TABLE IF NOT EXISTS "coupon_redemptions" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"coupon_id" uuid NOT NULL,
+	"user_id" uuid NOT NULL,
+	"plan" text,
+	"checkout_session_id" text,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_coupon_redemption" ON "coupon_redemptions" ("coupon_id","user_id");
+
+CREATE TABLE IF NOT EXISTS "affiliates" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"user_id" uuid NOT NULL,
+	"code" text NOT NULL,
+	"commission_percent" bigint NOT NULL,
+	"status" text NOT NULL DEFAULT 'active',
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_affiliates_code" ON "affiliates" ("code");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_affiliates_user_id" ON "affiliates" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "affiliate_clicks" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"affiliate_id" uuid NOT NULL,
+	"landing_path" text,
+	"referrer" text,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_affiliate_clicks_affiliate_id" ON "affiliate_clicks" ("affiliate_id");
+
+CREATE TABLE IF NOT EXISTS "affiliate_referrals" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"affiliate_id" uuid NOT NULL,
+	"user_id" uuid NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_affiliate_referrals_user_id" ON "affiliate_referrals" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_affiliate_referrals_affiliate_id" ON "affiliate_referrals" ("affiliate_id");
+
+CREATE TABLE IF NOT EXISTS "commissions" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"affiliate_id" uuid NOT NULL,
+	"user_id" uuid NOT NULL,
+	"stripe_invoice_id" text NOT NULL,
+	"amount" bigint NOT NULL,
+	"currency" text NOT NULL,
+	"status" text NOT NULL DEFAULT 'pending',
+	"payout_batch_id" uuid DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_commissions_payout_batch_id" ON "commissions" ("payout_batch_id");
+CREATE INDEX IF NOT EXISTS "idx_commissions_status" ON "commissions" ("status");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_commissions_stripe_invoice_id" ON "commissions" ("stripe_invoice_id");
+CREATE INDEX IF NOT EXISTS "idx_commissions_affiliate_id" ON "commissions" ("affiliate_id");
+
+CREATE TABLE IF NOT EXISTS "payout_batches" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"period_start" timestamptz NOT NULL,
+	"period_end" timestamptz NOT NULL,
+	"status" text NOT NULL DEFAULT 'open',
+	"paid_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_payout_batches_period_start" ON "payout_batches" ("period_start");
+
+CREATE TABLE IF NOT EXISTS "affiliate_payouts" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"payout_batch_id" uuid NOT NULL,
+	"affiliate_id" uuid NOT NULL,
+	"currency" text NOT NULL,
+	"amount" bigint NOT NULL,
+	"commission_count" bigint NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_affiliate_payout" ON "affiliate_payouts" ("payout_batch_id","affiliate_id","currency");
+
+CREATE TABLE IF NOT EXISTS "jobs" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"name" text NOT NULL,
+	"payload" jsonb NOT NULL DEFAULT '{}',
+	"scheduled_for" timestamptz DEFAULT null,
+	"status" text NOT NULL DEFAULT 'pending',
+	"run_at" timestamptz NOT NULL,
+	"attempts" bigint NOT NULL DEFAULT 0,
+	"max_attempts" bigint NOT NULL,
+	"locked_by" text,
+	"locked_at" timestamptz DEFAULT null,
+	"last_error" text,
+	"finished_at" timestamptz DEFAULT null,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_job_status_run_at" ON "jobs" ("status","run_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_job_scheduled_run" ON "jobs" ("name","scheduled_for");
+
+CREATE TABLE IF NOT EXISTS "job_schedules" (
+	"name" text,
+	"spec" text NOT NULL,
+	"next_run_at" timestamptz NOT NULL,
+	"last_run_at" timestamptz DEFAULT null,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("name")
+);
@@ -0,0 +1,3 @@
+-- Version is bumped on every write to a user so concurrent updates can't silently overwrite each
+-- other.
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- Values already encrypted stay encrypted, the application still reads them while PII_KEYS is set.
+DROP INDEX IF EXISTS "idx_users_email_index";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "email_index";
+ALTER TABLE "users" ADD CONSTRAINT "uni_users_email" UNIQUE ("email");
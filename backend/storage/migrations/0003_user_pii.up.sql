@@ -0,0 +1,6 @@
+-- Names and emails are encrypted by the application, so the email's uniqueness moves to a keyed
+-- blind index. Existing rows get their index, and their values encrypted, from the
+-- users.reencrypt job; until then they're matched on the plaintext.
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "email_index" text;
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "uni_users_email";
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email_index" ON "users" ("email_index");
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "organization_memberships";
+DROP TABLE IF EXISTS "organizations";
+DROP FUNCTION IF EXISTS app_current_user();
+DROP FUNCTION IF EXISTS app_current_org();
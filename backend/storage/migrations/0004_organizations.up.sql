@@ -0,0 +1,50 @@
+-- Organizations are tenants. Tenant tables are behind row-level security keyed on the
+-- app.current_org and app.current_user settings, which the store sets for one transaction in
+-- WithTenant; without them a query sees no rows and can't write any. FORCE applies the policies to
+-- the table owner too, but superusers and BYPASSRLS roles skip them, so the application must not
+-- connect as one.
+
+CREATE OR REPLACE FUNCTION app_current_org() RETURNS uuid LANGUAGE sql STABLE AS $$
+	SELECT NULLIF(current_setting('app.current_org', true), '')::uuid
+$$;
+
+CREATE OR REPLACE FUNCTION app_current_user() RETURNS uuid LANGUAGE sql STABLE AS $$
+	SELECT NULLIF(current_setting('app.current_user', true), '')::uuid
+$$;
+
+CREATE TABLE IF NOT EXISTS "organizations" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"name" text NOT NULL,
+	"created_at" timestamptz,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "organization_memberships" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"organization_id" uuid NOT NULL REFERENCES "organizations" ("id") ON DELETE CASCADE,
+	"user_id" uuid NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+	"role" text NOT NULL,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organization_memberships_member" ON "organization_memberships" ("organization_id", "user_id");
+CREATE INDEX IF NOT EXISTS "idx_organization_memberships_user_id" ON "organization_memberships" ("user_id");
+
+ALTER TABLE "organizations" ENABLE ROW LEVEL SECURITY;
+ALTER TABLE "organizations" FORCE ROW LEVEL SECURITY;
+ALTER TABLE "organization_memberships" ENABLE ROW LEVEL SECURITY;
+ALTER TABLE "organization_memberships" FORCE ROW LEVEL SECURITY;
+
+-- a user can see the organizations they belong to, to pick one, but only change the current one
+CREATE POLICY "organizations_read" ON "organizations" FOR SELECT
+	USING ("id" = app_current_org() OR "id" IN (SELECT "organization_id" FROM "organization_memberships" WHERE "user_id" = app_current_user()));
+CREATE POLICY "organizations_write" ON "organizations"
+	USING ("id" = app_current_org())
+	WITH CHECK ("id" = app_current_org());
+
+CREATE POLICY "organization_memberships_read" ON "organization_memberships" FOR SELECT
+	USING ("organization_id" = app_current_org() OR "user_id" = app_current_user());
+CREATE POLICY "organization_memberships_write" ON "organization_memberships"
+	USING ("organization_id" = app_current_org())
+	WITH CHECK ("organization_id" = app_current_org());
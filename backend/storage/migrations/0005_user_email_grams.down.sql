@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "idx_users_email_grams";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "email_grams";
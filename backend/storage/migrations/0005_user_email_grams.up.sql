@@ -0,0 +1,4 @@
+-- Keyed grams of the email, so the admin listing can search encrypted emails by part of the
+-- address. Existing rows get theirs from the users.reencrypt job.
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "email_grams" text[];
+CREATE INDEX IF NOT EXISTS "idx_users_email_grams" ON "users" USING GIN ("email_grams");
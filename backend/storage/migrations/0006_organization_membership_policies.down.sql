@@ -0,0 +1,24 @@
+DROP POLICY IF EXISTS "organizations_read" ON "organizations";
+DROP POLICY IF EXISTS "organizations_create" ON "organizations";
+DROP POLICY IF EXISTS "organizations_update" ON "organizations";
+DROP POLICY IF EXISTS "organizations_delete" ON "organizations";
+DROP POLICY IF EXISTS "organization_memberships_read" ON "organization_memberships";
+DROP POLICY IF EXISTS "organization_memberships_create" ON "organization_memberships";
+DROP POLICY IF EXISTS "organization_memberships_update" ON "organization_memberships";
+DROP POLICY IF EXISTS "organization_memberships_delete" ON "organization_memberships";
+
+CREATE POLICY "organizations_read" ON "organizations" FOR SELECT
+	USING ("id" = app_current_org() OR "id" IN (SELECT "organization_id" FROM "organization_memberships" WHERE "user_id" = app_current_user()));
+CREATE POLICY "organizations_write" ON "organizations"
+	USING ("id" = app_current_org())
+	WITH CHECK ("id" = app_current_org());
+CREATE POLICY "organization_memberships_read" ON "organization_memberships" FOR SELECT
+	USING ("organization_id" = app_current_org() OR "user_id" = app_current_user());
+CREATE POLICY "organization_memberships_write" ON "organization_memberships"
+	USING ("organization_id" = app_current_org())
+	WITH CHECK ("organization_id" = app_current_org());
+
+DROP FUNCTION IF EXISTS app_is_member(uuid);
+DROP TRIGGER IF EXISTS "organizations_stamp" ON "organizations";
+DROP FUNCTION IF EXISTS app_stamp_organization();
+ALTER TABLE "organizations" DROP COLUMN IF EXISTS "created_by";
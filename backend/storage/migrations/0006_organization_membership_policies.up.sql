@@ -0,0 +1,66 @@
+-- The tenant policies used to trust app.current_org on its own, so a handler that passed an
+-- organization the user doesn't belong to to WithTenant could read and write it. Now the current
+-- user must be a member of the current organization as well. Creating an organization is the one
+-- write that happens before anyone is a member, so a user may add themselves as the owner of an
+-- organization they created in the same transaction.
+
+ALTER TABLE "organizations" ADD COLUMN IF NOT EXISTS "created_by" uuid;
+
+CREATE OR REPLACE FUNCTION app_stamp_organization() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	NEW.created_by := app_current_user();
+	NEW.created_at := now();
+	RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS "organizations_stamp" ON "organizations";
+CREATE TRIGGER "organizations_stamp" BEFORE INSERT ON "organizations"
+	FOR EACH ROW EXECUTE FUNCTION app_stamp_organization();
+
+-- app_is_member reports whether the current user belongs to org. Its lookup is filtered by the
+-- memberships policies, which call back in here, so the nested calls answer false and the lookup
+-- only sees the user's own rows. Setting app.checking_membership from outside can only hide rows.
+CREATE OR REPLACE FUNCTION app_is_member(org uuid) RETURNS boolean LANGUAGE plpgsql AS $$
+DECLARE
+	member boolean;
+BEGIN
+	IF current_setting('app.checking_membership', true) = 'on' THEN
+		RETURN false;
+	END IF;
+	PERFORM set_config('app.checking_membership', 'on', true);
+	SELECT EXISTS (SELECT 1 FROM "organization_memberships" m WHERE m.organization_id = org AND m.user_id = app_current_user()) INTO member;
+	PERFORM set_config('app.checking_membership', 'off', true);
+	RETURN member;
+END
+$$;
+
+DROP POLICY IF EXISTS "organizations_read" ON "organizations";
+DROP POLICY IF EXISTS "organizations_write" ON "organizations";
+DROP POLICY IF EXISTS "organization_memberships_read" ON "organization_memberships";
+DROP POLICY IF EXISTS "organization_memberships_write" ON "organization_memberships";
+
+-- a user can see the organizations they belong to, to pick one, but only change the current one
+CREATE POLICY "organizations_read" ON "organizations" FOR SELECT
+	USING (app_is_member("id") OR ("id" = app_current_org() AND "created_by" = app_current_user() AND "created_at" = now()));
+CREATE POLICY "organizations_create" ON "organizations" FOR INSERT
+	WITH CHECK ("id" = app_current_org() AND "created_by" = app_current_user());
+CREATE POLICY "organizations_update" ON "organizations" FOR UPDATE
+	USING ("id" = app_current_org() AND app_is_member("id"))
+	WITH CHECK ("id" = app_current_org() AND app_is_member("id"));
+CREATE POLICY "organizations_delete" ON "organizations" FOR DELETE
+	USING ("id" = app_current_org() AND app_is_member("id"));
+
+CREATE POLICY "organization_memberships_read" ON "organization_memberships" FOR SELECT
+	USING ("user_id" = app_current_user() OR ("organization_id" = app_current_org() AND app_is_member("organization_id")));
+CREATE POLICY "organization_memberships_create" ON "organization_memberships" FOR INSERT
+	WITH CHECK ("organization_id" = app_current_org() AND (
+		app_is_member("organization_id") OR (
+			"user_id" = app_current_user() AND "role" = 'owner' AND EXISTS (
+				SELECT 1 FROM "organizations" o
+				WHERE o.id = "organization_id" AND o.created_by = app_current_user() AND o.created_at = now()))));
+CREATE POLICY "organization_memberships_update" ON "organization_memberships" FOR UPDATE
+	USING ("organization_id" = app_current_org() AND app_is_member("organization_id"))
+	WITH CHECK ("organization_id" = app_current_org() AND app_is_member("organization_id"));
+CREATE POLICY "organization_memberships_delete" ON "organization_memberships" FOR DELETE
+	USING ("organization_id" = app_current_org() AND app_is_member("organization_id"));
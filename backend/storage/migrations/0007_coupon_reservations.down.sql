@@ -0,0 +1,4 @@
+DELETE FROM "coupon_redemptions" WHERE "status" = 'reserved';
+UPDATE "coupons" SET "times_redeemed" = (SELECT count(*) FROM "coupon_redemptions" r WHERE r.coupon_id = "coupons"."id");
+ALTER TABLE "coupon_redemptions" DROP COLUMN IF EXISTS "expires_at";
+ALTER TABLE "coupon_redemptions" DROP COLUMN IF EXISTS "status";
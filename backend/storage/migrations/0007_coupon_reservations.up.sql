@@ -0,0 +1,4 @@
+-- A checkout reserves its coupon redemption until the checkout session expires, so the coupon's
+-- limit holds before the payment completes. Existing rows were all redeemed.
+ALTER TABLE "coupon_redemptions" ADD COLUMN IF NOT EXISTS "status" text NOT NULL DEFAULT 'redeemed';
+ALTER TABLE "coupon_redemptions" ADD COLUMN IF NOT EXISTS "expires_at" timestamptz;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "commission_adjustments";
+ALTER TABLE "commissions" DROP COLUMN IF EXISTS "reversed_amount";
@@ -0,0 +1,17 @@
+-- Partial refunds reverse part of a commission. Before it's batched the reversal is kept on the
+-- commission, after that it becomes an adjustment deducted from the affiliate's next payout.
+ALTER TABLE "commissions" ADD COLUMN IF NOT EXISTS "reversed_amount" bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "commission_adjustments" (
+	"id" uuid DEFAULT gen_random_uuid(),
+	"commission_id" uuid NOT NULL,
+	"affiliate_id" uuid NOT NULL,
+	"amount" bigint NOT NULL,
+	"currency" text NOT NULL,
+	"payout_batch_id" uuid DEFAULT null,
+	"created_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_commission_adjustments_commission_id" ON "commission_adjustments" ("commission_id");
+CREATE INDEX IF NOT EXISTS "idx_commission_adjustments_affiliate_id" ON "commission_adjustments" ("affiliate_id");
+CREATE INDEX IF NOT EXISTS "idx_commission_adjustments_payout_batch_id" ON "commission_adjustments" ("payout_batch_id");
@@ -0,0 +1,3 @@
+-- The older code searches one and two character terms by grams this version no longer writes, so
+-- have the users.reencrypt job rebuild them.
+UPDATE "users" SET "email_grams" = NULL WHERE "email_grams" IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Search grams are three characters now. Dropping the old ones, which also held one and two
+-- character grams, leaves the users.reencrypt job to rebuild them; until it reaches a row, the
+-- admin listing's email search doesn't find it.
+UPDATE "users" SET "email_grams" = NULL WHERE "email_grams" IS NOT NULL;
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+)
+
+// outboxLease is how long a claimed email is left to its worker. If the worker dies before
+// recording the result, the email is claimed again once the lease runs out; the provider is
+// given the email's id as an idempotency key so that retry isn't sent twice.
+const outboxLease = 5 * time.Minute
+
+// settleOutboxEmail applies the result of a delivery attempt to a claimed email.
+func settleOutboxEmail(email *models.OutboxEmail, err error, retryAt func(attempts int) *time.Time) {
+	if err == nil {
+		now := time.Now()
+		email.Status = models.OutboxSent
+		email.SentAt = &now
+		email.LastError = ""
+		return
+	}
+
+	email.LastError = err.Error()
+	if errors.Is(err, ErrSkipped) {
+		email.Status = models.OutboxSkipped
+	} else if errors.Is(err, ErrUndeliverable) {
+		email.Status = models.OutboxDead
+	} else if next := retryAt(email.Attempts); next != nil {
+		email.Status = models.OutboxPending
+		email.NextAttemptAt = *next
+	} else {
+		email.Status = models.OutboxDead
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/google/uuid"
+)
+
+// commissionReversal returns how much more of a commission to reverse now that refunded of the
+// charged amount has been refunded. Stripe reports the refunded total, so reversed is what earlier
+// refunds of the same charge already took back.
+func commissionReversal(commission *models.Commission, reversed, refunded, charged int64) int64 {
+	owed := commission.Amount
+	if charged > 0 && refunded < charged {
+		owed = commission.Amount * refunded / charged
+	}
+	return max(owed-reversed, 0)
+}
+
+// payoutBatchContents totals the approved commissions and outstanding adjustments into one payout
+// per affiliate and currency. An affiliate whose adjustments outweigh what they've earned is left
+// out, so the whole balance carries into the next batch.
+func payoutBatchContents(batchID uuid.UUID, commissions []*models.Commission, adjustments []*models.CommissionAdjustment, now time.Time) ([]*models.AffiliatePayout, []*models.Commission, []*models.CommissionAdjustment) {
+	type payoutKey struct {
+		affiliateID uuid.UUID
+		currency    string
+	}
+	type group struct {
+		payout      *models.AffiliatePayout
+		commissions []*models.Commission
+		adjustments []*models.CommissionAdjustment
+	}
+	groups := map[payoutKey]*group{}
+	var keys []payoutKey
+
+	groupFor := func(affiliateID uuid.UUID, currency string) *group {
+		key := payoutKey{affiliateID, currency}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{payout: &models.AffiliatePayout{
+				ID:            uuid.New(),
+				PayoutBatchID: batchID,
+				AffiliateID:   affiliateID,
+				Currency:      currency,
+				CreatedAt:     now,
+			}}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		return g
+	}
+
+	for _, commission := range commissions {
+		g := groupFor(commission.AffiliateID, commission.Currency)
+		g.payout.Amount += commission.Amount - commission.ReversedAmount
+		g.payout.CommissionCount++
+		g.commissions = append(g.commissions, commission)
+	}
+	for _, adjustment := range adjustments {
+		g := groupFor(adjustment.AffiliateID, adjustment.Currency)
+		g.payout.Amount += adjustment.Amount
+		g.adjustments = append(g.adjustments, adjustment)
+	}
+
+	var payouts []*models.AffiliatePayout
+	var batchedCommissions []*models.Commission
+	var batchedAdjustments []*models.CommissionAdjustment
+	for _, key := range keys {
+		g := groups[key]
+		if len(g.commissions) == 0 || g.payout.Amount < 0 {
+			continue
+		}
+		payouts = append(payouts, g.payout)
+		batchedCommissions = append(batchedCommissions, g.commissions...)
+		batchedAdjustments = append(batchedAdjustments, g.adjustments...)
+	}
+
+	return payouts, batchedCommissions, batchedAdjustments
+}
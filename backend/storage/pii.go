@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/colecaccamise/go-backend/pii"
+	"gorm.io/gorm/schema"
+)
+
+// piiSerializer encrypts string fields tagged `gorm:"serializer:pii"` on the way into the
+// database and decrypts them on the way out.
+type piiSerializer struct {
+	keys *pii.Keyring
+}
+
+func (p piiSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("can't decrypt %s from %T", field.Name, dbValue)
+	}
+
+	plaintext, err := p.keys.Decrypt(value)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", field.Name, err)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (p piiSerializer) Value(_ context.Context, field *schema.Field, _ reflect.Value, fieldValue any) (any, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("can't encrypt %s of type %T", field.Name, fieldValue)
+	}
+	return p.keys.Encrypt(plaintext)
+}
@@ -0,0 +1,1825 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/pii"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// PostgresStore writes to the primary and, when a replica is configured, sends the read-only
+// queries that can tolerate replication lag to it. Inside WithTx everything goes to the primary.
+type PostgresStore struct {
+	db      *gorm.DB
+	replica *gorm.DB
+	keys    *pii.Keyring
+}
+
+var _ Storage = (*PostgresStore)(nil)
+
+// NewPostgresStore connects using PostgresConfigFromEnv.
+func NewPostgresStore() (*PostgresStore, error) {
+	config, err := PostgresConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := ConnectPostgresStore(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Connected to database!")
+	return store, nil
+}
+
+// ConnectPostgresStore opens the store, retrying with exponential backoff while the database
+// isn't ready.
+func ConnectPostgresStore(ctx context.Context, config PostgresConfig) (*PostgresStore, error) {
+	attempts := max(config.ConnectAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		store, err := OpenPostgresStore(config)
+		if err == nil {
+			return store, nil
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("failed to connect to database after %d attempts: %w", attempts, err)
+		}
+
+		fmt.Printf("Waiting for database to be ready... (%d/%d): %v\n", attempt, attempts, err)
+		if err := config.connectBackoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// OpenPostgresStore connects to the primary and the replica, if there is one, without retrying.
+func OpenPostgresStore(config PostgresConfig) (*PostgresStore, error) {
+	db, err := openPostgres(config.DSN, config)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &PostgresStore{db: db, keys: config.Keys}
+	if config.ReplicaDSN != "" {
+		store.replica, err = openPostgres(config.ReplicaDSN, config)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("connecting to the replica: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+func openPostgres(dsn string, config PostgresConfig) (*gorm.DB, error) {
+	// serializers are looked up by name, so there's one keyring per process
+	schema.RegisterSerializer("pii", piiSerializer{keys: config.Keys})
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// zero leaves database/sql's defaults
+	pool.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	pool.SetConnMaxLifetime(config.ConnMaxLifetime)
+	pool.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	if config.StatementTimeout > 0 {
+		if err := registerStatementTimeout(db, config.StatementTimeout); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// Close closes the connection pools.
+func (s *PostgresStore) Close() error {
+	var errs []error
+	for _, db := range []*gorm.DB{s.db, s.replica} {
+		if db == nil {
+			continue
+		}
+		if pool, err := db.DB(); err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, pool.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reader is where read-only queries that can tolerate replication lag go, like admin listings.
+// Anything auth depends on or that feeds a write reads from the primary.
+func (s *PostgresStore) reader(ctx context.Context) *gorm.DB {
+	if s.replica != nil {
+		return s.replica.WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+func (s *PostgresStore) WithTx(ctx context.Context, fn func(Storage) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&PostgresStore{db: tx, keys: s.keys})
+	})
+}
+
+// Init applies any pending migrations.
+func (s *PostgresStore) Init() error {
+	ctx := context.Background()
+	applied, err := s.MigrateUp(ctx)
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	// a role that skips the tenant policies would serve every tenant's rows to every other
+	bypass, err := s.BypassesRowLevelSecurity(ctx)
+	if err != nil {
+		return fmt.Errorf("checking the database role: %w", err)
+	}
+	if bypass {
+		if os.Getenv("ENVIRONMENT") == "production" {
+			return errors.New("the database role bypasses row-level security, connect as one without SUPERUSER or BYPASSRLS")
+		}
+		fmt.Println("Warning: the database role bypasses row-level security, so tenants aren't isolated")
+	}
+	return nil
+}
+
+// BypassesRowLevelSecurity reports whether the store's role skips row-level security policies.
+func (s *PostgresStore) BypassesRowLevelSecurity(ctx context.Context) (bool, error) {
+	var bypass bool
+	err := s.db.WithContext(ctx).Raw("SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user").Scan(&bypass).Error
+	return bypass, err
+}
+
+// CreateUser creates the user and queues any emails in the same transaction.
+func (s *PostgresStore) CreateUser(ctx context.Context, user *models.User, emails ...*models.OutboxEmail) error {
+	user.EmailIndex = s.keys.BlindIndex(user.Email)
+	user.EmailGrams = s.keys.SearchGrams(user.Email)
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return enqueueEmails(tx, emails)
+	})
+}
+
+// UpdateUser updates the user and queues any emails in the same transaction.
+func (s *PostgresStore) UpdateUser(ctx context.Context, user *models.User, emails ...*models.OutboxEmail) (err error) {
+	version := user.Version
+	user.Version++
+	defer func() {
+		if err != nil {
+			user.Version = version
+		}
+	}()
+
+	user.EmailIndex = s.keys.BlindIndex(user.Email)
+	user.EmailGrams = s.keys.SearchGrams(user.Email)
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(user).Where("version = ?", version).Select("*").Updates(user) // explicitly tell gorm to update with zero values
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrConflict
+		}
+		return enqueueEmails(tx, emails)
+	})
+}
+
+// GetUserByID reads from the primary: auth checks the user's security version and deleted state
+// and updates start from what it returns, so neither can be behind.
+func (s *PostgresStore) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return getUserByID(s.db.WithContext(ctx), id)
+}
+
+func (s *PostgresStore) GetUserByIDFromReplica(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return getUserByID(s.reader(ctx), id)
+}
+
+func getUserByID(db *gorm.DB, id uuid.UUID) (*models.User, error) {
+	var user models.User
+	result := db.First(&user, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user not found with id %s", id)
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	match, args := s.emailMatch("users", email)
+	result := s.db.WithContext(ctx).Where(match, args...).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user not found with email %s", email)
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// emailMatch matches users on the email's blind index. Rows written before encryption have no
+// index until ReencryptUsers reaches them, so those are matched on the plaintext.
+func (s *PostgresStore) emailMatch(table string, email string) (string, []any) {
+	return fmt.Sprintf("(%[1]s.email_index = ? OR (%[1]s.email_index IS NULL AND LOWER(%[1]s.email) = LOWER(?)))", table), []any{s.keys.BlindIndex(email), email}
+}
+
+// piiColumns are the users columns sealed by the pii serializer.
+var piiColumns = []string{"email", "first_name", "last_name", "updated_email"}
+
+// ReencryptUsers reseals up to limit users whose personal data is still in plaintext or sealed
+// with a KEK other than the primary, filling in missing blind indexes, and returns how many it
+// updated. It writes the columns directly, so versions and updated_at are left alone.
+func (s *PostgresStore) ReencryptUsers(ctx context.Context, limit int) (int, error) {
+	stale := []string{"email_index IS NULL", "email_grams IS NULL"}
+	var args []any
+	if current := s.keys.Current(); current != "" {
+		for _, column := range piiColumns {
+			stale = append(stale, fmt.Sprintf("(COALESCE(%[1]s, '') <> '' AND %[1]s NOT LIKE ?)", column))
+			args = append(args, current+"%")
+		}
+	}
+
+	var rows []map[string]any
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("users").
+			Select("id, "+strings.Join(piiColumns, ", ")).
+			Where(strings.Join(stale, " OR "), args...).
+			Order("id").
+			Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Find(&rows).Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			updates := map[string]any{}
+			for _, column := range piiColumns {
+				value, _ := row[column].(string)
+				sealed, changed, err := s.keys.Reseal(value)
+				if err != nil {
+					return fmt.Errorf("resealing %s of user %v: %w", column, row["id"], err)
+				}
+				if changed {
+					updates[column] = sealed
+				}
+			}
+
+			email, _ := row["email"].(string)
+			plaintext, err := s.keys.Decrypt(email)
+			if err != nil {
+				return fmt.Errorf("decrypting email of user %v: %w", row["id"], err)
+			}
+			updates["email_index"] = s.keys.BlindIndex(plaintext)
+			updates["email_grams"] = pq.StringArray(s.keys.SearchGrams(plaintext))
+
+			if err := tx.Table("users").Where("id = ?", row["id"]).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return len(rows), err
+}
+
+// ListUsers pages through users with keyset pagination on created_at and id, so a page costs the
+// same however deep it is. Emails are encrypted, so the email sorts decrypt and sort every
+// matching user, up to maxEmailSortUsers, and email filters are checked again once decrypted.
+func (s *PostgresStore) ListUsers(ctx context.Context, query UserQuery) (*UserPage, error) {
+	sort, cursor, limit, err := query.page()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := s.userFilters(query)
+	estimate, err := s.estimateRows(ctx, "SELECT 1 FROM users WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.reader(ctx).Where(where, args...)
+
+	if isEmailSort(sort) {
+		var users []*models.User
+		if err := db.Limit(maxEmailSortUsers + 1).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		// checked before the email filter, a match past the rows read would be missing from the listing
+		if len(users) > maxEmailSortUsers {
+			return nil, ErrTooManyToSort
+		}
+		users = slices.DeleteFunc(users, func(u *models.User) bool { return !containsEmail(u, query.Email) })
+		return pageUsers(sort, users, cursor, limit, estimate), nil
+	}
+
+	direction, op := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, op = "DESC", "<"
+	}
+
+	// the search grams can match users whose email doesn't contain the filter, so keep reading
+	// past those until the page is full or the table runs out
+	var users []*models.User
+	for len(users) <= limit {
+		batch := s.reader(ctx).Where(where, args...)
+		if cursor != nil {
+			value, _ := time.Parse(time.RFC3339Nano, cursor.Value)
+			batch = batch.Where(fmt.Sprintf("(created_at, id) %s (?, ?)", op), value, cursor.ID)
+		}
+
+		var read []*models.User
+		if err := batch.Order(fmt.Sprintf("created_at %s, id %s", direction, direction)).Limit(limit + 1).Find(&read).Error; err != nil {
+			return nil, err
+		}
+		for _, user := range read {
+			if containsEmail(user, query.Email) {
+				users = append(users, user)
+			}
+		}
+		if len(read) <= limit {
+			break
+		}
+
+		last := read[len(read)-1]
+		cursor = &userCursor{Value: sortValue(sort, last), ID: last.ID}
+	}
+
+	return newUserPage(sort, take(users, limit+1), limit, estimate), nil
+}
+
+// userFilters turns the query's filters into a where clause. The email filter matches on the
+// email's search grams, or on the plaintext for rows written before encryption.
+func (s *PostgresStore) userFilters(query UserQuery) (string, []any) {
+	conditions := []string{"TRUE"}
+	var args []any
+
+	if query.Email != "" {
+		conditions = append(conditions, `(email_grams @> ? OR (email_index IS NULL AND email ILIKE ? ESCAPE '\'))`)
+		args = append(args, pq.StringArray(s.keys.SearchTerms(query.Email)), "%"+strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query.Email)+"%")
+	}
+
+	isSet := map[bool]string{true: "IS NOT NULL", false: "IS NULL"}
+	if query.Confirmed != nil {
+		conditions = append(conditions, "email_confirmed_at "+isSet[*query.Confirmed])
+	}
+	if query.Deleted != nil {
+		conditions = append(conditions, "deleted_at "+isSet[*query.Deleted])
+	}
+	if query.Admin != nil {
+		conditions = append(conditions, "is_admin = ?")
+		args = append(args, *query.Admin)
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *query.CreatedBefore)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// estimateRows asks the planner how many rows the query returns instead of counting them.
+func (s *PostgresStore) estimateRows(ctx context.Context, query string, args ...any) (int64, error) {
+	var plan string
+	if err := s.reader(ctx).Raw("EXPLAIN (FORMAT JSON) "+query, args...).Scan(&plan).Error; err != nil {
+		return 0, err
+	}
+
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &explained); err != nil {
+		return 0, fmt.Errorf("reading the query plan: %w", err)
+	}
+	if len(explained) == 0 {
+		return 0, errors.New("the query plan is empty")
+	}
+
+	return int64(explained[0].Plan.Rows), nil
+}
+
+func (s *PostgresStore) DeleteUserByID(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Delete(&models.User{}, id)
+	return result.Error
+}
+
+func (s *PostgresStore) GetApiTokenByHashedToken(ctx context.Context, hashedToken string) (*models.ApiToken, error) {
+	var token models.ApiToken
+	result := s.db.WithContext(ctx).Where("hashed_token = ?", hashedToken).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("api token not found")
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+func (s *PostgresStore) CreateApiToken(ctx context.Context, token *models.ApiToken) error {
+	return s.db.WithContext(ctx).Create(token).Error
+}
+
+func (s *PostgresStore) GetApiTokensByUserID(ctx context.Context, userID uuid.UUID) ([]*models.ApiToken, error) {
+	var tokens []*models.ApiToken
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens)
+	return tokens, result.Error
+}
+
+func (s *PostgresStore) DeleteApiToken(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.ApiToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("api token not found with id %s", id)
+	}
+	return nil
+}
+
+// RevokeSessions invalidates every token issued to the user before at.
+func (s *PostgresStore) RevokeSessions(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	result := s.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]any{
+		"security_version_changed_at": at,
+		"version":                     gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user not found with id %s", userID)
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return s.db.WithContext(ctx).Create(event).Error
+}
+
+func (s *PostgresStore) GetAuditEventsByUserID(ctx context.Context, userID uuid.UUID, limit int) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&events)
+	return events, result.Error
+}
+
+func (s *PostgresStore) GetSubscriptionByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	var sub models.Subscription
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&sub)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w for user %s", ErrSubscriptionNotFound, userID)
+		}
+		return nil, result.Error
+	}
+	return &sub, nil
+}
+
+func (s *PostgresStore) GetSubscriptionByStripeCustomerID(ctx context.Context, customerID string) (*models.Subscription, error) {
+	var sub models.Subscription
+	result := s.db.WithContext(ctx).Where("stripe_customer_id = ?", customerID).First(&sub)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w for customer %s", ErrSubscriptionNotFound, customerID)
+		}
+		return nil, result.Error
+	}
+	return &sub, nil
+}
+
+func (s *PostgresStore) GetSubscriptionsByStatus(ctx context.Context, status string) ([]*models.Subscription, error) {
+	var subs []*models.Subscription
+	result := s.db.WithContext(ctx).Where("status = ?", status).Find(&subs)
+	return subs, result.Error
+}
+
+// SaveSubscription creates the subscription or updates every field of an existing one.
+func (s *PostgresStore) SaveSubscription(ctx context.Context, sub *models.Subscription) error {
+	return s.db.WithContext(ctx).Save(sub).Error
+}
+
+// SaveInvoice upserts the invoice by its stripe id.
+func (s *PostgresStore) SaveInvoice(ctx context.Context, inv *models.Invoice) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "stripe_invoice_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"number", "status", "description", "currency", "subtotal", "discount", "tax", "total",
+			"amount_paid", "period_start", "period_end", "paid_at", "updated_at",
+		}),
+	}).Create(inv).Error
+}
+
+func (s *PostgresStore) GetInvoiceByID(ctx context.Context, id uuid.UUID) (*models.Invoice, error) {
+	var inv models.Invoice
+	result := s.db.WithContext(ctx).First(&inv, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("invoice not found with id %s", id)
+		}
+		return nil, result.Error
+	}
+	return &inv, nil
+}
+
+func (s *PostgresStore) GetInvoicesByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.Invoice, int64, error) {
+	var total int64
+	if err := s.db.WithContext(ctx).Model(&models.Invoice{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var invoices []*models.Invoice
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Offset(offset).Find(&invoices)
+	return invoices, total, result.Error
+}
+
+func (s *PostgresStore) GetBillingProfileByUserID(ctx context.Context, userID uuid.UUID) (*models.BillingProfile, error) {
+	var profile models.BillingProfile
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&profile)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("billing profile not found for user %s", userID)
+		}
+		return nil, result.Error
+	}
+	return &profile, nil
+}
+
+func (s *PostgresStore) SaveBillingProfile(ctx context.Context, profile *models.BillingProfile) error {
+	return s.db.WithContext(ctx).Save(profile).Error
+}
+
+func (s *PostgresStore) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	return s.db.WithContext(ctx).Create(coupon).Error
+}
+
+func (s *PostgresStore) UpdateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	return s.db.WithContext(ctx).Model(coupon).Select("*").Updates(coupon).Error
+}
+
+func (s *PostgresStore) GetCouponByID(ctx context.Context, id uuid.UUID) (*models.Coupon, error) {
+	var coupon models.Coupon
+	result := s.db.WithContext(ctx).First(&coupon, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("coupon not found with id %s", id)
+		}
+		return nil, result.Error
+	}
+	return &coupon, nil
+}
+
+func (s *PostgresStore) GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	result := s.db.WithContext(ctx).Where("code = ?", code).First(&coupon)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("coupon not found with code %s", code)
+		}
+		return nil, result.Error
+	}
+	return &coupon, nil
+}
+
+func (s *PostgresStore) GetAllCoupons(ctx context.Context) ([]*models.Coupon, error) {
+	var coupons []*models.Coupon
+	result := s.db.WithContext(ctx).Order("created_at DESC").Find(&coupons)
+	return coupons, result.Error
+}
+
+func (s *PostgresStore) GetCouponRedemption(ctx context.Context, couponID, userID uuid.UUID) (*models.CouponRedemption, error) {
+	var redemption models.CouponRedemption
+	result := s.db.WithContext(ctx).Where("coupon_id = ? AND user_id = ?", couponID, userID).First(&redemption)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("redemption not found")
+		}
+		return nil, result.Error
+	}
+	return &redemption, nil
+}
+
+// ReserveCoupon holds one of the coupon's redemptions for the user's checkout until
+// redemption.ExpiresAt, failing if the user already redeemed it or the coupon is out of
+// redemptions. Reserving again replaces the user's earlier reservation without counting twice.
+// Reservations that expired without a webhook releasing them are released first.
+func (s *PostgresStore) ReserveCoupon(ctx context.Context, redemption *models.CouponRedemption) error {
+	redemption.Status = models.CouponReserved
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := releaseExpiredReservations(tx, redemption.CouponID); err != nil {
+			return err
+		}
+
+		existing, err := lockCouponRedemption(tx, redemption.CouponID, redemption.UserID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return createCouponRedemption(tx, redemption)
+		}
+		if existing.Status == models.CouponRedeemed {
+			return ErrCouponAlreadyRedeemed
+		}
+
+		redemption.ID = existing.ID
+		return tx.Model(existing).Updates(map[string]any{
+			"plan":                redemption.Plan,
+			"checkout_session_id": redemption.CheckoutSessionID,
+			"expires_at":          redemption.ExpiresAt,
+		}).Error
+	})
+}
+
+// RedeemCoupon confirms the user's reservation, or records a redemption and bumps the coupon's
+// count when there's none, failing if the user already redeemed it or the coupon is out of
+// redemptions. Redeeming again for the same checkout session succeeds, so webhooks can retry.
+func (s *PostgresStore) RedeemCoupon(ctx context.Context, redemption *models.CouponRedemption) error {
+	redemption.Status = models.CouponRedeemed
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		existing, err := lockCouponRedemption(tx, redemption.CouponID, redemption.UserID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return createCouponRedemption(tx, redemption)
+		}
+		if existing.Status == models.CouponRedeemed {
+			if redemption.CheckoutSessionID != "" && existing.CheckoutSessionID == redemption.CheckoutSessionID {
+				redemption.ID = existing.ID
+				return nil
+			}
+			return ErrCouponAlreadyRedeemed
+		}
+
+		redemption.ID = existing.ID
+		redemption.ExpiresAt = nil
+		return tx.Model(existing).Updates(map[string]any{
+			"status":              models.CouponRedeemed,
+			"plan":                redemption.Plan,
+			"checkout_session_id": redemption.CheckoutSessionID,
+			"expires_at":          nil,
+		}).Error
+	})
+}
+
+// ReleaseCoupon gives back the user's reservation if it's still the one redemption.ExpiresAt
+// describes, so an expired checkout doesn't release a newer one's.
+func (s *PostgresStore) ReleaseCoupon(ctx context.Context, redemption *models.CouponRedemption) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("coupon_id = ? AND user_id = ? AND status = ? AND expires_at = ?", redemption.CouponID, redemption.UserID, models.CouponReserved, redemption.ExpiresAt).
+			Delete(&models.CouponRedemption{})
+		if result.Error != nil {
+			return result.Error
+		}
+		return uncountRedemptions(tx, redemption.CouponID, result.RowsAffected)
+	})
+}
+
+func lockCouponRedemption(tx *gorm.DB, couponID, userID uuid.UUID) (*models.CouponRedemption, error) {
+	var redemption models.CouponRedemption
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("coupon_id = ? AND user_id = ?", couponID, userID).Limit(1).Find(&redemption)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &redemption, nil
+}
+
+// createCouponRedemption inserts the redemption and bumps the coupon's count, failing if the
+// user already has one or the coupon is out of redemptions.
+func createCouponRedemption(tx *gorm.DB, redemption *models.CouponRedemption) error {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(redemption)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponAlreadyRedeemed
+	}
+
+	result = tx.Model(&models.Coupon{}).
+		Where("id = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", redemption.CouponID).
+		Update("times_redeemed", gorm.Expr("times_redeemed + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponExhausted
+	}
+
+	return nil
+}
+
+func releaseExpiredReservations(tx *gorm.DB, couponID uuid.UUID) error {
+	result := tx.Where("coupon_id = ? AND status = ? AND expires_at <= ?", couponID, models.CouponReserved, time.Now()).
+		Delete(&models.CouponRedemption{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return uncountRedemptions(tx, couponID, result.RowsAffected)
+}
+
+func uncountRedemptions(tx *gorm.DB, couponID uuid.UUID, released int64) error {
+	if released == 0 {
+		return nil
+	}
+	return tx.Model(&models.Coupon{}).Where("id = ?", couponID).
+		Update("times_redeemed", gorm.Expr("GREATEST(times_redeemed - ?, 0)", released)).Error
+}
+
+func (s *PostgresStore) CreateAffiliate(ctx context.Context, affiliate *models.Affiliate) error {
+	return s.db.WithContext(ctx).Create(affiliate).Error
+}
+
+func (s *PostgresStore) GetAffiliateByID(ctx context.Context, id uuid.UUID) (*models.Affiliate, error) {
+	var affiliate models.Affiliate
+	result := s.db.WithContext(ctx).First(&affiliate, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("affiliate not found with id %s", id)
+		}
+		return nil, result.Error
+	}
+	return &affiliate, nil
+}
+
+func (s *PostgresStore) GetAffiliateByCode(ctx context.Context, code string) (*models.Affiliate, error) {
+	var affiliate models.Affiliate
+	result := s.db.WithContext(ctx).Where("code = ?", code).First(&affiliate)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("affiliate not found with code %s", code)
+		}
+		return nil, result.Error
+	}
+	return &affiliate, nil
+}
+
+func (s *PostgresStore) GetAffiliateByUserID(ctx context.Context, userID uuid.UUID) (*models.Affiliate, error) {
+	var affiliate models.Affiliate
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&affiliate)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("affiliate not found for user %s", userID)
+		}
+		return nil, result.Error
+	}
+	return &affiliate, nil
+}
+
+func (s *PostgresStore) CreateAffiliateClick(ctx context.Context, click *models.AffiliateClick) error {
+	return s.db.WithContext(ctx).Create(click).Error
+}
+
+// CreateAffiliateReferral attributes the user to an affiliate. The first attribution wins.
+func (s *PostgresStore) CreateAffiliateReferral(ctx context.Context, referral *models.AffiliateReferral) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(referral).Error
+}
+
+func (s *PostgresStore) GetAffiliateReferralByUserID(ctx context.Context, userID uuid.UUID) (*models.AffiliateReferral, error) {
+	var referral models.AffiliateReferral
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&referral)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("referral not found for user %s", userID)
+		}
+		return nil, result.Error
+	}
+	return &referral, nil
+}
+
+// CreateCommission stores a commission once per invoice so webhook retries don't double pay.
+func (s *PostgresStore) CreateCommission(ctx context.Context, commission *models.Commission) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(commission).Error
+}
+
+func (s *PostgresStore) GetAffiliateStats(ctx context.Context, affiliateID uuid.UUID) (*models.AffiliateStats, error) {
+	stats := &models.AffiliateStats{}
+
+	if err := s.reader(ctx).Model(&models.AffiliateClick{}).Where("affiliate_id = ?", affiliateID).Count(&stats.Clicks).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.reader(ctx).Model(&models.AffiliateReferral{}).Where("affiliate_id = ?", affiliateID).Count(&stats.Signups).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.reader(ctx).Model(&models.Commission{}).
+		Where("affiliate_id = ? AND status <> ?", affiliateID, models.CommissionReversed).
+		Distinct("user_id").
+		Count(&stats.Conversions).Error; err != nil {
+		return nil, err
+	}
+
+	var totals []struct {
+		Status string
+		Total  int64
+	}
+	if err := s.reader(ctx).Model(&models.Commission{}).
+		Select("status, COALESCE(SUM(amount - reversed_amount), 0) AS total").
+		Where("affiliate_id = ?", affiliateID).
+		Group("status").
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+
+	for _, total := range totals {
+		switch total.Status {
+		case models.CommissionPending:
+			stats.Pending = total.Total
+		case models.CommissionApproved:
+			stats.Approved = total.Total
+		case models.CommissionPaid:
+			stats.Paid = total.Total
+		}
+	}
+
+	// adjustments come off what's owed until the batch they were deducted in is paid
+	var adjustments []struct {
+		Paid  bool
+		Total int64
+	}
+	if err := s.reader(ctx).Table("commission_adjustments AS a").
+		Select("COALESCE(b.status = ?, false) AS paid, SUM(a.amount) AS total", models.PayoutBatchPaid).
+		Joins("LEFT JOIN payout_batches b ON b.id = a.payout_batch_id").
+		Where("a.affiliate_id = ?", affiliateID).
+		Group("1").
+		Scan(&adjustments).Error; err != nil {
+		return nil, err
+	}
+
+	for _, adjustment := range adjustments {
+		if adjustment.Paid {
+			stats.Paid += adjustment.Total
+		} else {
+			stats.Approved += adjustment.Total
+		}
+	}
+
+	return stats, nil
+}
+
+// ApproveCommissions approves pending commissions created before the cutoff, once they're past the refund hold window.
+func (s *PostgresStore) ApproveCommissions(ctx context.Context, createdBefore time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Model(&models.Commission{}).
+		Where("status = ? AND created_at < ?", models.CommissionPending, createdBefore).
+		Updates(map[string]interface{}{"status": models.CommissionApproved, "updated_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// ReverseCommission reverses the share of an invoice's commission that has been refunded, given the
+// refunded total of the charge. A commission that hasn't been batched yet is reduced, one that has
+// been batched or paid gets a negative adjustment that's deducted from the affiliate's next payout.
+// It returns the amount reversed, which is zero when the refund was already accounted for.
+func (s *PostgresStore) ReverseCommission(ctx context.Context, stripeInvoiceID string, refunded, charged int64) (int64, error) {
+	var amount int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var commission models.Commission
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("stripe_invoice_id = ?", stripeInvoiceID).Limit(1).Find(&commission)
+		if result.Error != nil || result.RowsAffected == 0 || commission.Status == models.CommissionReversed {
+			return result.Error
+		}
+
+		var adjusted int64
+		if err := tx.Model(&models.CommissionAdjustment{}).
+			Select("COALESCE(SUM(amount), 0)").
+			Where("commission_id = ?", commission.ID).
+			Scan(&adjusted).Error; err != nil {
+			return err
+		}
+
+		amount = commissionReversal(&commission, commission.ReversedAmount-adjusted, refunded, charged)
+		if amount == 0 {
+			return nil
+		}
+
+		if commission.PayoutBatchID == nil {
+			updates := map[string]interface{}{"reversed_amount": commission.ReversedAmount + amount, "updated_at": time.Now()}
+			if commission.ReversedAmount+amount == commission.Amount {
+				updates["status"] = models.CommissionReversed
+			}
+			return tx.Model(&commission).Updates(updates).Error
+		}
+
+		return tx.Create(&models.CommissionAdjustment{
+			CommissionID: commission.ID,
+			AffiliateID:  commission.AffiliateID,
+			Amount:       -amount,
+			Currency:     commission.Currency,
+		}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// CreatePayoutBatch pays out the approved commissions earned by the end of the period, including
+// earlier ones that were still on hold, less any outstanding adjustments, totalled per affiliate.
+func (s *PostgresStore) CreatePayoutBatch(ctx context.Context, periodStart, periodEnd time.Time) (*models.PayoutBatch, error) {
+	batch := &models.PayoutBatch{
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Status:      models.PayoutBatchOpen,
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(batch)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPayoutBatchExists
+		}
+
+		var approved []*models.Commission
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND payout_batch_id IS NULL AND created_at < ?", models.CommissionApproved, periodEnd).
+			Find(&approved).Error; err != nil {
+			return err
+		}
+
+		var outstanding []*models.CommissionAdjustment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("payout_batch_id IS NULL AND created_at < ?", periodEnd).
+			Find(&outstanding).Error; err != nil {
+			return err
+		}
+
+		payouts, commissions, adjustments := payoutBatchContents(batch.ID, approved, outstanding, time.Now())
+		if len(payouts) == 0 {
+			return ErrNoApprovedCommissions
+		}
+
+		commissionIDs := make([]uuid.UUID, len(commissions))
+		for i, commission := range commissions {
+			commissionIDs[i] = commission.ID
+		}
+		if err := tx.Model(&models.Commission{}).
+			Where("id IN ?", commissionIDs).
+			Updates(map[string]interface{}{"payout_batch_id": batch.ID, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+
+		if len(adjustments) > 0 {
+			adjustmentIDs := make([]uuid.UUID, len(adjustments))
+			for i, adjustment := range adjustments {
+				adjustmentIDs[i] = adjustment.ID
+			}
+			if err := tx.Model(&models.CommissionAdjustment{}).
+				Where("id IN ?", adjustmentIDs).
+				Update("payout_batch_id", batch.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(payouts).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func (s *PostgresStore) GetPayoutBatches(ctx context.Context) ([]*models.PayoutBatch, error) {
+	var batches []*models.PayoutBatch
+	result := s.db.WithContext(ctx).Order("period_start DESC").Find(&batches)
+	return batches, result.Error
+}
+
+func (s *PostgresStore) GetPayoutBatchByID(ctx context.Context, id uuid.UUID) (*models.PayoutBatch, error) {
+	var batch models.PayoutBatch
+	result := s.db.WithContext(ctx).First(&batch, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("payout batch not found with id %s", id)
+		}
+		return nil, result.Error
+	}
+	return &batch, nil
+}
+
+func (s *PostgresStore) GetAffiliatePayouts(ctx context.Context, batchID uuid.UUID) ([]*models.AffiliatePayout, error) {
+	var payouts []*models.AffiliatePayout
+	result := s.db.WithContext(ctx).Where("payout_batch_id = ?", batchID).Order("amount DESC").Find(&payouts)
+	return payouts, result.Error
+}
+
+// MarkPayoutBatchPaid marks the batch and all of its commissions as paid.
+func (s *PostgresStore) MarkPayoutBatchPaid(ctx context.Context, batch *models.PayoutBatch) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		batch.Status = models.PayoutBatchPaid
+		batch.PaidAt = &now
+
+		if err := tx.Save(batch).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Commission{}).
+			Where("payout_batch_id = ?", batch.ID).
+			Updates(map[string]interface{}{"status": models.CommissionPaid, "updated_at": now}).Error
+	})
+}
+
+// RecordUsage adds quantity to the hourly bucket containing at, creating the bucket if needed.
+func (s *PostgresStore) RecordUsage(ctx context.Context, userID uuid.UUID, metric string, quantity int64, at time.Time) error {
+	bucket := &models.UsageBucket{
+		UserID:      userID,
+		Metric:      metric,
+		BucketStart: at.UTC().Truncate(time.Hour),
+		Quantity:    quantity,
+	}
+
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "metric"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("usage_buckets.quantity + ?", quantity),
+			"updated_at": time.Now(),
+		}),
+	}).Create(bucket).Error
+}
+
+func (s *PostgresStore) GetUsageTotal(ctx context.Context, userID uuid.UUID, metric string, from, to time.Time) (int64, error) {
+	var total int64
+	result := s.db.WithContext(ctx).Model(&models.UsageBucket{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("user_id = ? AND metric = ? AND bucket_start >= ? AND bucket_start < ?", userID, metric, from, to).
+		Scan(&total)
+	return total, result.Error
+}
+
+func (s *PostgresStore) GetUsageBuckets(ctx context.Context, userID uuid.UUID, metric string, from, to time.Time) ([]*models.UsageBucket, error) {
+	var buckets []*models.UsageBucket
+	result := s.db.WithContext(ctx).
+		Where("user_id = ? AND metric = ? AND bucket_start >= ? AND bucket_start < ?", userID, metric, from, to).
+		Order("bucket_start").
+		Find(&buckets)
+	return buckets, result.Error
+}
+
+// GetUnreportedUsageBuckets returns buckets with usage stripe hasn't been told about, for users
+// with a stripe customer to bill. Other users' buckets are left until they have one, so they
+// can't fill every page and starve the users who can be billed.
+func (s *PostgresStore) GetUnreportedUsageBuckets(ctx context.Context, limit int) ([]*models.UsageBucket, error) {
+	var buckets []*models.UsageBucket
+	result := s.db.WithContext(ctx).
+		Joins("JOIN subscriptions ON subscriptions.user_id = usage_buckets.user_id AND subscriptions.stripe_customer_id <> ''").
+		Where("usage_buckets.quantity <> usage_buckets.reported_quantity").
+		Order("usage_buckets.bucket_start").
+		Limit(limit).
+		Find(&buckets)
+	return buckets, result.Error
+}
+
+func (s *PostgresStore) MarkUsageBucketReported(ctx context.Context, bucket *models.UsageBucket) error {
+	return s.db.WithContext(ctx).Model(&models.UsageBucket{}).
+		Where("id = ?", bucket.ID).
+		Update("reported_quantity", bucket.Quantity).Error
+}
+
+// CreateUsageWarning stores the warning and reports whether it was new.
+func (s *PostgresStore) CreateUsageWarning(ctx context.Context, warning *models.UsageWarning) (bool, error) {
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(warning)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (s *PostgresStore) EnqueueEmails(ctx context.Context, emails ...*models.OutboxEmail) error {
+	return enqueueEmails(s.db, emails)
+}
+
+func enqueueEmails(tx *gorm.DB, emails []*models.OutboxEmail) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, email := range emails {
+		email.Status = models.OutboxPending
+		if email.NextAttemptAt.IsZero() {
+			email.NextAttemptAt = now
+		}
+	}
+
+	return tx.Create(emails).Error
+}
+
+// ProcessOutbox delivers up to limit due emails. They're claimed first, in a statement of their
+// own with SKIP LOCKED so several workers can run at once, and then sent outside any transaction,
+// each result recorded as it comes in. A claim is a lease: an email whose worker died is claimed
+// again once it runs out, and a worker that lost its lease doesn't record its result. Failed
+// emails are retried at the time retryAt returns for their attempt count, or dead-lettered when it
+// returns nil or delivery fails with ErrUndeliverable. Emails failing with ErrSkipped are dropped.
+func (s *PostgresStore) ProcessOutbox(ctx context.Context, limit int, deliver func(context.Context, *models.OutboxEmail) error, retryAt func(attempts int) *time.Time) (int, error) {
+	now := time.Now()
+
+	var claimed []*models.OutboxEmail
+	if err := s.db.WithContext(ctx).Raw(`UPDATE outbox_emails SET status = ?, attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox_emails
+			WHERE status IN (?, ?) AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.OutboxSending, now.Add(outboxLease), now, models.OutboxPending, models.OutboxSending, now, limit).
+		Scan(&claimed).Error; err != nil {
+		return 0, err
+	}
+
+	for i, email := range claimed {
+		settleOutboxEmail(email, deliver(ctx, email), retryAt)
+
+		// the email may already be with the provider, so its result is recorded even if ctx is done
+		if err := s.recordOutboxEmail(context.WithoutCancel(ctx), email); err != nil {
+			return i, err
+		}
+	}
+
+	return len(claimed), nil
+}
+
+func (s *PostgresStore) recordOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return s.db.WithContext(ctx).Model(&models.OutboxEmail{}).
+		Where("id = ? AND status = ? AND attempts = ?", email.ID, models.OutboxSending, email.Attempts).
+		Updates(map[string]interface{}{
+			"status":          email.Status,
+			"next_attempt_at": email.NextAttemptAt,
+			"last_error":      email.LastError,
+			"sent_at":         email.SentAt,
+			"updated_at":      time.Now(),
+		}).Error
+}
+
+func (s *PostgresStore) GetOutboxEmailsByStatus(ctx context.Context, status string, limit int) ([]*models.OutboxEmail, error) {
+	var emails []*models.OutboxEmail
+	result := s.db.WithContext(ctx).Where("status = ?", status).Order("updated_at DESC").Limit(limit).Find(&emails)
+	return emails, result.Error
+}
+
+// RetryOutboxEmail puts a dead-lettered email back in the queue with a fresh set of attempts.
+func (s *PostgresStore) RetryOutboxEmail(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Model(&models.OutboxEmail{}).
+		Where("id = ? AND status = ?", id, models.OutboxDead).
+		Updates(map[string]interface{}{
+			"status":          models.OutboxPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"updated_at":      time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("dead email not found with id %s", id)
+	}
+	return nil
+}
+
+// CreateEmailEvent records a provider event, reporting false if it was already recorded.
+func (s *PostgresStore) CreateEmailEvent(ctx context.Context, event *models.EmailEvent) (bool, error) {
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *PostgresStore) SuppressEmail(ctx context.Context, suppression *models.EmailSuppression) error {
+	suppression.Email = strings.ToLower(suppression.Email)
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(suppression).Error
+}
+
+func (s *PostgresStore) IsEmailSuppressed(ctx context.Context, email string) (bool, error) {
+	var count int64
+	result := s.db.WithContext(ctx).Model(&models.EmailSuppression{}).Where("email = ?", strings.ToLower(email)).Count(&count)
+	return count > 0, result.Error
+}
+
+func (s *PostgresStore) GetEmailSuppressions(ctx context.Context) ([]*models.EmailSuppression, error) {
+	var suppressions []*models.EmailSuppression
+	result := s.db.WithContext(ctx).Order("created_at DESC").Find(&suppressions)
+	return suppressions, result.Error
+}
+
+func (s *PostgresStore) DeleteEmailSuppression(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Delete(&models.EmailSuppression{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("suppression not found with id %s", id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetNotificationPreferences(ctx context.Context, userID uuid.UUID) ([]*models.NotificationPreference, error) {
+	var preferences []*models.NotificationPreference
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&preferences)
+	return preferences, result.Error
+}
+
+func (s *PostgresStore) SaveNotificationPreferences(ctx context.Context, userID uuid.UUID, email map[string]bool) error {
+	if len(email) == 0 {
+		return nil
+	}
+
+	preferences := make([]*models.NotificationPreference, 0, len(email))
+	for category, enabled := range email {
+		preferences = append(preferences, &models.NotificationPreference{
+			UserID:   userID,
+			Category: category,
+			Email:    enabled,
+		})
+	}
+
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "category"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "updated_at"}),
+	}).Create(preferences).Error
+}
+
+// IsEmailOptedOut reports whether the user with this address turned off the category. Required
+// categories and addresses without an account are never opted out.
+func (s *PostgresStore) IsEmailOptedOut(ctx context.Context, email string, category string) (bool, error) {
+	if c, ok := models.GetNotificationCategory(category); !ok || c.Required {
+		return false, nil
+	}
+
+	match, args := s.emailMatch("users", email)
+
+	var count int64
+	result := s.db.WithContext(ctx).Model(&models.NotificationPreference{}).
+		Joins("JOIN users ON users.id = notification_preferences.user_id").
+		Where(match, args...).
+		Where("notification_preferences.category = ? AND notification_preferences.email = ?", category, false).
+		Count(&count)
+	return count > 0, result.Error
+}
+
+// notificationsChannel carries the ID of each new notification to the listening replicas.
+const notificationsChannel = "notifications"
+
+// CreateNotification announces the notification with pg_notify in the same transaction, which
+// Postgres delivers to listeners when it commits.
+func (s *PostgresStore) CreateNotification(ctx context.Context, notification *models.Notification) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(notification).Error; err != nil {
+			return err
+		}
+		return tx.Exec("SELECT pg_notify(?, ?)", notificationsChannel, notification.ID.String()).Error
+	})
+}
+
+// ListenNotifications holds a connection out of the pool for LISTEN and loads each announced
+// notification through the pool. The connection is discarded afterwards rather than going back
+// to the pool still listening.
+func (s *PostgresStore) ListenNotifications(ctx context.Context, fn func(*models.Notification)) error {
+	pool, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		listener := driverConn.(*stdlib.Conn).Conn()
+		err := listenNotifications(ctx, listener, func(id uuid.UUID) error {
+			var notification models.Notification
+			result := s.db.WithContext(ctx).First(&notification, "id = ?", id)
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				// deleted with its user before we got to it
+				return nil
+			}
+			if result.Error != nil {
+				return result.Error
+			}
+			fn(&notification)
+			return nil
+		})
+		return fmt.Errorf("%w (%w)", err, driver.ErrBadConn)
+	})
+}
+
+func listenNotifications(ctx context.Context, conn *pgx.Conn, fn func(uuid.UUID) error) error {
+	if _, err := conn.Exec(ctx, "LISTEN "+notificationsChannel); err != nil {
+		return err
+	}
+	for {
+		announced, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		id, err := uuid.Parse(announced.Payload)
+		if err != nil {
+			continue
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *PostgresStore) GetNotifications(ctx context.Context, userID uuid.UUID, limit int) ([]*models.Notification, error) {
+	var notifications []*models.Notification
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&notifications)
+	return notifications, result.Error
+}
+
+func (s *PostgresStore) CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	result := s.db.WithContext(ctx).Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	return count, result.Error
+}
+
+func (s *PostgresStore) MarkNotificationRead(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("notification not found with id %s", id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) error {
+	return s.db.WithContext(ctx).Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now()).Error
+}
+
+// EnqueueJob inserts a job. Scheduled runs that were already enqueued are ignored.
+func (s *PostgresStore) EnqueueJob(ctx context.Context, job *models.Job) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(job).Error
+}
+
+// ClaimJob marks the next due job as running by workerID and returns it, or nil when there is
+// nothing to do. Running jobs locked before staleBefore are assumed to belong to a crashed
+// worker and are claimed again.
+func (s *PostgresStore) ClaimJob(ctx context.Context, workerID string, staleBefore time.Time) (*models.Job, error) {
+	var claimed *models.Job
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		job := new(models.Job)
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)", models.JobPending, now, models.JobRunning, staleBefore).
+			Order("run_at").
+			Limit(1).
+			Find(job)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		job.Status = models.JobRunning
+		job.Attempts++
+		job.LockedBy = workerID
+		job.LockedAt = &now
+
+		if err := tx.Save(job).Error; err != nil {
+			return err
+		}
+
+		claimed = job
+		return nil
+	})
+
+	return claimed, err
+}
+
+// CompleteJob marks the job as succeeded. It returns ErrJobLeaseLost when the job has since been
+// claimed again, so a worker that overran its lease can't overwrite the newer attempt.
+func (s *PostgresStore) CompleteJob(ctx context.Context, job *models.Job) error {
+	now := time.Now()
+	if err := s.settleJob(ctx, job, map[string]interface{}{
+		"status":      models.JobSucceeded,
+		"finished_at": now,
+		"last_error":  "",
+		"updated_at":  now,
+	}); err != nil {
+		return err
+	}
+
+	job.Status = models.JobSucceeded
+	job.FinishedAt = &now
+	job.LastError = ""
+	job.UpdatedAt = now
+	return nil
+}
+
+// FailJob puts the job back in the queue to run at retryAt, or marks it dead when retryAt is nil.
+// Like CompleteJob it returns ErrJobLeaseLost when the job has since been claimed again.
+func (s *PostgresStore) FailJob(ctx context.Context, job *models.Job, retryAt *time.Time) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"locked_by":  "",
+		"locked_at":  nil,
+		"last_error": job.LastError,
+		"updated_at": now,
+	}
+	if retryAt == nil {
+		updates["status"] = models.JobDead
+		updates["finished_at"] = now
+	} else {
+		updates["status"] = models.JobPending
+		updates["run_at"] = *retryAt
+	}
+
+	if err := s.settleJob(ctx, job, updates); err != nil {
+		return err
+	}
+
+	job.LockedBy = ""
+	job.LockedAt = nil
+	job.UpdatedAt = now
+	if retryAt == nil {
+		job.Status = models.JobDead
+		job.FinishedAt = &now
+	} else {
+		job.Status = models.JobPending
+		job.RunAt = *retryAt
+	}
+	return nil
+}
+
+// settleJob records the outcome of the attempt the job was claimed for, as long as that worker
+// still holds it.
+func (s *PostgresStore) settleJob(ctx context.Context, job *models.Job, updates map[string]interface{}) error {
+	result := s.db.WithContext(ctx).Model(&models.Job{}).
+		Where("id = ? AND status = ? AND locked_by = ? AND attempts = ?", job.ID, models.JobRunning, job.LockedBy, job.Attempts).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: job %s attempt %d", ErrJobLeaseLost, job.ID, job.Attempts)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetJobs(ctx context.Context, status string, limit int) ([]*models.Job, error) {
+	var jobs []*models.Job
+	query := s.db.WithContext(ctx).Order("updated_at DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	result := query.Find(&jobs)
+	return jobs, result.Error
+}
+
+func (s *PostgresStore) GetJobCounts(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	result := s.db.WithContext(ctx).Model(&models.Job{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows)
+
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, result.Error
+}
+
+// RetryJob requeues a dead job with a fresh set of attempts.
+func (s *PostgresStore) RetryJob(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, models.JobDead).
+		Updates(map[string]any{"status": models.JobPending, "attempts": 0, "run_at": time.Now(), "finished_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("job not found with id %s", id)
+	}
+	return nil
+}
+
+// DeleteFinishedJobs removes succeeded jobs that finished before the cutoff. Dead jobs are kept
+// until someone retries or looks at them.
+func (s *PostgresStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("status = ? AND finished_at < ?", models.JobSucceeded, before).Delete(&models.Job{})
+	return result.RowsAffected, result.Error
+}
+
+// SyncJobSchedule registers a recurring job, resetting its next run when the spec changed.
+func (s *PostgresStore) SyncJobSchedule(ctx context.Context, name string, spec string, next time.Time) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"spec":        spec,
+			"next_run_at": gorm.Expr("CASE WHEN job_schedules.spec = excluded.spec THEN job_schedules.next_run_at ELSE excluded.next_run_at END"),
+			"updated_at":  time.Now(),
+		}),
+	}).Create(&models.JobSchedule{Name: name, Spec: spec, NextRunAt: next}).Error
+}
+
+func (s *PostgresStore) GetJobSchedules(ctx context.Context) ([]*models.JobSchedule, error) {
+	var schedules []*models.JobSchedule
+	result := s.db.WithContext(ctx).Order("name").Find(&schedules)
+	return schedules, result.Error
+}
+
+// EnqueueScheduledJob enqueues the run that was due at the schedule's next run time and moves
+// the schedule on to next.
+func (s *PostgresStore) EnqueueScheduledJob(ctx context.Context, schedule *models.JobSchedule, next time.Time, maxAttempts int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		due := schedule.NextRunAt
+
+		job := &models.Job{
+			Name:         schedule.Name,
+			ScheduledFor: &due,
+			RunAt:        time.Now(),
+			MaxAttempts:  maxAttempts,
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(job).Error; err != nil {
+			return err
+		}
+
+		schedule.LastRunAt = &due
+		schedule.NextRunAt = next
+		return tx.Save(schedule).Error
+	})
+}
+
+// postgresLock is a session-level advisory lock. It's held on a dedicated connection and is
+// released by Postgres if that connection drops.
+type postgresLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// TryAdvisoryLock takes the advisory lock for key without waiting. It returns nil when another
+// session holds the lock.
+func (s *PostgresStore) TryAdvisoryLock(ctx context.Context, key int64) (Lock, error) {
+	db, err := s.db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &postgresLock{conn: conn, key: key}, nil
+}
+
+func (l *postgresLock) Alive(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+func (l *postgresLock) Release() error {
+	defer l.conn.Close()
+
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
+
+func (s *PostgresStore) GetUsersDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	var users []*models.User
+	result := s.db.WithContext(ctx).Where("deleted_at < ?", before).Order("deleted_at").Limit(limit).Find(&users)
+	return users, result.Error
+}
+
+// PurgeUser hard-deletes a soft-deleted user and the rows that belong to them, writes the
+// tombstone and queues the emails in one transaction. Returns ErrUserNotDeleted if the user was
+// restored.
+func (s *PostgresStore) PurgeUser(ctx context.Context, user *models.User, tombstone *models.AuditEvent, emails ...*models.OutboxEmail) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND deleted_at IS NOT NULL", user.ID).Delete(&models.User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotDeleted
+		}
+
+		if err := deleteUserData(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := tx.Create(tombstone).Error; err != nil {
+			return err
+		}
+
+		return enqueueEmails(tx, emails)
+	})
+}
+
+// deleteUserData removes the rows that belong to a user being hard-deleted. Invoices,
+// commissions and coupon redemptions are kept for accounting and the user's affiliate account
+// is disabled rather than deleted because payouts reference it.
+func deleteUserData(tx *gorm.DB, userID uuid.UUID) error {
+	related := []any{
+		&models.ApiToken{},
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.UsageBucket{},
+		&models.UsageWarning{},
+		&models.BillingProfile{},
+		&models.Subscription{},
+		&models.AffiliateReferral{},
+	}
+	for _, model := range related {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.Affiliate{}).Where("user_id = ?", userID).Update("status", models.AffiliateDisabled).Error
+}
+
+// GetUsersDueConfirmationReminder returns unconfirmed users who signed up before createdBefore
+// and have been sent at most remindersSent confirmation reminders.
+func (s *PostgresStore) GetUsersDueConfirmationReminder(ctx context.Context, createdBefore time.Time, remindersSent int, limit int) ([]*models.User, error) {
+	var users []*models.User
+	result := s.db.WithContext(ctx).
+		Where("email_confirmed_at IS NULL AND deleted_at IS NULL AND created_at < ? AND confirmation_reminders <= ?", createdBefore, remindersSent).
+		Order("created_at").
+		Limit(limit).
+		Find(&users)
+	return users, result.Error
+}
+
+func (s *PostgresStore) GetExpiredUnconfirmedUsers(ctx context.Context, createdBefore time.Time, limit int) ([]*models.User, error) {
+	var users []*models.User
+	result := s.db.WithContext(ctx).
+		Where("email_confirmed_at IS NULL AND created_at < ?", createdBefore).
+		Order("created_at").
+		Limit(limit).
+		Find(&users)
+	return users, result.Error
+}
+
+// ExpireUnconfirmedUser hard-deletes a user who never confirmed their email, freeing the address
+// to sign up again. Returns ErrUserConfirmed if they confirmed in the meantime.
+func (s *PostgresStore) ExpireUnconfirmedUser(ctx context.Context, user *models.User, tombstone *models.AuditEvent) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND email_confirmed_at IS NULL", user.ID).Delete(&models.User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserConfirmed
+		}
+
+		if err := deleteUserData(tx, user.ID); err != nil {
+			return err
+		}
+
+		return tx.Create(tombstone).Error
+	})
+}
+
+// ClearStaleEmailChanges drops pending email changes requested before the cutoff.
+func (s *PostgresStore) ClearStaleEmailChanges(ctx context.Context, requestedBefore time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("updated_email <> '' AND updated_email_at < ?", requestedBefore).
+		Updates(map[string]any{"updated_email": "", "updated_email_at": nil, "version": gorm.Expr("version + 1")})
+	return result.RowsAffected, result.Error
+}
+
+// WithTenant sets app.current_org and app.current_user for the transaction, which the tenant
+// tables' row-level security policies read. A nested call puts the outer tenant back when it
+// returns.
+func (s *PostgresStore) WithTenant(ctx context.Context, tenant Tenant, fn func(Storage) error) error {
+	return s.WithTx(ctx, func(tx Storage) error {
+		db := tx.(*PostgresStore).db.WithContext(ctx)
+
+		var outer struct{ OrgID, UserID string }
+		if err := db.Raw("SELECT COALESCE(current_setting('app.current_org', true), '') AS org_id, COALESCE(current_setting('app.current_user', true), '') AS user_id").
+			Scan(&outer).Error; err != nil {
+			return err
+		}
+
+		if err := setTenant(db, tenantSetting(tenant.OrgID), tenantSetting(tenant.UserID)); err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return setTenant(db, outer.OrgID, outer.UserID)
+	})
+}
+
+// setTenant sets the tenant until the end of the transaction.
+func setTenant(db *gorm.DB, orgID, userID string) error {
+	return db.Exec("SELECT set_config('app.current_org', ?, true), set_config('app.current_user', ?, true)", orgID, userID).Error
+}
+
+func tenantSetting(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+// tenantError turns a write rejected by a row-level security policy into ErrOutsideTenant.
+func tenantError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42501" {
+		return fmt.Errorf("%w: %s", ErrOutsideTenant, pgErr.Message)
+	}
+	return err
+}
+
+// CreateOrganization creates the organization and its owner's membership in the same transaction.
+func (s *PostgresStore) CreateOrganization(ctx context.Context, org *models.Organization, owner uuid.UUID) error {
+	return tenantError(s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMembership{OrganizationID: org.ID, UserID: owner, Role: models.OrganizationOwner}).Error
+	}))
+}
+
+func (s *PostgresStore) GetOrganization(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	var org models.Organization
+	result := s.db.WithContext(ctx).First(&org, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w with id %s", ErrOrganizationNotFound, id)
+		}
+		return nil, result.Error
+	}
+	return &org, nil
+}
+
+func (s *PostgresStore) GetOrganizations(ctx context.Context) ([]*models.Organization, error) {
+	var orgs []*models.Organization
+	result := s.db.WithContext(ctx).Order("created_at, id").Find(&orgs)
+	return orgs, result.Error
+}
+
+func (s *PostgresStore) AddOrganizationMember(ctx context.Context, membership *models.OrganizationMembership) error {
+	return tenantError(s.db.WithContext(ctx).Create(membership).Error)
+}
+
+func (s *PostgresStore) GetOrganizationMembers(ctx context.Context, orgID uuid.UUID) ([]*models.OrganizationMembership, error) {
+	var members []*models.OrganizationMembership
+	result := s.db.WithContext(ctx).Where("organization_id = ?", orgID).Order("created_at, id").Find(&members)
+	return members, result.Error
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/colecaccamise/go-backend/pii"
+	"github.com/colecaccamise/go-backend/util"
+)
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// PostgresConfig says how to reach the database and how to size the connection pools.
+type PostgresConfig struct {
+	// DSN is the primary, as a postgres:// URL or a key=value connection string.
+	DSN string
+	// ReplicaDSN optionally sends read-only queries that can tolerate replication lag to a replica.
+	ReplicaDSN string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// Keys encrypts personal data, see pii.Keyring.
+	Keys *pii.Keyring
+
+	// StatementTimeout bounds each statement on top of the caller's context. Zero leaves it to the
+	// caller.
+	StatementTimeout time.Duration
+
+	// ConnectAttempts is how many times to try connecting before giving up, waiting ConnectBackoff
+	// after the first failure and doubling it up to MaxConnectBackoff.
+	ConnectAttempts   int
+	ConnectBackoff    time.Duration
+	MaxConnectBackoff time.Duration
+}
+
+// PostgresConfigFromEnv reads the database config. The primary is DATABASE_URL, or built from
+// POSTGRES_USER, POSTGRES_PASSWORD, POSTGRES_HOST, POSTGRES_PORT and POSTGRES_DB, and the replica is
+// DATABASE_REPLICA_URL. DB_SSLMODE, DB_SSLROOTCERT, DB_SSLCERT and DB_SSLKEY set TLS on both.
+func PostgresConfigFromEnv() (PostgresConfig, error) {
+	primary := os.Getenv("DATABASE_URL")
+	if primary == "" {
+		primary = (&url.URL{
+			Scheme: "postgresql",
+			User:   url.UserPassword(os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD")),
+			Host:   os.Getenv("POSTGRES_HOST") + ":" + os.Getenv("POSTGRES_PORT"),
+			Path:   "/" + os.Getenv("POSTGRES_DB"),
+		}).String()
+	}
+
+	tls := map[string]string{
+		"sslmode":     os.Getenv("DB_SSLMODE"),
+		"sslrootcert": os.Getenv("DB_SSLROOTCERT"),
+		"sslcert":     os.Getenv("DB_SSLCERT"),
+		"sslkey":      os.Getenv("DB_SSLKEY"),
+	}
+	if mode := tls["sslmode"]; mode != "" && !slices.Contains(sslModes, mode) {
+		return PostgresConfig{}, fmt.Errorf("DB_SSLMODE must be one of %s, got %q", strings.Join(sslModes, ", "), mode)
+	}
+
+	config := PostgresConfig{
+		MaxOpenConns:      util.GetEnvInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:      util.GetEnvInt("DB_MAX_IDLE_CONNS", 10),
+		ConnMaxLifetime:   time.Duration(util.GetEnvInt("DB_CONN_MAX_LIFETIME_SECONDS", 1800)) * time.Second,
+		ConnMaxIdleTime:   time.Duration(util.GetEnvInt("DB_CONN_MAX_IDLE_TIME_SECONDS", 300)) * time.Second,
+		StatementTimeout:  time.Duration(util.GetEnvInt("DB_STATEMENT_TIMEOUT_SECONDS", 10)) * time.Second,
+		ConnectAttempts:   util.GetEnvInt("DB_CONNECT_ATTEMPTS", 8),
+		ConnectBackoff:    time.Duration(util.GetEnvInt("DB_CONNECT_BACKOFF_SECONDS", 1)) * time.Second,
+		MaxConnectBackoff: time.Duration(util.GetEnvInt("DB_CONNECT_MAX_BACKOFF_SECONDS", 30)) * time.Second,
+	}
+
+	var err error
+	if config.Keys, err = pii.FromEnv(); err != nil {
+		return PostgresConfig{}, err
+	}
+	if config.DSN, err = withDSNOptions(primary, tls); err != nil {
+		return PostgresConfig{}, fmt.Errorf("DATABASE_URL: %w", err)
+	}
+	if replica := os.Getenv("DATABASE_REPLICA_URL"); replica != "" {
+		if config.ReplicaDSN, err = withDSNOptions(replica, tls); err != nil {
+			return PostgresConfig{}, fmt.Errorf("DATABASE_REPLICA_URL: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// withDSNOptions sets the non-empty options on dsn, replacing any it already has. dsn is either a
+// URL or a key=value connection string.
+func withDSNOptions(dsn string, options map[string]string) (string, error) {
+	keys := make([]string, 0, len(options))
+	for key, value := range options {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		for _, key := range keys {
+			query.Set(key, options[key])
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	// later keywords win in a key=value string
+	for _, key := range keys {
+		dsn += fmt.Sprintf(" %s='%s'", key, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(options[key]))
+	}
+	return strings.TrimSpace(dsn), nil
+}
+
+// connectBackoff waits before the next connection attempt, doubling the delay each time up to
+// MaxConnectBackoff.
+func (c PostgresConfig) connectBackoff(ctx context.Context, attempt int) error {
+	delay := c.ConnectBackoff
+	for i := 1; i < attempt && delay < c.MaxConnectBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, c.MaxConnectBackoff)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import "testing"
+
+func TestWithDSNOptions(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		options map[string]string
+		want    string
+	}{
+		{
+			dsn:     "postgresql://app:secret@db:5432/app",
+			options: map[string]string{"sslmode": "verify-full", "sslrootcert": "/etc/ssl/rds.pem", "sslkey": ""},
+			want:    "postgresql://app:secret@db:5432/app?sslmode=verify-full&sslrootcert=%2Fetc%2Fssl%2Frds.pem",
+		},
+		{
+			dsn:     "postgres://app@db/app?sslmode=disable&application_name=api",
+			options: map[string]string{"sslmode": "require"},
+			want:    "postgres://app@db/app?application_name=api&sslmode=require",
+		},
+		{
+			dsn:     "host=db user=app dbname=app",
+			options: map[string]string{"sslmode": "require", "sslcert": `/certs/it's.pem`},
+			want:    `host=db user=app dbname=app sslcert='/certs/it\'s.pem' sslmode='require'`,
+		},
+		{
+			dsn:     "postgresql://app@db/app",
+			options: map[string]string{"sslmode": ""},
+			want:    "postgresql://app@db/app",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := withDSNOptions(test.dsn, test.options)
+		if err != nil {
+			t.Errorf("withDSNOptions(%q): %v", test.dsn, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("withDSNOptions(%q) = %q, want %q", test.dsn, got, test.want)
+		}
+	}
+}
+
+func TestPostgresConfigFromEnv(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("POSTGRES_USER", "app")
+	t.Setenv("POSTGRES_PASSWORD", "p@ss/word")
+	t.Setenv("POSTGRES_HOST", "db")
+	t.Setenv("POSTGRES_PORT", "5432")
+	t.Setenv("POSTGRES_DB", "app")
+	t.Setenv("DB_SSLMODE", "require")
+	t.Setenv("DATABASE_REPLICA_URL", "postgres://app@replica/app")
+
+	config, err := PostgresConfigFromEnv()
+	if err != nil {
+		t.Fatalf("PostgresConfigFromEnv: %v", err)
+	}
+	if want := "postgresql://app:p%40ss%2Fword@db:5432/app?sslmode=require"; config.DSN != want {
+		t.Errorf("DSN = %q, want %q", config.DSN, want)
+	}
+	if want := "postgres://app@replica/app?sslmode=require"; config.ReplicaDSN != want {
+		t.Errorf("ReplicaDSN = %q, want %q", config.ReplicaDSN, want)
+	}
+
+	t.Setenv("DB_SSLMODE", "sometimes")
+	if _, err := PostgresConfigFromEnv(); err == nil {
+		t.Error("PostgresConfigFromEnv accepted an unknown DB_SSLMODE")
+	}
+}
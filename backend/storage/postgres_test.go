@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/pii"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// TestPostgresStore runs the conformance suite against the database at TEST_DATABASE_URL. Every
+// table is truncated between tests, so never point it at a database you care about. The role must
+// not be a superuser or have BYPASSRLS, or row-level security wouldn't apply to it.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	store, err := OpenPostgresStore(PostgresConfig{DSN: dsn})
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	requireRowLevelSecurity(t, store)
+
+	runConformance(t, func(t *testing.T) Storage {
+		if err := store.db.Exec(`DO $$ DECLARE t text; BEGIN
+			FOR t IN SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename <> 'schema_migrations' LOOP
+				EXECUTE format('TRUNCATE TABLE %I CASCADE', t);
+			END LOOP;
+		END $$`).Error; err != nil {
+			t.Fatalf("truncating the test database: %v", err)
+		}
+		return store
+	})
+}
+
+// TestPostgresEncryption checks personal data is stored encrypted and survives a key rotation.
+func TestPostgresEncryption(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	keyring := func(primary string, index byte) *pii.Keyring {
+		keys, err := pii.NewKeyring(map[string][]byte{
+			"old": bytes.Repeat([]byte{1}, 32),
+			"new": bytes.Repeat([]byte{2}, 32),
+		}, primary, bytes.Repeat([]byte{index}, 32))
+		if err != nil {
+			t.Fatalf("NewKeyring: %v", err)
+		}
+		return keys
+	}
+
+	store, err := OpenPostgresStore(PostgresConfig{DSN: dsn, Keys: keyring("old", 3)})
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	if err := store.db.Exec("TRUNCATE TABLE users CASCADE").Error; err != nil {
+		t.Fatalf("truncating users: %v", err)
+	}
+
+	ctx := context.Background()
+	user := models.NewUser(&models.CreateUserRequest{Email: "ada@example.com", HashedPassword: "hash"})
+	user.FirstName = "Ada"
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	var raw struct {
+		Email, FirstName, EmailIndex string
+		EmailGrams                   pq.StringArray
+	}
+	store.db.Raw("SELECT email, first_name, email_index, email_grams FROM users WHERE id = ?", user.ID).Scan(&raw)
+	if !strings.HasPrefix(raw.Email, "pii:v1:old:") || !strings.HasPrefix(raw.FirstName, "pii:v1:old:") {
+		t.Fatalf("stored email and name = %q, %q, want them sealed with the old key", raw.Email, raw.FirstName)
+	}
+
+	oldIndex, oldGrams := raw.EmailIndex, raw.EmailGrams
+
+	// rotate to the new key, along with the index key
+	store.keys = keyring("new", 4)
+	if updated, err := store.ReencryptUsers(ctx, 10); err != nil || updated != 1 {
+		t.Fatalf("ReencryptUsers = %d, %v, want 1 user updated", updated, err)
+	}
+	store.db.Raw("SELECT email, first_name, email_index, email_grams FROM users WHERE id = ?", user.ID).Scan(&raw)
+	if !strings.HasPrefix(raw.Email, "pii:v1:new:") {
+		t.Errorf("stored email after rotation = %q, want it sealed with the new key", raw.Email)
+	}
+	if raw.EmailIndex == oldIndex || raw.EmailIndex != store.keys.BlindIndex("ada@example.com") {
+		t.Errorf("stored blind index after rotation = %q, want it rewritten with the new index key", raw.EmailIndex)
+	}
+	if slices.Equal(raw.EmailGrams, oldGrams) || !slices.Equal(raw.EmailGrams, store.keys.SearchGrams("ada@example.com")) {
+		t.Errorf("stored grams after rotation = %v, want them rewritten with the new index key", raw.EmailGrams)
+	}
+	if updated, _ := store.ReencryptUsers(ctx, 10); updated != 0 {
+		t.Errorf("ReencryptUsers updated %d users again", updated)
+	}
+
+	found, err := store.GetUserByEmail(ctx, "Ada@Example.com")
+	if err != nil || found.FirstName != "Ada" || found.Email != "ada@example.com" {
+		t.Errorf("GetUserByEmail = %+v, %v, want Ada", found, err)
+	}
+
+	// part of the address finds the encrypted email through its grams
+	page, err := store.ListUsers(ctx, UserQuery{Email: "DA@EX", Sort: UserSortEmail})
+	if err != nil || len(page.Users) != 1 || page.Users[0].ID != user.ID {
+		t.Errorf("ListUsers by part of the email = %v, %v, want Ada", page, err)
+	}
+}
+
+// TestPostgresRowLevelSecurity checks the policies themselves, with queries that go around the
+// store and never set a tenant.
+func TestPostgresRowLevelSecurity(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	store, err := OpenPostgresStore(PostgresConfig{DSN: dsn})
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	requireRowLevelSecurity(t, store)
+
+	ctx := context.Background()
+	user := &models.User{Email: "rls-" + uuid.NewString() + "@example.com"}
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	org := &models.Organization{ID: uuid.New(), Name: "Acme"}
+	if err := store.WithTenant(ctx, Tenant{OrgID: org.ID, UserID: user.ID}, func(tx Storage) error {
+		return tx.CreateOrganization(ctx, org, user.ID)
+	}); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	for _, table := range []string{"organizations", "organization_memberships"} {
+		var count int64
+		if err := store.db.Raw("SELECT count(*) FROM " + table).Scan(&count).Error; err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("%s has %d rows visible without a tenant, want none", table, count)
+		}
+	}
+
+	// the settings are local to the transaction that set them
+	var current string
+	store.db.Raw("SELECT COALESCE(current_setting('app.current_org', true), '')").Scan(&current)
+	if current != "" {
+		t.Errorf("app.current_org leaked out of its transaction as %q", current)
+	}
+
+	err = store.db.Exec("INSERT INTO organizations (id, name, created_at, updated_at) VALUES (?, 'Globex', now(), now())", uuid.New()).Error
+	if !errors.Is(tenantError(err), ErrOutsideTenant) {
+		t.Errorf("inserting without a tenant = %v, want a row-level security violation", err)
+	}
+}
+
+// requireRowLevelSecurity fails the test when the database role would skip the policies, since the
+// tenant tests would then pass or fail for the wrong reason.
+func requireRowLevelSecurity(t *testing.T, store *PostgresStore) {
+	t.Helper()
+
+	bypass, err := store.BypassesRowLevelSecurity(context.Background())
+	if err != nil {
+		t.Fatalf("checking the database role: %v", err)
+	}
+	if bypass {
+		t.Fatal("TEST_DATABASE_URL connects as a role that bypasses row-level security, use one without SUPERUSER or BYPASSRLS")
+	}
+}
@@ -1,105 +1,213 @@
 package storage
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"os"
 	"time"
 
 	"github.com/colecaccamise/go-backend/models"
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
+// Storage is every repository the API needs. PostgresStore is the production implementation and
+// MemoryStore backs tests. Code that only touches one aggregate should depend on its repository.
 type Storage interface {
-	CreateUser(*models.User) error
-	UpdateUser(*models.User) error
-	GetAllUsers() ([]*models.User, error)
-	GetUserByID(uuid.UUID) (*models.User, error)
-	GetUserByEmail(string) (*models.User, error)
-	DeleteUserByID(uuid.UUID) error
+	UserRepository
+	TokenRepository
+	SessionRepository
+	AuditRepository
+	BillingRepository
+	AffiliateRepository
+	UsageRepository
+	EmailRepository
+	NotificationRepository
+	JobRepository
+	OrganizationRepository
+
+	// WithTx runs fn in a transaction. Everything fn writes through the Storage it's given commits
+	// together when fn returns nil and is rolled back when it returns an error. Calling WithTx on
+	// that Storage again nests a savepoint.
+	WithTx(ctx context.Context, fn func(Storage) error) error
+
+	// WithTenant runs fn in a transaction acting for tenant. Tenant tables are only visible
+	// through the Storage fn is given, and only the tenant's rows.
+	WithTenant(ctx context.Context, tenant Tenant, fn func(Storage) error) error
 }
 
-type PostgresStore struct {
-	db *gorm.DB
+// Tenant is who a WithTenant transaction acts for. A user who hasn't picked an organization has
+// no OrgID and only sees the organizations they belong to.
+type Tenant struct {
+	OrgID  uuid.UUID
+	UserID uuid.UUID
 }
 
-func NewPostgresStore() (*PostgresStore, error) {
-	dsn := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s", os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_PORT"), os.Getenv("POSTGRES_DB"))
-
-	maxRetries := 5
-	for i := 0; i < maxRetries; i++ {
-		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if err == nil {
-			fmt.Println("Connected to database!")
-			return &PostgresStore{db: db}, nil
-		}
-		fmt.Printf("Waiting for database to be ready... (%d/%d)\n", i+1, maxRetries)
-		time.Sleep(5 * time.Second)
-	}
-
-	return nil, fmt.Errorf("failed to connect to database after %d retries", maxRetries)
+// UserRepository stores users. UpdateUser only applies if the user's Version is still the stored
+// one and returns ErrConflict otherwise; on success Version is moved on. Names and emails are
+// encrypted at rest and users are found by email through a blind index, ignoring case.
+type UserRepository interface {
+	CreateUser(context.Context, *models.User, ...*models.OutboxEmail) error
+	UpdateUser(context.Context, *models.User, ...*models.OutboxEmail) error
+	ListUsers(context.Context, UserQuery) (*UserPage, error)
+	GetUserByID(context.Context, uuid.UUID) (*models.User, error)
+	// GetUserByIDFromReplica may be behind GetUserByID, so it's only for displaying users.
+	GetUserByIDFromReplica(context.Context, uuid.UUID) (*models.User, error)
+	GetUserByEmail(context.Context, string) (*models.User, error)
+	DeleteUserByID(context.Context, uuid.UUID) error
+	GetUsersDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*models.User, error)
+	PurgeUser(ctx context.Context, user *models.User, tombstone *models.AuditEvent, emails ...*models.OutboxEmail) error
+	GetUsersDueConfirmationReminder(ctx context.Context, createdBefore time.Time, remindersSent int, limit int) ([]*models.User, error)
+	GetExpiredUnconfirmedUsers(ctx context.Context, createdBefore time.Time, limit int) ([]*models.User, error)
+	ExpireUnconfirmedUser(ctx context.Context, user *models.User, tombstone *models.AuditEvent) error
+	ClearStaleEmailChanges(ctx context.Context, requestedBefore time.Time) (int64, error)
+	ReencryptUsers(ctx context.Context, limit int) (int, error)
 }
 
-func (s *PostgresStore) Init() error {
-	return s.CreateUsersTable()
+type TokenRepository interface {
+	CreateApiToken(context.Context, *models.ApiToken) error
+	GetApiTokenByHashedToken(context.Context, string) (*models.ApiToken, error)
+	GetApiTokensByUserID(context.Context, uuid.UUID) ([]*models.ApiToken, error)
+	DeleteApiToken(ctx context.Context, userID uuid.UUID, id uuid.UUID) error
 }
 
-func (s *PostgresStore) CreateUsersTable() error {
-	return s.db.AutoMigrate(&models.User{})
+// SessionRepository revokes sessions. Sessions are stateless JWTs, so revoking moves the user's
+// security version on and every token issued before it is rejected.
+type SessionRepository interface {
+	RevokeSessions(ctx context.Context, userID uuid.UUID, at time.Time) error
 }
 
-func (s *PostgresStore) CreateUser(user *models.User) error {
-	result := s.db.Create(user)
-	return result.Error
+type AuditRepository interface {
+	CreateAuditEvent(context.Context, *models.AuditEvent) error
+	GetAuditEventsByUserID(ctx context.Context, userID uuid.UUID, limit int) ([]*models.AuditEvent, error)
 }
 
-func (s *PostgresStore) UpdateUser(user *models.User) error {
-	return s.db.Model(user).Select("*").Updates(user).Error // explicitly tell gorm to update with zero values
+type BillingRepository interface {
+	GetSubscriptionByUserID(context.Context, uuid.UUID) (*models.Subscription, error)
+	GetSubscriptionByStripeCustomerID(context.Context, string) (*models.Subscription, error)
+	GetSubscriptionsByStatus(context.Context, string) ([]*models.Subscription, error)
+	SaveSubscription(context.Context, *models.Subscription) error
+	SaveInvoice(context.Context, *models.Invoice) error
+	GetInvoiceByID(context.Context, uuid.UUID) (*models.Invoice, error)
+	GetInvoicesByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.Invoice, int64, error)
+	GetBillingProfileByUserID(context.Context, uuid.UUID) (*models.BillingProfile, error)
+	SaveBillingProfile(context.Context, *models.BillingProfile) error
+	CreateCoupon(context.Context, *models.Coupon) error
+	UpdateCoupon(context.Context, *models.Coupon) error
+	GetCouponByID(context.Context, uuid.UUID) (*models.Coupon, error)
+	GetCouponByCode(context.Context, string) (*models.Coupon, error)
+	GetAllCoupons(context.Context) ([]*models.Coupon, error)
+	GetCouponRedemption(ctx context.Context, couponID, userID uuid.UUID) (*models.CouponRedemption, error)
+	ReserveCoupon(context.Context, *models.CouponRedemption) error
+	RedeemCoupon(context.Context, *models.CouponRedemption) error
+	ReleaseCoupon(context.Context, *models.CouponRedemption) error
 }
 
-func (s *PostgresStore) GetUserByID(id uuid.UUID) (*models.User, error) {
-	var user models.User
-	result := s.db.First(&user, id)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("user not found with id %s", id)
-		}
-		return nil, result.Error
-	}
-	return &user, nil
+type AffiliateRepository interface {
+	CreateAffiliate(context.Context, *models.Affiliate) error
+	GetAffiliateByID(context.Context, uuid.UUID) (*models.Affiliate, error)
+	GetAffiliateByCode(context.Context, string) (*models.Affiliate, error)
+	GetAffiliateByUserID(context.Context, uuid.UUID) (*models.Affiliate, error)
+	CreateAffiliateClick(context.Context, *models.AffiliateClick) error
+	CreateAffiliateReferral(context.Context, *models.AffiliateReferral) error
+	GetAffiliateReferralByUserID(context.Context, uuid.UUID) (*models.AffiliateReferral, error)
+	CreateCommission(context.Context, *models.Commission) error
+	GetAffiliateStats(context.Context, uuid.UUID) (*models.AffiliateStats, error)
+	ApproveCommissions(ctx context.Context, createdBefore time.Time) (int64, error)
+	ReverseCommission(ctx context.Context, stripeInvoiceID string, refunded, charged int64) (int64, error)
+	CreatePayoutBatch(ctx context.Context, periodStart, periodEnd time.Time) (*models.PayoutBatch, error)
+	GetPayoutBatches(context.Context) ([]*models.PayoutBatch, error)
+	GetPayoutBatchByID(context.Context, uuid.UUID) (*models.PayoutBatch, error)
+	GetAffiliatePayouts(ctx context.Context, batchID uuid.UUID) ([]*models.AffiliatePayout, error)
+	MarkPayoutBatchPaid(context.Context, *models.PayoutBatch) error
 }
 
-func (s *PostgresStore) GetUserByEmail(email string) (*models.User, error) {
-	var user models.User
-	result := s.db.Where("email = ?", email).First(&user)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("user not found with email %s", email)
-		}
-		return nil, result.Error
-	}
-	return &user, nil
+type UsageRepository interface {
+	RecordUsage(ctx context.Context, userID uuid.UUID, metric string, quantity int64, at time.Time) error
+	GetUsageTotal(ctx context.Context, userID uuid.UUID, metric string, from, to time.Time) (int64, error)
+	GetUsageBuckets(ctx context.Context, userID uuid.UUID, metric string, from, to time.Time) ([]*models.UsageBucket, error)
+	GetUnreportedUsageBuckets(ctx context.Context, limit int) ([]*models.UsageBucket, error)
+	MarkUsageBucketReported(context.Context, *models.UsageBucket) error
+	CreateUsageWarning(context.Context, *models.UsageWarning) (bool, error)
 }
 
-func (s *PostgresStore) GetAllUsers() ([]*models.User, error) {
-	var users []*models.User
-	result := s.db.Find(&users)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return users, nil
+type EmailRepository interface {
+	EnqueueEmails(context.Context, ...*models.OutboxEmail) error
+	ProcessOutbox(ctx context.Context, limit int, deliver func(context.Context, *models.OutboxEmail) error, retryAt func(attempts int) *time.Time) (int, error)
+	GetOutboxEmailsByStatus(ctx context.Context, status string, limit int) ([]*models.OutboxEmail, error)
+	RetryOutboxEmail(context.Context, uuid.UUID) error
+	CreateEmailEvent(context.Context, *models.EmailEvent) (bool, error)
+	SuppressEmail(context.Context, *models.EmailSuppression) error
+	IsEmailSuppressed(context.Context, string) (bool, error)
+	GetEmailSuppressions(context.Context) ([]*models.EmailSuppression, error)
+	DeleteEmailSuppression(context.Context, uuid.UUID) error
 }
 
-func (s *PostgresStore) DeleteUserByID(id uuid.UUID) error {
-	result := s.db.Delete(&models.User{}, id)
-	return result.Error
+type NotificationRepository interface {
+	GetNotificationPreferences(context.Context, uuid.UUID) ([]*models.NotificationPreference, error)
+	SaveNotificationPreferences(ctx context.Context, userID uuid.UUID, email map[string]bool) error
+	IsEmailOptedOut(ctx context.Context, email string, category string) (bool, error)
+	// CreateNotification stores the notification and, once it commits, announces it to every
+	// ListenNotifications.
+	CreateNotification(context.Context, *models.Notification) error
+	// ListenNotifications calls fn with each notification created from now on, by any process
+	// sharing the store, until ctx is done or the listener fails. It always returns an error.
+	ListenNotifications(ctx context.Context, fn func(*models.Notification)) error
+	GetNotifications(ctx context.Context, userID uuid.UUID, limit int) ([]*models.Notification, error)
+	CountUnreadNotifications(context.Context, uuid.UUID) (int64, error)
+	MarkNotificationRead(ctx context.Context, userID uuid.UUID, id uuid.UUID) error
+	MarkAllNotificationsRead(context.Context, uuid.UUID) error
 }
 
-//func (s *PostgresStore) GetAPITokensByUserID(id uuid.UUID) ([]*models.APIToken, error) {
-//	var tokens []*models.APIToken
-//	result := s.db.Where("user_id = ?", id).Find(&tokens)
-//	return tokens, result.Error
-//}
+type JobRepository interface {
+	EnqueueJob(context.Context, *models.Job) error
+	ClaimJob(ctx context.Context, workerID string, staleBefore time.Time) (*models.Job, error)
+	CompleteJob(context.Context, *models.Job) error
+	FailJob(ctx context.Context, job *models.Job, retryAt *time.Time) error
+	GetJobs(ctx context.Context, status string, limit int) ([]*models.Job, error)
+	GetJobCounts(context.Context) (map[string]int64, error)
+	RetryJob(context.Context, uuid.UUID) error
+	DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+	SyncJobSchedule(ctx context.Context, name string, spec string, next time.Time) error
+	GetJobSchedules(context.Context) ([]*models.JobSchedule, error)
+	EnqueueScheduledJob(ctx context.Context, schedule *models.JobSchedule, next time.Time, maxAttempts int) error
+	TryAdvisoryLock(ctx context.Context, key int64) (Lock, error)
+}
+
+// OrganizationRepository stores organizations and their members. These are tenant tables: outside
+// WithTenant reads find nothing and writes fail with ErrOutsideTenant, and inside it writes are
+// limited to the tenant's organization.
+type OrganizationRepository interface {
+	// CreateOrganization creates the tenant's organization, so org.ID must be the tenant's OrgID,
+	// with owner, who must be the tenant's user, as its first member. Every other organization
+	// method needs the tenant's user to be a member of the organization.
+	CreateOrganization(ctx context.Context, org *models.Organization, owner uuid.UUID) error
+	GetOrganization(context.Context, uuid.UUID) (*models.Organization, error)
+	GetOrganizations(context.Context) ([]*models.Organization, error)
+	AddOrganizationMember(context.Context, *models.OrganizationMembership) error
+	GetOrganizationMembers(ctx context.Context, orgID uuid.UUID) ([]*models.OrganizationMembership, error)
+}
+
+// Lock is a cluster-wide lock taken with TryAdvisoryLock.
+type Lock interface {
+	// Alive checks the lock is still held.
+	Alive(ctx context.Context) error
+	Release() error
+}
+
+var (
+	ErrCouponAlreadyRedeemed = errors.New("coupon has already been redeemed")
+	ErrCouponExhausted       = errors.New("coupon has reached its redemption limit")
+	ErrPayoutBatchExists     = errors.New("a payout batch already exists for this period")
+	ErrNoApprovedCommissions = errors.New("there are no approved commissions to pay out")
+	ErrUndeliverable         = errors.New("email is undeliverable")
+	ErrSkipped               = errors.New("email was skipped")
+	ErrUserNotDeleted        = errors.New("user is not deleted")
+	ErrUserConfirmed         = errors.New("user has confirmed their email")
+	ErrConflict              = errors.New("record was changed by another request")
+	ErrInvalidCursor         = errors.New("cursor is invalid")
+	ErrTooManyToSort         = errors.New("too many users match to sort them by email")
+	ErrSearchTooShort        = errors.New("search is too short")
+	ErrOutsideTenant         = errors.New("row is outside the current tenant")
+	ErrOrganizationNotFound  = errors.New("organization not found")
+	ErrSubscriptionNotFound  = errors.New("subscription not found")
+	ErrJobLeaseLost          = errors.New("job was claimed by another worker")
+)
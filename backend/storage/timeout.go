@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const statementTimeoutKey = "storage:statement_timeout"
+
+type statementTimeout struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// registerStatementTimeout bounds every create, query, update, delete and exec to timeout on top
+// of the caller's context, so a slow query hands its connection back instead of holding it until
+// the request ends. Row scans are left to the caller's context because gorm returns the open rows.
+func registerStatementTimeout(db *gorm.DB, timeout time.Duration) error {
+	start := func(db *gorm.DB) {
+		parent := db.Statement.Context
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(statementTimeoutKey, statementTimeout{parent: parent, cancel: cancel})
+	}
+
+	// put the caller's context back, gorm reuses the statement for chained calls
+	finish := func(db *gorm.DB) {
+		if value, ok := db.InstanceGet(statementTimeoutKey); ok {
+			timeout := value.(statementTimeout)
+			timeout.cancel()
+			db.Statement.Context = timeout.parent
+		}
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("storage:start_timeout", start),
+		callbacks.Create().After("gorm:create").Register("storage:finish_timeout", finish),
+		callbacks.Query().Before("gorm:query").Register("storage:start_timeout", start),
+		callbacks.Query().After("gorm:query").Register("storage:finish_timeout", finish),
+		callbacks.Update().Before("gorm:update").Register("storage:start_timeout", start),
+		callbacks.Update().After("gorm:update").Register("storage:finish_timeout", finish),
+		callbacks.Delete().Before("gorm:delete").Register("storage:start_timeout", start),
+		callbacks.Delete().After("gorm:delete").Register("storage:finish_timeout", finish),
+		callbacks.Raw().Before("gorm:raw").Register("storage:start_timeout", start),
+		callbacks.Raw().After("gorm:raw").Register("storage:finish_timeout", finish),
+	)
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/colecaccamise/go-backend/models"
+	"github.com/colecaccamise/go-backend/pii"
+	"github.com/google/uuid"
+)
+
+// User sort orders for ListUsers. Ties are broken by id so every order is total.
+const (
+	UserSortNewest    = "-created_at"
+	UserSortOldest    = "created_at"
+	UserSortEmail     = "email"
+	UserSortEmailDesc = "-email"
+)
+
+var UserSorts = []string{UserSortNewest, UserSortOldest, UserSortEmail, UserSortEmailDesc}
+
+// maxEmailSortUsers is how many matching users ListUsers will sort by email. Emails are encrypted,
+// so the database can't order them and they're sorted once decrypted.
+const maxEmailSortUsers = 10000
+
+// UserQuery filters and pages ListUsers. Empty filters match every user.
+type UserQuery struct {
+	// Email matches users whose email contains it, ignoring case. It must be at least
+	// pii.MinSearchLength characters.
+	Email         string
+	Confirmed     *bool
+	Deleted       *bool
+	Admin         *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// Sort is one of UserSorts and defaults to UserSortNewest.
+	Sort string
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+}
+
+// UserPage is one page of ListUsers. NextCursor is empty on the last page and TotalEstimate is the
+// planner's estimate of how many users match, which is cheap but can be off.
+type UserPage struct {
+	Users         []*models.User
+	NextCursor    string
+	TotalEstimate int64
+}
+
+// userCursor is the sort key of the last user on a page. It carries the sort so a cursor can't be
+// replayed against another order.
+type userCursor struct {
+	Sort  string    `json:"s"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func isEmailSort(sort string) bool {
+	return sort == UserSortEmail || sort == UserSortEmailDesc
+}
+
+// sortValue is the user's key under sort: the lowercased email, or created_at kept to the
+// nanosecond.
+func sortValue(sort string, user *models.User) string {
+	if isEmailSort(sort) {
+		return strings.ToLower(user.Email)
+	}
+	return user.CreatedAt.UTC().Format(time.RFC3339Nano)
+}
+
+func encodeUserCursor(sort string, user *models.User) string {
+	data, _ := json.Marshal(userCursor{Sort: sort, Value: sortValue(sort, user), ID: user.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeUserCursor returns nil for an empty cursor and ErrInvalidCursor for one that wasn't
+// issued for sort.
+func decodeUserCursor(sort string, cursor string) (*userCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var decoded userCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	if !isEmailSort(sort) {
+		if _, err := time.Parse(time.RFC3339Nano, decoded.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return &decoded, nil
+}
+
+// page defaults the sort and limit, capping the limit at 100, and decodes the cursor.
+func (q UserQuery) page() (string, *userCursor, int, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = UserSortNewest
+	}
+	if !slices.Contains(UserSorts, sort) {
+		return "", nil, 0, fmt.Errorf("unknown user sort %q", sort)
+	}
+	if n := utf8.RuneCountInString(strings.TrimSpace(q.Email)); n > 0 && n < pii.MinSearchLength {
+		return "", nil, 0, ErrSearchTooShort
+	}
+
+	limit := q.Limit
+	if limit < 1 {
+		limit = 50
+	}
+	limit = min(limit, 100)
+
+	cursor, err := decodeUserCursor(sort, q.Cursor)
+	return sort, cursor, limit, err
+}
+
+// newUserPage trims the extra user fetched to tell whether there's another page.
+func newUserPage(sort string, users []*models.User, limit int, estimate int64) *UserPage {
+	page := &UserPage{Users: users, TotalEstimate: estimate}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeUserCursor(sort, users[limit-1])
+	}
+	return page
+}
+
+// pageUsers sorts users and cuts out the page after cursor, for stores that order users
+// themselves.
+func pageUsers(sort string, users []*models.User, cursor *userCursor, limit int, estimate int64) *UserPage {
+	compare := func(a, b *userCursor) int {
+		order := strings.Compare(a.Value, b.Value)
+		if !isEmailSort(sort) {
+			at, _ := time.Parse(time.RFC3339Nano, a.Value)
+			bt, _ := time.Parse(time.RFC3339Nano, b.Value)
+			order = at.Compare(bt)
+		}
+		if order == 0 {
+			order = bytes.Compare(a.ID[:], b.ID[:])
+		}
+		if strings.HasPrefix(sort, "-") {
+			return -order
+		}
+		return order
+	}
+	key := func(u *models.User) *userCursor { return &userCursor{Value: sortValue(sort, u), ID: u.ID} }
+
+	slices.SortFunc(users, func(a, b *models.User) int { return compare(key(a), key(b)) })
+	if cursor != nil {
+		users = slices.DeleteFunc(users, func(u *models.User) bool { return compare(key(u), cursor) <= 0 })
+	}
+
+	return newUserPage(sort, take(users, limit+1), limit, estimate)
+}
+
+// containsEmail is the email filter, an empty one matches everyone.
+func containsEmail(user *models.User, substr string) bool {
+	return strings.Contains(strings.ToLower(user.Email), strings.ToLower(strings.TrimSpace(substr)))
+}
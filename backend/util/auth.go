@@ -1,31 +1,14 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"net/http"
 	"os"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func IsAuthenticated(authToken *http.Cookie, apiKey string) (bool, string, error) {
-	if authToken != nil {
-		userId, authTokenType, err := ParseJWT(authToken.Value)
-		if err != nil {
-			return false, "", err
-		}
-	
-		if userId != "" && authTokenType == "auth" {
-			return true, userId, nil
-		}
-	} else if apiKey != "" {
-		// TODO: check api key in db / env
-		return false, "", fmt.Errorf("not implemented")
-	}
-
-	return false, "", fmt.Errorf("unauthorized")
-}
-
 func ParseJWT(authToken string) (userId string, authTokenType string, err error) {
 	token, err := jwt.Parse(authToken, func(token *jwt.Token) (any, error) {
 		return []byte(os.Getenv("JWT_SECRET")), nil
@@ -45,3 +28,9 @@ func ParseJWT(authToken string) (userId string, authTokenType string, err error)
 
 	return userId, authTokenType, nil
 }
+
+// HashApiKey returns the value stored for an api key so raw keys never touch the db.
+func HashApiKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
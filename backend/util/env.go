@@ -0,0 +1,34 @@
+package util
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetEnvInt reads an integer env var, falling back when it's unset or invalid.
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvInts reads a comma separated list of integers, e.g. "1,3,7".
+func GetEnvInts(key string, fallback []int) []int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+
+	var values []int
+	for _, part := range strings.Split(raw, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
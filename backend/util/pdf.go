@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// PDF is a minimal single page pdf writer for text documents like receipts.
+// Coordinates are in points from the bottom left of a US letter page.
+type PDF struct {
+	content bytes.Buffer
+}
+
+const (
+	PDFPageWidth  = 612.0
+	PDFPageHeight = 792.0
+)
+
+func NewPDF() *PDF {
+	return &PDF{}
+}
+
+// Text draws a line of text at x, y. Bold uses Helvetica-Bold instead of Helvetica.
+func (p *PDF) Text(x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePDFText(text))
+}
+
+// TextRight draws text so that it ends at x, using an approximate helvetica glyph width.
+func (p *PDF) TextRight(x, y, size float64, bold bool, text string) {
+	p.Text(x-float64(utf8.RuneCountInString(text))*size*0.5, y, size, bold, text)
+}
+
+// Line draws a thin horizontal or vertical rule.
+func (p *PDF) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.8 w 0.8 0.8 0.8 RG %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Bytes renders the document.
+func (p *PDF) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	writeObject := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	writeObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Contents 4 0 R /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>", PDFPageWidth, PDFPageHeight))
+	writeObject(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// escapePDFText escapes string delimiters and encodes the text in WinAnsiEncoding, the fonts'
+// encoding, writing bytes outside ASCII as octal escapes. Characters WinAnsi doesn't have are
+// replaced with "?".
+func escapePDFText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteRune(' ')
+		case r < 127:
+			b.WriteRune(r)
+		default:
+			if c, ok := charmap.Windows1252.EncodeRune(r); ok && c >= 128 {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
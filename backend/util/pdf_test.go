@@ -0,0 +1,21 @@
+package util
+
+import "testing"
+
+func TestEscapePDFText(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		want string
+	}{
+		"ascii":           {"Total (USD)", `Total \(USD\)`},
+		"latin":           {"Zoë Müller", `Zo\353 M\374ller`},
+		"windows-1252":    {"€5 – “paid”", `\2005 \226 \223paid\224`},
+		"control":         {"a\tb", "a b"},
+		"outside winansi": {"東京 ✓", "?? ?"},
+	}
+	for name, test := range tests {
+		if got := escapePDFText(test.text); got != test.want {
+			t.Errorf("%s: escapePDFText(%q) = %q, want %q", name, test.text, got, test.want)
+		}
+	}
+}
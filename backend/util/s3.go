@@ -16,11 +16,18 @@ import (
 	"image/png"
 	"os"
 	"strings"
+	"time"
 )
 
-func UploadFileToS3(file []byte, uploadThumbnail bool) (filename string, thumbFilename string, err error) {
+// s3Timeout bounds a single S3 call, including loading the AWS config.
+const s3Timeout = 30 * time.Second
+
+func UploadFileToS3(ctx context.Context, file []byte, uploadThumbnail bool) (filename string, thumbFilename string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
 	// aws config
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(os.Getenv("AWS_REGION")),
 	)
 	if err != nil {
@@ -40,7 +47,7 @@ func UploadFileToS3(file []byte, uploadThumbnail bool) (filename string, thumbFi
 	uploader := manager.NewUploader(client)
 
 	// upload original
-	_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
 		Key:    aws.String(filename),
 		Body:   bytes.NewReader(file),
@@ -76,7 +83,7 @@ func UploadFileToS3(file []byte, uploadThumbnail bool) (filename string, thumbFi
 		// upload thumbnail with _thumb suffix
 		thumbFilename = fmt.Sprintf("%s_thumb.%s", key, fileType.Extension)
 
-		_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
 			Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
 			Key:    aws.String(thumbFilename),
 			Body:   bytes.NewReader(thumbnailBuf.Bytes()),
@@ -91,8 +98,10 @@ func UploadFileToS3(file []byte, uploadThumbnail bool) (filename string, thumbFi
 	return filename, "", nil
 }
 
-func DeleteFileFromS3(fileURL string) error {
-	ctx := context.Background()
+func DeleteFileFromS3(ctx context.Context, fileURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("aws config error: %v", err)
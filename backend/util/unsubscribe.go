@@ -0,0 +1,53 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SignUnsubscribeToken returns a token that lets the holder turn off one email category for a
+// user without logging in. Tokens don't expire so links in old emails keep working.
+func SignUnsubscribeToken(userID uuid.UUID, category string) string {
+	payload := fmt.Sprintf("%s:%s", userID, category)
+	return fmt.Sprintf("%s.%s", base64.RawURLEncoding.EncodeToString([]byte(payload)), unsubscribeSignature(payload))
+}
+
+func ParseUnsubscribeToken(token string) (userID uuid.UUID, category string, err error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, "", fmt.Errorf("token invalid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("token invalid")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(unsubscribeSignature(string(payload)))) {
+		return uuid.Nil, "", fmt.Errorf("token invalid")
+	}
+
+	id, category, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return uuid.Nil, "", fmt.Errorf("token invalid")
+	}
+
+	userID, err = uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("token invalid")
+	}
+
+	return userID, category, nil
+}
+
+func unsubscribeSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("unsubscribe:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}